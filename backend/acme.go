@@ -0,0 +1,919 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"system-manager/cloudflare"
+	"system-manager/nginxconf"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme"
+)
+
+// --- ACME (DNS-01) ---
+//
+// Certificates are issued directly against an ACME directory (Let's Encrypt by
+// default) using DNS-01 challenges, so sites behind the Cloudflare proxy and
+// wildcard names work. Keys never leave the state directory, which is 0700;
+// key files are 0600.
+//
+// Configuration (environment):
+//   ACME_DIRECTORY_URL        directory, e.g. https://localhost:14000/dir for Pebble
+//   ACME_EMAIL                account contact, also passed to certbot (none when unset)
+//   ACME_CA_BUNDLE            extra PEM roots trusted for the directory (Pebble's minica)
+//   ACME_DNS_SOLVER           "cloudflare" (default) or "challtestsrv"
+//   ACME_CHALLTESTSRV_URL     pebble-challtestsrv management URL (default http://localhost:8055)
+//   ACME_DNS_RESOLVERS        resolvers polled for TXT propagation (default 1.1.1.1,8.8.8.8)
+//   ACME_PROPAGATION_TIMEOUT  how long to wait for TXT records (default 3m)
+//   ACME_RENEW_BEFORE_DAYS    renew when fewer days remain (default 30)
+//   ACME_RENEW_INTERVAL       how often to check for renewals (default 12h)
+
+const (
+	defaultChallTestSrvURL     = "http://localhost:8055"
+	defaultACMEResolvers       = "1.1.1.1,8.8.8.8"
+	defaultPropagationTimeout  = 3 * time.Minute
+	defaultACMERenewBeforeDays = 30
+	defaultACMERenewInterval   = 12 * time.Hour
+	acmeIssueTimeout           = 10 * time.Minute
+	propagationPollInterval    = 5 * time.Second
+)
+
+var acmeDomainRegex = regexp.MustCompile(`^(\*\.)?(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// acmeMu serializes issuance and renewal so two orders never race on the same files.
+var acmeMu sync.Mutex
+
+type ACMECertificate struct {
+	Name        string    `json:"name"`
+	Domains     []string  `json:"domains"`
+	KeyType     string    `json:"key_type"`
+	Directory   string    `json:"directory"`
+	CertPath    string    `json:"cert_path"`
+	KeyPath     string    `json:"key_path"`
+	IssuedAt    time.Time `json:"issued_at"`
+	NotAfter    time.Time `json:"not_after"`
+	AutoRenew   bool      `json:"auto_renew"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type acmeAccountInfo struct {
+	Directory string `json:"directory"`
+	Email     string `json:"email,omitempty"`
+	URI       string `json:"uri"`
+}
+
+func acmeDir() string {
+	return filepath.Join(stateDir, "acme")
+}
+
+func acmeCertDir(name string) string {
+	return filepath.Join(acmeDir(), "certificates", name)
+}
+
+func acmeDirectoryURL() string {
+	if url := os.Getenv("ACME_DIRECTORY_URL"); url != "" {
+		return url
+	}
+	return acme.LetsEncryptURL
+}
+
+func acmeEmail() string {
+	return os.Getenv("ACME_EMAIL")
+}
+
+// certbotEmailArgs passes the configured contact to certbot.
+func certbotEmailArgs() []string {
+	if email := acmeEmail(); email != "" {
+		return []string{"-m", email}
+	}
+	return []string{"--register-unsafely-without-email"}
+}
+
+// acmeCertName names a certificate after its first domain; a wildcard and its
+// apex share the same name.
+func acmeCertName(domains []string) string {
+	return strings.TrimPrefix(strings.ToLower(domains[0]), "*.")
+}
+
+// writeSecretFile atomically writes data readable only by the owner.
+func writeSecretFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func marshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type", path)
+	}
+	return signer, nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	if keyType == "rsa" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// acmeHTTPClient trusts ACME_CA_BUNDLE in addition to the system roots.
+func acmeHTTPClient() (*http.Client, error) {
+	bundle := os.Getenv("ACME_CA_BUNDLE")
+	if bundle == "" {
+		return &http.Client{Timeout: 30 * time.Second}, nil
+	}
+	pemData, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("ACME_CA_BUNDLE: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("ACME_CA_BUNDLE: no certificates found")
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+// acmeAccount returns a client registered with the directory, creating the
+// account key on first use.
+func acmeAccount(ctx context.Context) (*acme.Client, acmeAccountInfo, error) {
+	info := acmeAccountInfo{Directory: acmeDirectoryURL(), Email: acmeEmail()}
+	httpClient, err := acmeHTTPClient()
+	if err != nil {
+		return nil, info, err
+	}
+
+	keyPath := filepath.Join(acmeDir(), "account.key")
+	key, err := loadPrivateKey(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = generateKey("ecdsa")
+		if err != nil {
+			return nil, info, err
+		}
+		data, err := marshalPrivateKey(key)
+		if err != nil {
+			return nil, info, err
+		}
+		if err := writeSecretFile(keyPath, data); err != nil {
+			return nil, info, err
+		}
+	} else if err != nil {
+		return nil, info, err
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: info.Directory, HTTPClient: httpClient, UserAgent: "system-manager"}
+	account := &acme.Account{}
+	if info.Email != "" {
+		account.Contact = []string{"mailto:" + info.Email}
+	}
+	acct, err := client.Register(ctx, account, acme.AcceptTOS)
+	if errors.Is(err, acme.ErrAccountAlreadyExists) {
+		acct, err = client.GetReg(ctx, "")
+	}
+	if err != nil {
+		return nil, info, fmt.Errorf("ACME account registration failed: %w", err)
+	}
+	info.URI = acct.URI
+
+	data, _ := json.MarshalIndent(info, "", "  ")
+	if err := writeSecretFile(filepath.Join(acmeDir(), "account.json"), data); err != nil {
+		fmt.Printf("ACME: failed to save account info: %v\n", err)
+	}
+	return client, info, nil
+}
+
+// --- DNS-01 Solvers ---
+
+// dns01Solver publishes a TXT record and returns a function that removes it.
+type dns01Solver interface {
+	Present(ctx context.Context, fqdn, value string) (func(context.Context) error, error)
+	// WaitPropagation reports whether public resolvers must be polled before
+	// the challenge is accepted.
+	WaitPropagation() bool
+}
+
+func newDNS01Solver() (dns01Solver, error) {
+	switch solver := os.Getenv("ACME_DNS_SOLVER"); solver {
+	case "", "cloudflare":
+		if CloudflareAPIToken == "" {
+			return nil, errors.New("Cloudflare credentials not configured")
+		}
+		return cloudflareDNS01Solver{}, nil
+	case "challtestsrv":
+		url := os.Getenv("ACME_CHALLTESTSRV_URL")
+		if url == "" {
+			url = defaultChallTestSrvURL
+		}
+		return challTestSrvSolver{url: strings.TrimSuffix(url, "/")}, nil
+	default:
+		return nil, fmt.Errorf("unknown ACME_DNS_SOLVER %q", solver)
+	}
+}
+
+type cloudflareDNS01Solver struct{}
+
+func (cloudflareDNS01Solver) Present(ctx context.Context, fqdn, value string) (func(context.Context) error, error) {
+	zoneID, err := resolveCloudflareZone(ctx, "", fqdn)
+	if err != nil {
+		return nil, err
+	}
+	record, err := cfClient.CreateDNSRecord(ctx, zoneID, cloudflare.DNSRecord{Type: "TXT", Name: fqdn, Content: value, TTL: 120})
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		return cfClient.DeleteDNSRecord(ctx, zoneID, record.ID)
+	}, nil
+}
+
+func (cloudflareDNS01Solver) WaitPropagation() bool { return true }
+
+// challTestSrvSolver drives pebble-challtestsrv, which Pebble queries directly.
+type challTestSrvSolver struct {
+	url string
+}
+
+func (s challTestSrvSolver) post(ctx context.Context, path string, payload interface{}) error {
+	data, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challtestsrv %s returned %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (s challTestSrvSolver) Present(ctx context.Context, fqdn, value string) (func(context.Context) error, error) {
+	host := fqdn + "."
+	if err := s.post(ctx, "/set-txt", map[string]string{"host": host, "value": value}); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		return s.post(ctx, "/clear-txt", map[string]string{"host": host})
+	}, nil
+}
+
+func (challTestSrvSolver) WaitPropagation() bool { return false }
+
+// dnsPropagationTimeout reads ACME_PROPAGATION_TIMEOUT, which also bounds the
+// wait for site records during provisioning.
+func dnsPropagationTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ACME_PROPAGATION_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return defaultPropagationTimeout
+}
+
+// waitForTXT polls every resolver until each one returns value for fqdn.
+func waitForTXT(ctx context.Context, fqdn, value string) error {
+	timeout := dnsPropagationTimeout()
+	resolvers := os.Getenv("ACME_DNS_RESOLVERS")
+	if resolvers == "" {
+		resolvers = defaultACMEResolvers
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	pending := strings.Split(resolvers, ",")
+	for {
+		var remaining []string
+		for _, server := range pending {
+			server = strings.TrimSpace(server)
+			records, _ := dnsResolverFor(server, "4").LookupTXT(ctx, fqdn)
+			if !containsString(records, value) {
+				remaining = append(remaining, server)
+			}
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+		pending = remaining
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("TXT record %s not visible on %s after %s", fqdn, strings.Join(pending, ", "), timeout)
+		case <-time.After(propagationPollInterval):
+		}
+	}
+}
+
+// --- Issuance ---
+
+func validateACMEDomains(domains []string) error {
+	if len(domains) == 0 {
+		return errors.New("at least one domain is required")
+	}
+	if len(domains) > 100 {
+		return errors.New("at most 100 domains per certificate")
+	}
+	for _, d := range domains {
+		if !acmeDomainRegex.MatchString(d) {
+			return fmt.Errorf("invalid domain %q", d)
+		}
+	}
+	return nil
+}
+
+// issueACMECertificate runs a complete order: all DNS-01 records are published
+// first (a wildcard and its apex share one TXT name), then every challenge is
+// accepted, and the records are always removed afterwards.
+func issueACMECertificate(ctx context.Context, domains []string, keyType string) (*ACMECertificate, error) {
+	if err := validateACMEDomains(domains); err != nil {
+		return nil, err
+	}
+	if keyType == "" {
+		keyType = "ecdsa"
+	}
+	if keyType != "ecdsa" && keyType != "rsa" {
+		return nil, errors.New("key_type must be ecdsa or rsa")
+	}
+	solver, err := newDNS01Solver()
+	if err != nil {
+		return nil, err
+	}
+
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+
+	client, account, err := acmeAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+
+	type pendingChallenge struct {
+		authzURL string
+		chal     *acme.Challenge
+	}
+	var pending []pendingChallenge
+	var cleanups []func(context.Context) error
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, cleanup := range cleanups {
+			if err := cleanup(cleanupCtx); err != nil {
+				fmt.Printf("ACME: failed to remove challenge record: %v\n", err)
+			}
+		}
+	}()
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+				break
+			}
+		}
+		if chal == nil {
+			return nil, fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+		cleanup, err := solver.Present(ctx, fqdn, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish TXT record for %s: %w", fqdn, err)
+		}
+		cleanups = append(cleanups, cleanup)
+		if solver.WaitPropagation() {
+			if err := waitForTXT(ctx, fqdn, value); err != nil {
+				return nil, err
+			}
+		}
+		pending = append(pending, pendingChallenge{authzURL: authzURL, chal: chal})
+	}
+
+	for _, p := range pending {
+		if _, err := client.Accept(ctx, p.chal); err != nil {
+			return nil, fmt.Errorf("failed to accept challenge: %w", err)
+		}
+	}
+	for _, p := range pending {
+		if _, err := client.WaitAuthorization(ctx, p.authzURL); err != nil {
+			return nil, fmt.Errorf("authorization failed: %w", err)
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("order failed: %w", err)
+	}
+
+	certKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.ToLower(domains[0])},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var fullchain bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&fullchain, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyPEM, err := marshalPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+
+	name := acmeCertName(domains)
+	dir := acmeCertDir(name)
+	cert := &ACMECertificate{
+		Name:      name,
+		Domains:   domains,
+		KeyType:   keyType,
+		Directory: account.Directory,
+		CertPath:  filepath.Join(dir, "fullchain.pem"),
+		KeyPath:   filepath.Join(dir, "privkey.pem"),
+		IssuedAt:  time.Now(),
+		NotAfter:  leaf.NotAfter,
+		AutoRenew: true,
+	}
+	if previous, err := loadACMECertificate(name); err == nil {
+		cert.AutoRenew = previous.AutoRenew
+	}
+	// Key first: nginx must never see a certificate without its key
+	if err := writeSecretFile(cert.KeyPath, keyPEM); err != nil {
+		return nil, err
+	}
+	if err := writeSecretFile(cert.CertPath, fullchain.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := saveACMECertificate(cert); err != nil {
+		return nil, err
+	}
+	fmt.Printf("ACME: issued certificate %s for %s (expires %s)\n", name, strings.Join(domains, ", "), leaf.NotAfter.Format(time.RFC3339))
+	return cert, nil
+}
+
+func saveACMECertificate(cert *ACMECertificate) error {
+	data, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(filepath.Join(acmeCertDir(cert.Name), "meta.json"), data)
+}
+
+func loadACMECertificate(name string) (*ACMECertificate, error) {
+	data, err := os.ReadFile(filepath.Join(acmeCertDir(name), "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cert ACMECertificate
+	if err := json.Unmarshal(data, &cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func listACMECertificates() []*ACMECertificate {
+	var certs []*ACMECertificate
+	files, _ := filepath.Glob(filepath.Join(acmeDir(), "certificates", "*", "meta.json"))
+	sort.Strings(files)
+	for _, f := range files {
+		if cert, err := loadACMECertificate(filepath.Base(filepath.Dir(f))); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+func revokeACMECertificate(ctx context.Context, cert *ACMECertificate) error {
+	key, err := loadPrivateKey(cert.KeyPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(cert.CertPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("certificate file is empty")
+	}
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+	client, _, err := acmeAccount(ctx)
+	if err != nil {
+		return err
+	}
+	return client.RevokeCert(ctx, key, block.Bytes, acme.CRLReasonCessationOfOperation)
+}
+
+// siteTLSContent points a site config at a certificate. When the site already
+// has ssl_certificate directives only the paths are replaced. Otherwise every
+// server block listening on plain port 80 is moved to 443 and a server block
+// for the same names takes over port 80 to redirect to HTTPS, as certbot
+// --nginx --redirect does. Blocks on other ports are left alone.
+func siteTLSContent(current []byte, certPath, keyPath string) ([]byte, error) {
+	certRe := regexp.MustCompile(`(?m)^([ \t]*)ssl_certificate[ \t]+[^;]*;`)
+	keyRe := regexp.MustCompile(`(?m)^([ \t]*)ssl_certificate_key[ \t]+[^;]*;`)
+	if certRe.Match(current) {
+		updated := certRe.ReplaceAll(current, []byte("${1}ssl_certificate "+certPath+";"))
+		return keyRe.ReplaceAll(updated, []byte("${1}ssl_certificate_key "+keyPath+";")), nil
+	}
+
+	directives, err := nginxconf.Parse(string(current))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(current), "\n")
+	replace := map[int]string{} // Line index to its new text
+	insert := map[int]string{}  // Line index to the text inserted before it
+	var redirects []string
+	listenRe := regexp.MustCompile(`listen[ \t]+[^;]*;`)
+
+	for _, server := range nginxconf.Find(directives, "server") {
+		names := nginxconf.First(server.Block, "server_name")
+		if len(server.Block) == 0 || len(names) == 0 {
+			continue
+		}
+		var httpListens [][]string
+		tlsListens := ""
+		listens := nginxconf.Find(server.Block, "listen")
+		for _, d := range listens {
+			l := nginxconf.ParseListen(d.Args)
+			if l.Socket != "" || l.SSL || l.Port != 80 {
+				continue
+			}
+			httpListens = append(httpListens, d.Args)
+			i := d.Line - 1
+			if _, ok := replace[i]; !ok {
+				replace[i] = lines[i]
+			}
+			replace[i] = listenRe.ReplaceAllLiteralString(replace[i], "listen "+strings.Join(tlsListenArgs(d.Args), " ")+";")
+		}
+		if len(listens) == 0 {
+			// Without a listen directive nginx serves the block on port 80
+			httpListens = [][]string{{"80"}}
+			tlsListens = "listen 443 ssl;\n"
+		}
+		if len(httpListens) == 0 {
+			continue
+		}
+
+		first := server.Block[0].Line - 1
+		if server.Block[0].Line == server.Line {
+			return nil, fmt.Errorf("server block on line %d must have its directives on separate lines", server.Line)
+		}
+		indent := lines[first][:len(lines[first])-len(strings.TrimLeft(lines[first], " \t"))]
+		block := tlsListens + "ssl_certificate " + certPath + ";\nssl_certificate_key " + keyPath + ";\n"
+		for _, line := range strings.Split(strings.TrimSuffix(block, "\n"), "\n") {
+			insert[first] += indent + line + "\n"
+		}
+
+		redirect := "\nserver {\n"
+		for _, args := range httpListens {
+			redirect += "    listen " + strings.Join(args, " ") + ";\n"
+		}
+		redirect += "    server_name " + strings.Join(names, " ") + ";\n"
+		redirect += "    return 301 https://$host$request_uri;\n}\n"
+		redirects = append(redirects, redirect)
+	}
+	if len(redirects) == 0 {
+		return nil, errors.New("site has no server block listening on port 80")
+	}
+
+	var out strings.Builder
+	for i, line := range lines {
+		out.WriteString(insert[i])
+		if r, ok := replace[i]; ok {
+			line = r
+		}
+		out.WriteString(line)
+		if i < len(lines)-1 {
+			out.WriteString("\n")
+		}
+	}
+	result := strings.TrimRight(out.String(), "\n") + "\n"
+	return []byte(result + strings.Join(redirects, "")), nil
+}
+
+// tlsListenArgs turns the arguments of a port 80 listen directive into the
+// equivalent 443 listener.
+func tlsListenArgs(args []string) []string {
+	addr := "443"
+	if host, _, err := stdnet.SplitHostPort(args[0]); err == nil {
+		addr = stdnet.JoinHostPort(strings.Trim(host, "[]"), "443")
+	} else if _, err := strconv.Atoi(args[0]); err != nil {
+		addr = stdnet.JoinHostPort(strings.Trim(args[0], "[]"), "443")
+	}
+	return append([]string{addr, "ssl"}, args[1:]...)
+}
+
+// enableSiteTLS installs a certificate into a site, see siteTLSContent.
+func enableSiteTLS(site, certPath, keyPath, author string) (string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	sitePath := filepath.Join(nginxPath, site)
+	current, err := os.ReadFile(sitePath)
+	if err != nil {
+		return "", fmt.Errorf("site %s does not exist", site)
+	}
+	updated, err := siteTLSContent(current, certPath, keyPath)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(updated, current) {
+		return "", nil
+	}
+
+	output, err := applyNginxChangesLocked([]nginxChange{{Path: sitePath, Content: updated}})
+	if errors.Is(err, errNginxValidation) || (err != nil && !errors.Is(err, errNginxReload)) {
+		return output, err
+	}
+	if _, histErr := recordNginxRevision(site, current, updated, author, "TLS certificate installed", "tls"); histErr != nil {
+		fmt.Printf("Nginx: failed to record revision for %s: %v\n", site, histErr)
+	}
+	return output, err
+}
+
+// startACMERenewer renews certificates close to expiry and reloads nginx so
+// the new files are picked up.
+func startACMERenewer() {
+	interval := defaultACMERenewInterval
+	if d, err := time.ParseDuration(os.Getenv("ACME_RENEW_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	before := defaultACMERenewBeforeDays
+	if days, err := strconv.Atoi(os.Getenv("ACME_RENEW_BEFORE_DAYS")); err == nil && days > 0 {
+		before = days
+	}
+
+	go func() {
+		for {
+			renewed := false
+			for _, cert := range listACMECertificates() {
+				if !cert.AutoRenew || time.Until(cert.NotAfter) > time.Duration(before)*24*time.Hour {
+					continue
+				}
+				if renewACMECertificate(cert) == nil {
+					renewed = true
+				}
+			}
+			if renewed {
+				if unlock, err := lockNginxConfig(); err == nil {
+					if err := nginxReload(); err != nil {
+						fmt.Printf("ACME: %v\n", err)
+					}
+					unlock()
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func renewACMECertificate(cert *ACMECertificate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeIssueTimeout)
+	defer cancel()
+	_, err := issueACMECertificate(ctx, cert.Domains, cert.KeyType)
+	if err != nil {
+		fmt.Printf("ACME: renewal of %s failed: %v\n", cert.Name, err)
+		cert.LastAttempt = time.Now()
+		cert.LastError = err.Error()
+		saveACMECertificate(cert)
+	}
+	return err
+}
+
+// --- ACME Handlers ---
+
+type ACMEIssueRequest struct {
+	Domains   []string `json:"domains"`
+	KeyType   string   `json:"key_type"` // "ecdsa" (default) or "rsa"
+	Site      string   `json:"site"`     // Optional nginx site to install the certificate into
+	AutoRenew *bool    `json:"auto_renew"`
+}
+
+func listACMECertificatesHandler(c *gin.Context) {
+	certs := listACMECertificates()
+	if certs == nil {
+		certs = []*ACMECertificate{}
+	}
+	account := acmeAccountInfo{Directory: acmeDirectoryURL(), Email: acmeEmail()}
+	if data, err := os.ReadFile(filepath.Join(acmeDir(), "account.json")); err == nil {
+		json.Unmarshal(data, &account)
+	}
+	c.JSON(http.StatusOK, gin.H{"account": account, "certificates": certs})
+}
+
+func issueACMECertificateHandler(c *gin.Context) {
+	var req ACMEIssueRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := validateACMEDomains(req.Domains); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Site != "" && !validNginxFileName(req.Site) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid site name"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), acmeIssueTimeout)
+	defer cancel()
+	cert, err := issueACMECertificate(ctx, req.Domains, req.KeyType)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if req.AutoRenew != nil && !*req.AutoRenew {
+		cert.AutoRenew = false
+		saveACMECertificate(cert)
+	}
+
+	response := gin.H{"status": "success", "message": "Certificate issued", "certificate": cert}
+	if req.Site != "" {
+		if output, err := enableSiteTLS(req.Site, cert.CertPath, cert.KeyPath, requestUser(c)); err != nil {
+			response["status"] = "warning"
+			response["message"] = "Certificate issued but installing it failed: " + err.Error()
+			response["details"] = output
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// requestACMECertificate loads the certificate named by :name. The name is
+// checked before it is used as a path.
+func requestACMECertificate(c *gin.Context) (*ACMECertificate, bool) {
+	name := c.Param("name")
+	if !domainRegex.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certificate name"})
+		return nil, false
+	}
+	cert, err := loadACMECertificate(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return nil, false
+	}
+	return cert, true
+}
+
+func renewACMECertificateHandler(c *gin.Context) {
+	cert, ok := requestACMECertificate(c)
+	if !ok {
+		return
+	}
+	if err := renewACMECertificate(cert); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"status": "success", "message": "Certificate renewed"}
+	if unlock, err := lockNginxConfig(); err == nil {
+		if err := nginxReload(); err != nil {
+			response = gin.H{"status": "warning", "message": "Certificate renewed but nginx reload failed", "details": err.Error()}
+		}
+		unlock()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func installACMECertificateHandler(c *gin.Context) {
+	var req struct {
+		Site string `json:"site"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	cert, ok := requestACMECertificate(c)
+	if !ok {
+		return
+	}
+	if !validNginxFileName(req.Site) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid site name"})
+		return
+	}
+
+	output, err := enableSiteTLS(req.Site, cert.CertPath, cert.KeyPath, requestUser(c))
+	if errors.Is(err, errNginxValidation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nginx validation failed", "details": output})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Certificate installed & Reloaded"})
+}
+
+func deleteACMECertificateHandler(c *gin.Context) {
+	cert, ok := requestACMECertificate(c)
+	if !ok {
+		return
+	}
+	for _, info := range listCertificates(certWarnDays()) {
+		if info.Path == cert.CertPath && len(info.UsedBy) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Certificate is used by " + strings.Join(info.UsedBy, ", ")})
+			return
+		}
+	}
+	if c.Query("revoke") == "true" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+		defer cancel()
+		if err := revokeACMECertificate(ctx, cert); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Revocation failed: " + err.Error()})
+			return
+		}
+	}
+	if err := os.RemoveAll(acmeCertDir(c.Param("name"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Certificate deleted"})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSiteTLSContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		site    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "http block moves to 443 with a redirect",
+			site: `server {
+    listen 80;
+    listen [::]:80;
+    server_name example.com www.example.com;
+    root /srv/www;
+}
+`,
+			want: `server {
+    ssl_certificate /c.pem;
+    ssl_certificate_key /k.pem;
+    listen 443 ssl;
+    listen [::]:443 ssl;
+    server_name example.com www.example.com;
+    root /srv/www;
+}
+
+server {
+    listen 80;
+    listen [::]:80;
+    server_name example.com www.example.com;
+    return 301 https://$host$request_uri;
+}
+`,
+		},
+		{
+			name: "implicit port 80 and listen flags",
+			site: `server {
+    server_name a.example.com;
+}
+server {
+    listen 127.0.0.1:80 default_server;
+    server_name b.example.com;
+}
+`,
+			want: `server {
+    listen 443 ssl;
+    ssl_certificate /c.pem;
+    ssl_certificate_key /k.pem;
+    server_name a.example.com;
+}
+server {
+    ssl_certificate /c.pem;
+    ssl_certificate_key /k.pem;
+    listen 127.0.0.1:443 ssl default_server;
+    server_name b.example.com;
+}
+
+server {
+    listen 80;
+    server_name a.example.com;
+    return 301 https://$host$request_uri;
+}
+
+server {
+    listen 127.0.0.1:80 default_server;
+    server_name b.example.com;
+    return 301 https://$host$request_uri;
+}
+`,
+		},
+		{
+			name: "other ports are left alone",
+			site: `server {
+    listen 8080;
+    server_name internal.example.com;
+}
+server {
+    listen 80;
+    server_name example.com;
+}
+`,
+			want: `server {
+    listen 8080;
+    server_name internal.example.com;
+}
+server {
+    ssl_certificate /c.pem;
+    ssl_certificate_key /k.pem;
+    listen 443 ssl;
+    server_name example.com;
+}
+
+server {
+    listen 80;
+    server_name example.com;
+    return 301 https://$host$request_uri;
+}
+`,
+		},
+		{
+			name: "existing certificate paths are replaced",
+			site: `server {
+    listen 443 ssl;
+    server_name example.com;
+    ssl_certificate /old/fullchain.pem;
+    ssl_certificate_key /old/privkey.pem;
+}
+`,
+			want: `server {
+    listen 443 ssl;
+    server_name example.com;
+    ssl_certificate /c.pem;
+    ssl_certificate_key /k.pem;
+}
+`,
+		},
+		{
+			name:    "no http server block",
+			site:    "server {\n    listen 8443 ssl;\n    server_name example.com;\n}\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := siteTLSContent([]byte(tt.site), "/c.pem", "/k.pem")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("siteTLSContent succeeded:\n%s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("siteTLSContent: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("siteTLSContent\n got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestACMEIssueWithPebble runs a full order against Pebble and
+// pebble-challtestsrv. Start them with
+//
+//	pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053
+//	pebble-challtestsrv -defaultIPv6 "" -defaultIPv4 127.0.0.1
+//
+// and set PEBBLE_DIRECTORY_URL (https://localhost:14000/dir) and
+// PEBBLE_CA_BUNDLE (Pebble's test/certs/pebble.minica.pem).
+// PEBBLE_CHALLTESTSRV_URL defaults to http://localhost:8055.
+func TestACMEIssueWithPebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY_URL not set")
+	}
+	t.Setenv("ACME_DIRECTORY_URL", directory)
+	t.Setenv("ACME_CA_BUNDLE", os.Getenv("PEBBLE_CA_BUNDLE"))
+	t.Setenv("ACME_CHALLTESTSRV_URL", os.Getenv("PEBBLE_CHALLTESTSRV_URL"))
+	t.Setenv("ACME_DNS_SOLVER", "challtestsrv")
+	t.Setenv("ACME_EMAIL", "")
+
+	previousStateDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = previousStateDir })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	domains := []string{"example.test", "*.example.test"}
+	cert, err := issueACMECertificate(ctx, domains, "")
+	if err != nil {
+		t.Fatalf("issueACMECertificate: %v", err)
+	}
+	if cert.Name != "example.test" {
+		t.Errorf("certificate name = %q, want example.test", cert.Name)
+	}
+
+	data, err := os.ReadFile(cert.CertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatal("certificate file holds no PEM block")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := append([]string(nil), leaf.DNSNames...)
+	sort.Strings(names)
+	if strings.Join(names, ",") != "*.example.test,example.test" {
+		t.Errorf("certificate names = %v, want %v", leaf.DNSNames, domains)
+	}
+	if _, err := loadPrivateKey(cert.KeyPath); err != nil {
+		t.Errorf("loadPrivateKey: %v", err)
+	}
+	for _, path := range []string{cert.CertPath, cert.KeyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("stat %s: %v", path, err)
+		} else if info.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", path, info.Mode().Perm())
+		}
+	}
+
+	// A second order reuses the account and replaces the files
+	if err := renewACMECertificate(cert); err != nil {
+		t.Fatalf("renewACMECertificate: %v", err)
+	}
+	if listed := listACMECertificates(); len(listed) != 1 || listed[0].Name != cert.Name {
+		t.Errorf("listACMECertificates = %v", listed)
+	}
+	if err := revokeACMECertificate(ctx, cert); err != nil {
+		t.Errorf("revokeACMECertificate: %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Certificates ---
+
+const (
+	letsencryptLiveDir       = "/etc/letsencrypt/live"
+	defaultCertWarnDays      = 14
+	defaultCertCheckInterval = 12 * time.Hour
+	maxPEMFileBytes          = 1 << 20
+)
+
+type CertificateInfo struct {
+	Name         string    `json:"name,omitempty"`
+	Source       string    `json:"source"` // "certbot", "acme", "nginx" or "file"
+	Path         string    `json:"path"`
+	KeyPath      string    `json:"key_path,omitempty"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	Domains      []string  `json:"domains"`
+	IPAddresses  []string  `json:"ip_addresses,omitempty"`
+	Serial       string    `json:"serial"`
+	Fingerprint  string    `json:"fingerprint_sha256"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	DaysLeft     int       `json:"days_left"`
+	Expired      bool      `json:"expired"`
+	ExpiringSoon bool      `json:"expiring_soon"`
+	ChainLength  int       `json:"chain_length"`
+	SelfSigned   bool      `json:"self_signed"`
+	UsedBy       []string  `json:"used_by,omitempty"` // nginx sites referencing the certificate
+	Error        string    `json:"error,omitempty"`
+}
+
+type CertificateWarning struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Domains  []string `json:"domains"`
+	DaysLeft int      `json:"days_left"`
+	Message  string   `json:"message"`
+}
+
+// certWarnDays is the expiry threshold, from CERT_EXPIRY_WARN_DAYS.
+func certWarnDays() int {
+	if days, err := strconv.Atoi(os.Getenv("CERT_EXPIRY_WARN_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return defaultCertWarnDays
+}
+
+// inspectPEMFile parses the certificates in a PEM file and describes the
+// first one (the leaf for fullchain files). Private keys are never returned.
+func inspectPEMFile(path string, warnDays int) (CertificateInfo, error) {
+	info := CertificateInfo{Source: "file", Path: path, Domains: []string{}}
+	st, err := os.Stat(path)
+	if err != nil {
+		return info, err
+	}
+	if !st.Mode().IsRegular() || st.Size() > maxPEMFileBytes {
+		return info, errors.New("not a regular PEM file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return info, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return info, errors.New("no certificate found in file")
+	}
+
+	describeCertificate(&info, certs[0], warnDays)
+	info.ChainLength = len(certs)
+	return info, nil
+}
+
+func describeCertificate(info *CertificateInfo, cert *x509.Certificate, warnDays int) {
+	info.Subject = cert.Subject.String()
+	info.Issuer = cert.Issuer.String()
+	info.Domains = append([]string{}, cert.DNSNames...)
+	if len(info.Domains) == 0 && cert.Subject.CommonName != "" {
+		info.Domains = []string{cert.Subject.CommonName}
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	info.Serial = fmt.Sprintf("%X", cert.SerialNumber)
+	sum := sha256.Sum256(cert.Raw)
+	info.Fingerprint = hex.EncodeToString(sum[:])
+	info.NotBefore = cert.NotBefore
+	info.NotAfter = cert.NotAfter
+	info.SelfSigned = cert.Subject.String() == cert.Issuer.String()
+
+	left := time.Until(cert.NotAfter)
+	info.DaysLeft = int(left.Hours() / 24)
+	info.Expired = left <= 0
+	info.ExpiringSoon = !info.Expired && info.DaysLeft < warnDays
+}
+
+// listCertificates collects certbot and ACME certificates and any other
+// certificate referenced by an nginx site.
+func listCertificates(warnDays int) []CertificateInfo {
+	certs := []CertificateInfo{}
+	byPath := make(map[string]int)
+
+	dirs, _ := filepath.Glob(filepath.Join(letsencryptLiveDir, "*"))
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "fullchain.pem")
+		info, err := inspectPEMFile(path, warnDays)
+		if err != nil {
+			info.Error = err.Error()
+		}
+		info.Name = filepath.Base(dir)
+		info.Source = "certbot"
+		info.KeyPath = filepath.Join(dir, "privkey.pem")
+		byPath[path] = len(certs)
+		certs = append(certs, info)
+	}
+
+	for _, acmeCert := range listACMECertificates() {
+		info, err := inspectPEMFile(acmeCert.CertPath, warnDays)
+		if err != nil {
+			info.Error = err.Error()
+		}
+		info.Name = acmeCert.Name
+		info.Source = "acme"
+		info.KeyPath = acmeCert.KeyPath
+		byPath[acmeCert.CertPath] = len(certs)
+		certs = append(certs, info)
+	}
+
+	sites, _ := loadNginxSites()
+	for _, site := range sites {
+		for _, server := range site.Servers {
+			path := server.SSLCertificate
+			if path == "" || strings.Contains(path, "$") {
+				continue
+			}
+			if filepath.Base(path) == "cert.pem" && strings.HasPrefix(path, letsencryptLiveDir) {
+				path = filepath.Join(filepath.Dir(path), "fullchain.pem")
+			}
+			i, known := byPath[path]
+			if !known {
+				info, err := inspectPEMFile(path, warnDays)
+				if err != nil {
+					info.Error = err.Error()
+				}
+				info.Name = site.File
+				info.Source = "nginx"
+				info.KeyPath = server.SSLCertificateKey
+				i = len(certs)
+				byPath[path] = i
+				certs = append(certs, info)
+			}
+			if !containsString(certs[i].UsedBy, site.File) {
+				certs[i].UsedBy = append(certs[i].UsedBy, site.File)
+			}
+		}
+	}
+	return certs
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func certificateWarnings(certs []CertificateInfo) []CertificateWarning {
+	warnings := []CertificateWarning{}
+	for _, cert := range certs {
+		if cert.Error != "" || !(cert.Expired || cert.ExpiringSoon) {
+			continue
+		}
+		w := CertificateWarning{Name: cert.Name, Path: cert.Path, Domains: cert.Domains, DaysLeft: cert.DaysLeft}
+		if cert.Expired {
+			w.Message = fmt.Sprintf("certificate expired on %s", cert.NotAfter.Format("2006-01-02"))
+		} else {
+			w.Message = fmt.Sprintf("certificate expires in %d days", cert.DaysLeft)
+		}
+		warnings = append(warnings, w)
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].DaysLeft < warnings[j].DaysLeft })
+	return warnings
+}
+
+// startCertificateMonitor logs expiry warnings periodically so they reach the
+// service journal even when nobody looks at the panel.
+func startCertificateMonitor() {
+	interval := defaultCertCheckInterval
+	if d, err := time.ParseDuration(os.Getenv("CERT_CHECK_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	go func() {
+		for {
+			for _, w := range certificateWarnings(listCertificates(certWarnDays())) {
+				fmt.Printf("Certificates: WARNING %s (%s): %s\n", w.Name, strings.Join(w.Domains, ", "), w.Message)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// --- Certificate Handlers ---
+
+func parseWarnDaysQuery(c *gin.Context) (int, bool) {
+	warnDays := certWarnDays()
+	if v := c.Query("warn_days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 || days > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "warn_days must be between 1 and 365"})
+			return 0, false
+		}
+		warnDays = days
+	}
+	return warnDays, true
+}
+
+func getCertificates(c *gin.Context) {
+	warnDays, ok := parseWarnDaysQuery(c)
+	if !ok {
+		return
+	}
+	certs := listCertificates(warnDays)
+	c.JSON(http.StatusOK, gin.H{"warn_days": warnDays, "certificates": certs, "warnings": certificateWarnings(certs)})
+}
+
+func getCertificateWarnings(c *gin.Context) {
+	warnDays, ok := parseWarnDaysQuery(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"warn_days": warnDays, "warnings": certificateWarnings(listCertificates(warnDays))})
+}
+
+func inspectCertificate(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" || !filepath.IsAbs(path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be an absolute file path"})
+		return
+	}
+	warnDays, ok := parseWarnDaysQuery(c)
+	if !ok {
+		return
+	}
+	info, err := inspectPEMFile(filepath.Clean(path), warnDays)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+type CertificateActionRequest struct {
+	Name   string `json:"name"`    // certbot certificate name; empty renews all
+	Force  bool   `json:"force"`   // renew: --force-renewal
+	DryRun bool   `json:"dry_run"` // renew: --dry-run against staging
+	Reason string `json:"reason"`  // revoke: unspecified, keycompromise, affiliationchanged, superseded, cessationofoperation
+	Delete bool   `json:"delete"`  // revoke: remove the certificate files afterwards
+}
+
+var revokeReasons = map[string]bool{
+	"unspecified": true, "keycompromise": true, "affiliationchanged": true, "superseded": true, "cessationofoperation": true,
+}
+
+func renewCertificate(c *gin.Context) {
+	var req CertificateActionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	args := []string{"renew", "--non-interactive"}
+	if req.Name != "" {
+		if !domainRegex.MatchString(req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certificate name"})
+			return
+		}
+		args = append(args, "--cert-name", req.Name)
+	}
+	if req.Force {
+		args = append(args, "--force-renewal")
+	}
+	if req.DryRun {
+		args = append(args, "--dry-run")
+	}
+
+	out, err := exec.Command("certbot", args...).CombinedOutput()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Renewal failed", "details": string(out)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Renewal completed", "output": string(out)})
+}
+
+func revokeCertificate(c *gin.Context) {
+	var req CertificateActionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !domainRegex.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certificate name"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "unspecified"
+	}
+	if !revokeReasons[req.Reason] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revocation reason"})
+		return
+	}
+	deleteFlag := "--no-delete-after-revoke"
+	if req.Delete {
+		deleteFlag = "--delete-after-revoke"
+	}
+
+	out, err := exec.Command("certbot", "revoke", "--cert-name", req.Name, "--reason", req.Reason, deleteFlag, "--non-interactive").CombinedOutput()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Revocation failed", "details": string(out)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Certificate revoked", "output": string(out)})
+}
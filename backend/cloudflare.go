@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"system-manager/cloudflare"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Cloudflare Zones ---
+//
+// Every zone visible to the token can be managed. Endpoints take a zone (ID or
+// name); when it is omitted the zone is picked by matching the record name
+// against the zone names. Relative names ("www", "@") and requests without a
+// name use CloudflareZoneID; a qualified name that matches no zone is an
+// error rather than a record created in the wrong zone.
+
+const cloudflareZonesTTL = 5 * time.Minute
+
+var cloudflareZoneIDRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// cfClient is created in main once the token is known. CLOUDFLARE_API_URL
+// points it at another endpoint (a local fake when testing).
+var cfClient *cloudflare.Client
+
+func newCloudflareClient(token string) *cloudflare.Client {
+	client := cloudflare.New(token)
+	if base := os.Getenv("CLOUDFLARE_API_URL"); base != "" {
+		client.BaseURL = base
+	}
+	return client
+}
+
+var cloudflareZones struct {
+	sync.Mutex
+	zones     []cloudflare.Zone
+	fetchedAt time.Time
+}
+
+// listCloudflareZones returns every zone visible to the token, cached for a
+// few minutes since zone selection needs the list on each request.
+func listCloudflareZones(ctx context.Context, refresh bool) ([]cloudflare.Zone, error) {
+	cloudflareZones.Lock()
+	defer cloudflareZones.Unlock()
+	if !refresh && cloudflareZones.zones != nil && time.Since(cloudflareZones.fetchedAt) < cloudflareZonesTTL {
+		return cloudflareZones.zones, nil
+	}
+	zones, err := cfClient.ListZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cloudflareZones.zones = zones
+	cloudflareZones.fetchedAt = time.Now()
+	return zones, nil
+}
+
+// zoneForName returns the zone with the longest name that name falls under.
+func zoneForName(zones []cloudflare.Zone, name string) (cloudflare.Zone, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var best cloudflare.Zone
+	for _, z := range zones {
+		zoneName := strings.ToLower(z.Name)
+		if (name == zoneName || strings.HasSuffix(name, "."+zoneName)) && len(zoneName) > len(best.Name) {
+			best = z
+		}
+	}
+	return best, best.ID != ""
+}
+
+// resolveCloudflareZone turns a zone ID or name into a zone ID. Without one,
+// the zone is selected from the record name, or is CloudflareZoneID when the
+// name is relative (see relativeRecordName).
+func resolveCloudflareZone(ctx context.Context, zone, name string) (string, error) {
+	zone = strings.TrimSpace(zone)
+	name = strings.TrimSpace(name)
+	if cloudflareZoneIDRegex.MatchString(zone) {
+		return zone, nil
+	}
+	defaultZone := func() (string, error) {
+		if CloudflareZoneID == "" {
+			return "", errors.New("zone is required")
+		}
+		return CloudflareZoneID, nil
+	}
+	if zone == "" && relativeRecordName(name) {
+		return defaultZone()
+	}
+
+	zones, err := listCloudflareZones(ctx, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to list Cloudflare zones: %w", err)
+	}
+	if zone != "" {
+		for _, z := range zones {
+			if strings.EqualFold(z.Name, strings.TrimSuffix(zone, ".")) {
+				return z.ID, nil
+			}
+		}
+		return "", fmt.Errorf("zone %s not found", zone)
+	}
+	if z, ok := zoneForName(zones, name); ok {
+		return z.ID, nil
+	}
+	// Falling back to the default zone would put the record somewhere else
+	return "", fmt.Errorf("no zone matches %s", name)
+}
+
+// relativeRecordName reports whether name can only be meant relative to a
+// zone: empty, "@" or a single label such as "www". Names with a dot are
+// treated as fully qualified, since Cloudflare would otherwise store
+// "mail.other.org" as "mail.other.org.<zone>".
+func relativeRecordName(name string) bool {
+	return name == "" || name == "@" || !strings.Contains(name, ".")
+}
+
+// requestZone resolves the zone query parameter for a handler, writing the
+// error response when it cannot.
+func requestZone(c *gin.Context, name string) (string, bool) {
+	if CloudflareAPIToken == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cloudflare credentials not configured"})
+		return "", false
+	}
+	zoneID, err := resolveCloudflareZone(c.Request.Context(), c.Query("zone"), name)
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) {
+		cloudflareError(c, "Failed to select zone", err)
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return zoneID, true
+}
+
+// --- Cloudflare Zone Handlers ---
+
+// cloudflareError writes the response for a failed API call.
+func cloudflareError(c *gin.Context, action string, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, cloudflare.ErrNoCredentials):
+		status = http.StatusInternalServerError
+	case errors.Is(err, cloudflare.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, cloudflare.ErrRateLimited):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, cloudflare.ErrInvalid):
+		status = http.StatusBadRequest
+	}
+	resp := gin.H{"error": action + ": " + err.Error()}
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) && len(apiErr.Errors) > 0 {
+		resp["details"] = apiErr.Errors
+	}
+	c.JSON(status, resp)
+}
+
+func listCloudflareZonesHandler(c *gin.Context) {
+	zones, err := listCloudflareZones(c.Request.Context(), c.Query("refresh") == "true")
+	if err != nil {
+		cloudflareError(c, "Failed to list zones", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"zones": zones, "default_zone": CloudflareZoneID})
+}
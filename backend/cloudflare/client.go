@@ -0,0 +1,234 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Cloudflare v4 API endpoint.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 8 * time.Second
+	maxResponseSize   = 16 << 20
+)
+
+// Client is a small typed client for the zones and DNS records APIs. The
+// zero value is not usable; create one with New.
+type Client struct {
+	Token   string
+	BaseURL string
+	// HTTPClient performs the requests. Timeout bounds each attempt when the
+	// caller's context has no earlier deadline.
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	// Requests answered with 429 or 5xx are retried up to MaxRetries times,
+	// waiting Retry-After or an exponential backoff between MinBackoff and
+	// MaxBackoff.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func New(token string) *Client {
+	return &Client{
+		Token:      token,
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{},
+		Timeout:    defaultTimeout,
+		MaxRetries: defaultMaxRetries,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+// ResponseInfo is one entry of the errors or messages arrays.
+type ResponseInfo struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+}
+
+type response struct {
+	Success    bool            `json:"success"`
+	Errors     []ResponseInfo  `json:"errors"`
+	Messages   []ResponseInfo  `json:"messages"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo *resultInfo     `json:"result_info"`
+}
+
+// do sends one API call, retrying transient failures, and decodes the result
+// into out (when non-nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (*resultInfo, error) {
+	if c.Token == "" {
+		return nil, ErrNoCredentials
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	target := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, retryAfter, err := c.attempt(ctx, method, path, target, payload)
+		if err == nil {
+			if out != nil && len(resp.Result) > 0 {
+				if err := json.Unmarshal(resp.Result, out); err != nil {
+					return nil, &Error{Method: method, Path: path, Message: "invalid result: " + err.Error()}
+				}
+			}
+			return resp.ResultInfo, nil
+		}
+		if attempt >= c.MaxRetries || !retryable(method, err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.backoff(attempt, retryAfter)):
+		}
+	}
+}
+
+func (c *Client) attempt(parent context.Context, method, path, target string, payload []byte) (*response, time.Duration, error) {
+	ctx := parent
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Only the caller giving up is final; the per-attempt timeout is not
+		return nil, 0, &Error{Method: method, Path: path, Message: err.Error(), Temporary: parent.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, 0, &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Message: err.Error(), Temporary: true}
+	}
+	var decoded response
+	jsonErr := json.Unmarshal(data, &decoded)
+	if jsonErr == nil && decoded.Success && resp.StatusCode < 300 {
+		return &decoded, 0, nil
+	}
+
+	apiErr := &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Errors: decoded.Errors}
+	switch {
+	case jsonErr != nil:
+		apiErr.Message = fmt.Sprintf("unexpected response (%s)", resp.Status)
+	case len(decoded.Errors) > 0:
+		apiErr.Code, apiErr.Message = decoded.Errors[0].Code, decoded.Errors[0].Message
+	default:
+		apiErr.Message = resp.Status
+	}
+	apiErr.Temporary = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return nil, retryAfterHeader(resp.Header.Get("Retry-After")), apiErr
+}
+
+// retryable reports whether err is worth another attempt. POST is not
+// idempotent, so it is only retried when Cloudflare refused it outright.
+func retryable(method string, err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !apiErr.Temporary {
+		return false
+	}
+	if method == http.MethodPost {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	d := c.MinBackoff << attempt
+	if d <= 0 || d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	// Up to 25% jitter so concurrent callers do not retry in lockstep
+	if d > 0 {
+		d += time.Duration(rand.Int63n(int64(d)/4 + 1))
+	}
+	return d
+}
+
+func retryAfterHeader(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// paginate fetches every page of a list endpoint. fetch decodes one page and
+// returns the number of items it held.
+func (c *Client) paginate(ctx context.Context, path string, query url.Values, perPage int, fetch func(page json.RawMessage) (int, error)) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("per_page", strconv.Itoa(perPage))
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		var raw json.RawMessage
+		info, err := c.do(ctx, http.MethodGet, path, query, nil, &raw)
+		if err != nil {
+			return err
+		}
+		n, err := fetch(raw)
+		if err != nil {
+			return &Error{Method: http.MethodGet, Path: path, Message: "invalid result: " + err.Error()}
+		}
+		if n == 0 || info == nil || page >= info.TotalPages {
+			return nil
+		}
+	}
+}
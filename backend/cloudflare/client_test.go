@@ -0,0 +1,240 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testZone = "023e105f4ecef8ad9ca31a8372d0c353"
+
+// newTestClient returns a client for srv with backoffs short enough for tests.
+func newTestClient(srv *httptest.Server) *Client {
+	c := New("token")
+	c.BaseURL = srv.URL
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 5 * time.Millisecond
+	return c
+}
+
+func writeResult(w http.ResponseWriter, result interface{}, info *resultInfo) {
+	data, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(response{Success: true, Result: data, ResultInfo: info})
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response{Errors: []ResponseInfo{{Code: code, Message: message}}})
+}
+
+func TestListDNSRecordsPaginates(t *testing.T) {
+	const total = 7
+	perPage := 3
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.URL.Query().Get("type"); got != "A" {
+			t.Errorf("type filter = %q, want A", got)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		var records []DNSRecord
+		for i := (page - 1) * perPage; i < page*perPage && i < total; i++ {
+			records = append(records, DNSRecord{ID: strconv.Itoa(i), Type: "A", Name: "example.com", Content: fmt.Sprintf("192.0.2.%d", i)})
+		}
+		writeResult(w, records, &resultInfo{Page: page, PerPage: perPage, TotalPages: (total + perPage - 1) / perPage, Count: len(records), TotalCount: total})
+	}))
+	defer srv.Close()
+
+	records, err := newTestClient(srv).ListDNSRecords(context.Background(), testZone, RecordFilter{Type: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != total {
+		t.Fatalf("got %d records, want %d", len(records), total)
+	}
+	for i, r := range records {
+		if r.ID != strconv.Itoa(i) {
+			t.Errorf("record %d has ID %s", i, r.ID)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		failures   int // Responses with status before the success
+		status     int
+		retryAfter string
+		wantCalls  int32
+		wantErr    error
+	}{
+		{name: "GET retried on 503", method: http.MethodGet, failures: 2, status: http.StatusServiceUnavailable, wantCalls: 3},
+		{name: "GET retried on 429 with Retry-After", method: http.MethodGet, failures: 1, status: http.StatusTooManyRequests, retryAfter: "0", wantCalls: 2},
+		{name: "GET gives up after MaxRetries", method: http.MethodGet, failures: 10, status: http.StatusBadGateway, wantCalls: 4, wantErr: ErrUnavailable},
+		{name: "POST not retried on 500", method: http.MethodPost, failures: 1, status: http.StatusInternalServerError, wantCalls: 1, wantErr: ErrUnavailable},
+		{name: "POST retried on 429", method: http.MethodPost, failures: 1, status: http.StatusTooManyRequests, wantCalls: 2},
+		{name: "PUT retried on 500", method: http.MethodPut, failures: 1, status: http.StatusInternalServerError, wantCalls: 2},
+		{name: "DELETE retried on 502", method: http.MethodDelete, failures: 1, status: http.StatusBadGateway, wantCalls: 2},
+		{name: "400 not retried", method: http.MethodGet, failures: 1, status: http.StatusBadRequest, wantCalls: 1, wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.method {
+					t.Errorf("method = %s, want %s", r.Method, tt.method)
+				}
+				if int(atomic.AddInt32(&calls, 1)) <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					writeError(w, tt.status, 10000, http.StatusText(tt.status))
+					return
+				}
+				writeResult(w, DNSRecord{ID: "1", Type: "A", Name: "example.com", Content: "192.0.2.1"}, nil)
+			}))
+			defer srv.Close()
+
+			c := newTestClient(srv)
+			ctx := context.Background()
+			record := DNSRecord{Type: "A", Name: "example.com", Content: "192.0.2.1"}
+			var err error
+			switch tt.method {
+			case http.MethodGet:
+				_, err = c.GetDNSRecord(ctx, testZone, "1")
+			case http.MethodPost:
+				_, err = c.CreateDNSRecord(ctx, testZone, record)
+			case http.MethodPut:
+				_, err = c.UpdateDNSRecord(ctx, testZone, "1", record)
+			case http.MethodDelete:
+				err = c.DeleteDNSRecord(ctx, testZone, "1")
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("server saw %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	c := New("token")
+	if got := c.backoff(0, retryAfterHeader("2")); got != 2*time.Second {
+		t.Errorf("backoff with Retry-After: 2 = %v, want 2s", got)
+	}
+	date := time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat)
+	if got := retryAfterHeader(date); got < time.Second || got > 3*time.Second {
+		t.Errorf("Retry-After date gives %v, want about 3s", got)
+	}
+	if got := retryAfterHeader("soon"); got != 0 {
+		t.Errorf("invalid Retry-After gives %v, want 0", got)
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		if got := c.backoff(attempt, 0); got < c.MinBackoff || got > c.MaxBackoff+c.MaxBackoff/4 {
+			t.Errorf("backoff(%d) = %v outside [%v, %v]", attempt, got, c.MinBackoff, c.MaxBackoff*5/4)
+		}
+	}
+}
+
+func TestAttemptTimeoutIsRetried(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		writeResult(w, Zone{ID: testZone, Name: "example.com"}, nil)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv)
+	c.Timeout = 50 * time.Millisecond
+	zone, err := c.GetZone(context.Background(), testZone)
+	if err != nil {
+		t.Fatalf("GetZone: %v", err)
+	}
+	if zone.Name != "example.com" || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("got zone %q after %d calls, want example.com after 2", zone.Name, calls)
+	}
+}
+
+func TestCancelledContextIsNotRetried(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := newTestClient(srv).GetZone(ctx, testZone)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Temporary {
+		t.Fatalf("error = %#v, want a permanent *Error", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("server saw %d calls, want 1", got)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error
+		code   int
+	}{
+		{http.StatusUnauthorized, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`, ErrUnauthorized, 10000},
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":9109,"message":"Unauthorized to access requested resource"}]}`, ErrForbidden, 9109},
+		{http.StatusNotFound, `{"success":false,"errors":[{"code":81044,"message":"Record does not exist."}]}`, ErrNotFound, 81044},
+		{http.StatusTooManyRequests, `{"success":false,"errors":[{"code":971,"message":"Please wait and consider throttling your request speed"}]}`, ErrRateLimited, 971},
+		{http.StatusBadRequest, `{"success":false,"errors":[{"code":81057,"message":"Record already exists."}]}`, ErrInvalid, 81057},
+		{http.StatusInternalServerError, `<html>oops</html>`, ErrUnavailable, 0},
+		{http.StatusOK, `{"success":false,"errors":[{"code":1003,"message":"Invalid zone"}]}`, ErrInvalid, 1003},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := newTestClient(srv)
+			c.MaxRetries = 0
+			_, err := c.GetDNSRecord(context.Background(), testZone, "1")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %T is not *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code {
+				t.Errorf("status %d code %d, want %d and %d", apiErr.StatusCode, apiErr.Code, tt.status, tt.code)
+			}
+		})
+	}
+
+	if _, err := New("").ListZones(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("empty token: error = %v, want ErrNoCredentials", err)
+	}
+}
@@ -0,0 +1,154 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	zonesPerPage   = 50
+	recordsPerPage = 100
+)
+
+type Zone struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	NameServers []string `json:"name_servers"`
+}
+
+// DNSRecord is a record as returned by the API. The ID, zone and timestamp
+// fields are read-only and ignored when creating or updating.
+type DNSRecord struct {
+	ID         string                 `json:"id,omitempty"`
+	ZoneID     string                 `json:"zone_id,omitempty"`
+	ZoneName   string                 `json:"zone_name,omitempty"`
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	Content    string                 `json:"content,omitempty"`
+	Proxied    bool                   `json:"proxied"`
+	Proxiable  bool                   `json:"proxiable,omitempty"`
+	TTL        int                    `json:"ttl"`
+	Priority   *uint16                `json:"priority,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"` // SRV, CAA, ...
+	Comment    string                 `json:"comment,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	CreatedOn  *time.Time             `json:"created_on,omitempty"`
+	ModifiedOn *time.Time             `json:"modified_on,omitempty"`
+}
+
+// recordParams is the writable part of a DNSRecord.
+type recordParams struct {
+	Type     string                 `json:"type"`
+	Name     string                 `json:"name"`
+	Content  string                 `json:"content,omitempty"`
+	Proxied  bool                   `json:"proxied"`
+	TTL      int                    `json:"ttl"`
+	Priority *uint16                `json:"priority,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Comment  string                 `json:"comment"`
+	Tags     []string               `json:"tags"`
+}
+
+func paramsOf(r DNSRecord) recordParams {
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = 1 // Automatic
+	}
+	tags := r.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return recordParams{
+		Type: r.Type, Name: r.Name, Content: r.Content, Proxied: r.Proxied, TTL: ttl,
+		Priority: r.Priority, Data: r.Data, Comment: r.Comment, Tags: tags,
+	}
+}
+
+// RecordFilter narrows ListDNSRecords. Empty fields match everything.
+type RecordFilter struct {
+	Type    string
+	Name    string
+	Content string
+}
+
+// ListZones returns every zone visible to the token.
+func (c *Client) ListZones(ctx context.Context) ([]Zone, error) {
+	zones := []Zone{}
+	err := c.paginate(ctx, "/zones", nil, zonesPerPage, func(raw json.RawMessage) (int, error) {
+		var page []Zone
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return 0, err
+		}
+		zones = append(zones, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+func (c *Client) GetZone(ctx context.Context, zoneID string) (Zone, error) {
+	var zone Zone
+	_, err := c.do(ctx, http.MethodGet, "/zones/"+url.PathEscape(zoneID), nil, nil, &zone)
+	return zone, err
+}
+
+// ListDNSRecords returns every record of the zone matching filter.
+func (c *Client) ListDNSRecords(ctx context.Context, zoneID string, filter RecordFilter) ([]DNSRecord, error) {
+	query := url.Values{}
+	if filter.Type != "" {
+		query.Set("type", filter.Type)
+	}
+	if filter.Name != "" {
+		query.Set("name", filter.Name)
+	}
+	if filter.Content != "" {
+		query.Set("content", filter.Content)
+	}
+	records := []DNSRecord{}
+	err := c.paginate(ctx, recordsPath(zoneID), query, recordsPerPage, func(raw json.RawMessage) (int, error) {
+		var page []DNSRecord
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return 0, err
+		}
+		records = append(records, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (c *Client) GetDNSRecord(ctx context.Context, zoneID, id string) (DNSRecord, error) {
+	var record DNSRecord
+	_, err := c.do(ctx, http.MethodGet, recordsPath(zoneID)+"/"+url.PathEscape(id), nil, nil, &record)
+	return record, err
+}
+
+func (c *Client) CreateDNSRecord(ctx context.Context, zoneID string, record DNSRecord) (DNSRecord, error) {
+	var created DNSRecord
+	_, err := c.do(ctx, http.MethodPost, recordsPath(zoneID), nil, paramsOf(record), &created)
+	return created, err
+}
+
+// UpdateDNSRecord replaces the record with id.
+func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID, id string, record DNSRecord) (DNSRecord, error) {
+	var updated DNSRecord
+	_, err := c.do(ctx, http.MethodPut, recordsPath(zoneID)+"/"+url.PathEscape(id), nil, paramsOf(record), &updated)
+	return updated, err
+}
+
+func (c *Client) DeleteDNSRecord(ctx context.Context, zoneID, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, recordsPath(zoneID)+"/"+url.PathEscape(id), nil, nil, nil)
+	return err
+}
+
+func recordsPath(zoneID string) string {
+	return "/zones/" + url.PathEscape(zoneID) + "/dns_records"
+}
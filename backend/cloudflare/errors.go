@@ -0,0 +1,53 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNoCredentials = errors.New("cloudflare: API token not configured")
+	ErrUnauthorized  = errors.New("cloudflare: unauthorized")
+	ErrForbidden     = errors.New("cloudflare: forbidden")
+	ErrNotFound      = errors.New("cloudflare: not found")
+	ErrRateLimited   = errors.New("cloudflare: rate limited")
+	ErrInvalid       = errors.New("cloudflare: request rejected")
+	ErrUnavailable   = errors.New("cloudflare: service unavailable")
+)
+
+// Error is a failed API call. It unwraps to one of the Err* values above, so
+// callers can use errors.Is to decide how to report it.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int // 0 when no response was received
+	Code       int // First Cloudflare error code
+	Message    string
+	Errors     []ResponseInfo
+	// Temporary is set for rate limits, server errors and network failures.
+	Temporary bool
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("cloudflare %s %s: error %d: %s", e.Method, e.Path, e.Code, e.Message)
+	}
+	return fmt.Sprintf("cloudflare %s %s: %s", e.Method, e.Path, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == 0 || e.StatusCode >= 500:
+		return ErrUnavailable
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return ErrInvalid
+}
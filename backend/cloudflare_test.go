@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"system-manager/cloudflare"
+)
+
+func TestResolveCloudflareZone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success":true,"result":[
+			{"id":"00000000000000000000000000000001","name":"example.com"},
+			{"id":"00000000000000000000000000000002","name":"sub.example.com"},
+			{"id":"00000000000000000000000000000003","name":"other.org"}
+		],"result_info":{"page":1,"per_page":50,"total_pages":1,"count":3,"total_count":3}}`))
+	}))
+	defer srv.Close()
+
+	previousClient, previousZone := cfClient, CloudflareZoneID
+	cfClient = cloudflare.New("token")
+	cfClient.BaseURL = srv.URL
+	CloudflareZoneID = "00000000000000000000000000000009"
+	cloudflareZones.zones = nil
+	t.Cleanup(func() {
+		cfClient, CloudflareZoneID = previousClient, previousZone
+		cloudflareZones.zones, cloudflareZones.fetchedAt = nil, time.Time{}
+	})
+
+	tests := []struct {
+		zone, name string
+		want       string
+		wantErr    bool
+	}{
+		{name: "www.sub.example.com", want: "00000000000000000000000000000002"},
+		{name: "Other.org.", want: "00000000000000000000000000000003"},
+		{zone: "example.com", name: "www", want: "00000000000000000000000000000001"},
+		{zone: "00000000000000000000000000000003", name: "www.example.com", want: "00000000000000000000000000000003"},
+		// Relative names as the dashboard sends them go to the default zone
+		{name: "www", want: CloudflareZoneID},
+		{name: "@", want: CloudflareZoneID},
+		{want: CloudflareZoneID},
+		{name: "mail.example.net", wantErr: true},
+		{zone: "example.net", name: "www", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveCloudflareZone(context.Background(), tt.zone, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveCloudflareZone(%q, %q) = %q, want an error", tt.zone, tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveCloudflareZone(%q, %q) = %q, %v, want %q", tt.zone, tt.name, got, err, tt.want)
+		}
+	}
+
+	CloudflareZoneID = ""
+	if _, err := resolveCloudflareZone(context.Background(), "", "www"); err == nil {
+		t.Error("relative name without a default zone was accepted")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// --- Connections (ss/netstat) ---
+
+type ConnectionInfo struct {
+	Protocol   string `json:"protocol"`
+	Family     string `json:"family"`
+	LocalIP    string `json:"local_ip"`
+	LocalPort  uint32 `json:"local_port"`
+	RemoteIP   string `json:"remote_ip"`
+	RemotePort uint32 `json:"remote_port"`
+	State      string `json:"state"`
+	PID        int32  `json:"pid"`
+	Process    string `json:"process"`
+}
+
+type CountEntry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type ConnectionAggregates struct {
+	ByState     []CountEntry `json:"by_state"`
+	ByRemoteIP  []CountEntry `json:"by_remote_ip"`
+	ByLocalPort []CountEntry `json:"by_local_port"`
+}
+
+type ConnectionsResponse struct {
+	Total       int                  `json:"total"`
+	Connections []ConnectionInfo     `json:"connections"`
+	Aggregates  ConnectionAggregates `json:"aggregates"`
+}
+
+const (
+	defaultConnectionLimit = 1000
+	defaultAggregateTop    = 20
+)
+
+var connectionKinds = map[string]bool{
+	"inet": true, "inet4": true, "inet6": true,
+	"tcp": true, "tcp4": true, "tcp6": true,
+	"udp": true, "udp4": true, "udp6": true,
+}
+
+func connectionProtocol(conn net.ConnectionStat) string {
+	if conn.Type == syscall.SOCK_DGRAM {
+		return "udp"
+	}
+	return "tcp"
+}
+
+func connectionFamily(conn net.ConnectionStat) string {
+	if conn.Family == syscall.AF_INET6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// processNameCache avoids re-reading /proc/<pid>/comm for every socket of the same process.
+type processNameCache map[int32]string
+
+func (cache processNameCache) lookup(pid int32) string {
+	if pid == 0 {
+		return ""
+	}
+	if name, ok := cache[pid]; ok {
+		return name
+	}
+	name := "Unknown"
+	if proc, err := process.NewProcess(pid); err == nil {
+		if n, err := proc.Name(); err == nil {
+			name = n
+		}
+	}
+	cache[pid] = name
+	return name
+}
+
+// sortedCounts turns a counter map into a slice ordered by count (desc), capped at top.
+func sortedCounts(counts map[string]int, top int) []CountEntry {
+	entries := make([]CountEntry, 0, len(counts))
+	for key, count := range counts {
+		entries = append(entries, CountEntry{Key: key, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+func getConnections(c *gin.Context) {
+	kind := c.DefaultQuery("kind", "inet")
+	if !connectionKinds[kind] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kind"})
+		return
+	}
+	stateFilter := strings.ToUpper(c.Query("state"))
+	remoteFilter := c.Query("remote_ip")
+	portFilter, _ := strconv.Atoi(c.Query("port"))
+	pidFilter, _ := strconv.Atoi(c.Query("pid"))
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultConnectionLimit)))
+	if err != nil || limit < 0 {
+		limit = defaultConnectionLimit
+	}
+	top, err := strconv.Atoi(c.DefaultQuery("top", strconv.Itoa(defaultAggregateTop)))
+	if err != nil || top < 0 {
+		top = defaultAggregateTop
+	}
+
+	conns, err := net.Connections(kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	names := processNameCache{}
+	byState := map[string]int{}
+	byRemote := map[string]int{}
+	byPort := map[string]int{}
+	resp := ConnectionsResponse{Connections: []ConnectionInfo{}}
+
+	for _, conn := range conns {
+		state := conn.Status
+		if state == "" || state == "NONE" {
+			state = "UNCONN" // UDP sockets have no TCP state
+		}
+		if stateFilter != "" && state != stateFilter {
+			continue
+		}
+		if remoteFilter != "" && conn.Raddr.IP != remoteFilter {
+			continue
+		}
+		if portFilter != 0 && int(conn.Laddr.Port) != portFilter && int(conn.Raddr.Port) != portFilter {
+			continue
+		}
+		if pidFilter != 0 && int(conn.Pid) != pidFilter {
+			continue
+		}
+
+		resp.Total++
+		byState[state]++
+		if conn.Raddr.IP != "" && conn.Raddr.Port != 0 {
+			byRemote[conn.Raddr.IP]++
+		}
+		if state != "LISTEN" {
+			byPort[strconv.Itoa(int(conn.Laddr.Port))+"/"+connectionProtocol(conn)]++
+		}
+
+		if limit > 0 && len(resp.Connections) >= limit {
+			continue
+		}
+		resp.Connections = append(resp.Connections, ConnectionInfo{
+			Protocol:   connectionProtocol(conn),
+			Family:     connectionFamily(conn),
+			LocalIP:    conn.Laddr.IP,
+			LocalPort:  conn.Laddr.Port,
+			RemoteIP:   conn.Raddr.IP,
+			RemotePort: conn.Raddr.Port,
+			State:      state,
+			PID:        conn.Pid,
+			Process:    names.lookup(conn.Pid),
+		})
+	}
+
+	resp.Aggregates = ConnectionAggregates{
+		ByState:     sortedCounts(byState, 0),
+		ByRemoteIP:  sortedCounts(byRemote, top),
+		ByLocalPort: sortedCounts(byPort, top),
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"system-manager/dnsprovider"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Dynamic DNS ---
+//
+// Keeps Cloudflare A/AAAA records pointed at this server for hosts whose
+// public address changes. The records to manage are stored in
+// <stateDir>/ddns.json. An update runs when the public IP service finds a new
+// address, and every DDNS_INTERVAL to repair records changed elsewhere or
+// retry after a failure.
+
+const (
+	defaultDDNSInterval = 15 * time.Minute
+	ddnsUpdateTimeout   = time.Minute
+	maxDDNSHistory      = 200
+)
+
+type DDNSRecord struct {
+	Name string `json:"name"`
+	Zone string `json:"zone,omitempty"` // Zone ID or name, selected from the name when empty
+	// Families restricts the records to "A" and/or "AAAA". Defaults to every
+	// family the public IP service knows an address for.
+	Families []string `json:"families,omitempty"`
+	Proxied  bool     `json:"proxied"`
+	TTL      int      `json:"ttl,omitempty"` // Default 1 (auto)
+}
+
+type DDNSConfig struct {
+	Enabled bool         `json:"enabled"`
+	Records []DDNSRecord `json:"records"`
+}
+
+// DDNSUpdate is one history entry: a record that was created or changed, or
+// an update that failed.
+type DDNSUpdate struct {
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Action  string    `json:"action"` // "created", "updated" or "failed"
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Trigger string    `json:"trigger"` // "startup", "ip_change", "interval", "config" or "manual"
+	Error   string    `json:"error,omitempty"`
+}
+
+type DDNSRecordStatus struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Content     string    `json:"content,omitempty"` // Address the record was last seen or set to
+	LastChecked time.Time `json:"last_checked"`
+	LastChanged time.Time `json:"last_changed,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type DDNSStatus struct {
+	Enabled     bool               `json:"enabled"`
+	Interval    string             `json:"interval"`
+	Running     bool               `json:"running"`
+	IPv4        string             `json:"ipv4,omitempty"` // Addresses used by the last run
+	IPv6        string             `json:"ipv6,omitempty"`
+	LastRun     time.Time          `json:"last_run,omitempty"`
+	LastTrigger string             `json:"last_trigger,omitempty"`
+	LastError   string             `json:"last_error,omitempty"`
+	Records     []DDNSRecordStatus `json:"records"`
+}
+
+type ddnsUpdater struct {
+	mu       sync.Mutex // Guards config, status and history
+	runMu    sync.Mutex // Serializes runs
+	config   DDNSConfig
+	status   DDNSStatus
+	records  map[string]*DDNSRecordStatus
+	history  []DDNSUpdate
+	interval time.Duration
+}
+
+var ddns *ddnsUpdater
+
+func ddnsConfigPath() string {
+	return filepath.Join(stateDir, "ddns.json")
+}
+
+func ddnsHistoryPath() string {
+	return filepath.Join(stateDir, "ddns-history.json")
+}
+
+func (r *DDNSRecord) Validate() error {
+	r.Name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(r.Name), "."))
+	if !validHostname(r.Name) {
+		return fmt.Errorf("invalid record name %q", r.Name)
+	}
+	if r.TTL != 0 && r.TTL != 1 && (r.TTL < 60 || r.TTL > 86400) {
+		return fmt.Errorf("%s: ttl must be 1 (auto) or between 60 and 86400", r.Name)
+	}
+	// Cloudflare stores proxied records with TTL 1 whatever is sent, so any
+	// other value would be "repaired" on every run
+	if r.Proxied && r.TTL > 1 {
+		return fmt.Errorf("%s: proxied records always have ttl 1 (auto)", r.Name)
+	}
+	for _, f := range r.Families {
+		if f != "A" && f != "AAAA" {
+			return fmt.Errorf("%s: unsupported record type %q", r.Name, f)
+		}
+	}
+	return nil
+}
+
+func (c *DDNSConfig) Validate() error {
+	seen := make(map[string]bool)
+	for i := range c.Records {
+		if err := c.Records[i].Validate(); err != nil {
+			return err
+		}
+		if seen[c.Records[i].Name] {
+			return fmt.Errorf("%s is listed twice", c.Records[i].Name)
+		}
+		seen[c.Records[i].Name] = true
+	}
+	if c.Enabled && CloudflareAPIToken == "" {
+		return errors.New("Cloudflare credentials not configured")
+	}
+	return nil
+}
+
+func newDDNSUpdater(interval time.Duration) *ddnsUpdater {
+	d := &ddnsUpdater{interval: interval, records: make(map[string]*DDNSRecordStatus)}
+	if data, err := os.ReadFile(ddnsConfigPath()); err == nil {
+		if err := json.Unmarshal(data, &d.config); err != nil {
+			fmt.Printf("DDNS: ignoring corrupt %s: %v\n", ddnsConfigPath(), err)
+		}
+	}
+	if data, err := os.ReadFile(ddnsHistoryPath()); err == nil {
+		json.Unmarshal(data, &d.history)
+	}
+	return d
+}
+
+// startDDNS runs the updater in the background. DDNS_INTERVAL sets how often
+// records are checked when the address has not changed.
+func startDDNS() {
+	interval := defaultDDNSInterval
+	if d, err := time.ParseDuration(os.Getenv("DDNS_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	ddns = newDDNSUpdater(interval)
+	changes := publicIP.Watch()
+	go func() {
+		ddns.run("startup")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-changes:
+				ddns.run("ip_change")
+			case <-ticker.C:
+				ddns.run("interval")
+			}
+		}
+	}()
+}
+
+func (d *ddnsUpdater) Config() DDNSConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	config := d.config
+	config.Records = append([]DDNSRecord{}, d.config.Records...)
+	return config
+}
+
+func (d *ddnsUpdater) SetConfig(config DDNSConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(ddnsConfigPath(), data, 0600); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config = config
+	// Forget records that are no longer managed
+	for key, st := range d.records {
+		if !ddnsManages(config, st.Name) {
+			delete(d.records, key)
+		}
+	}
+	return nil
+}
+
+func ddnsManages(config DDNSConfig, name string) bool {
+	for _, r := range config.Records {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ddnsUpdater) Status() DDNSStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := d.status
+	status.Enabled = d.config.Enabled
+	status.Interval = d.interval.String()
+	status.Records = []DDNSRecordStatus{}
+	for _, r := range d.config.Records {
+		for _, t := range []string{"A", "AAAA"} {
+			if st, ok := d.records[r.Name+"/"+t]; ok {
+				status.Records = append(status.Records, *st)
+			}
+		}
+	}
+	return status
+}
+
+// History returns the most recent entries first.
+func (d *ddnsUpdater) History(limit int) []DDNSUpdate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []DDNSUpdate{}
+	for i := len(d.history) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		out = append(out, d.history[i])
+	}
+	return out
+}
+
+func (d *ddnsUpdater) addHistory(entries []DDNSUpdate) {
+	if len(entries) == 0 {
+		return
+	}
+	d.history = append(d.history, entries...)
+	if len(d.history) > maxDDNSHistory {
+		d.history = d.history[len(d.history)-maxDDNSHistory:]
+	}
+	data, err := json.MarshalIndent(d.history, "", "  ")
+	if err == nil {
+		err = os.WriteFile(ddnsHistoryPath(), data, 0600)
+	}
+	if err != nil {
+		fmt.Printf("DDNS: failed to save history: %v\n", err)
+	}
+}
+
+// run brings every configured record in line with the current public
+// addresses.
+func (d *ddnsUpdater) run(trigger string) DDNSStatus {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+
+	config := d.Config()
+	if !config.Enabled || len(config.Records) == 0 {
+		return d.Status()
+	}
+	state := publicIP.State()
+	addrs := map[string]string{"A": state.IPv4, "AAAA": state.IPv6}
+
+	d.mu.Lock()
+	d.status.Running = true
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ddnsUpdateTimeout)
+	defer cancel()
+
+	var entries []DDNSUpdate
+	var errs []string
+	for _, r := range config.Records {
+		families := r.Families
+		if len(families) == 0 {
+			families = []string{"A", "AAAA"}
+		}
+		for _, recordType := range families {
+			if addrs[recordType] == "" {
+				continue
+			}
+			entry, err := syncDDNSRecord(ctx, r, recordType, addrs[recordType])
+			now := time.Now()
+
+			d.mu.Lock()
+			st := d.records[r.Name+"/"+recordType]
+			if st == nil {
+				st = &DDNSRecordStatus{Name: r.Name, Type: recordType}
+				d.records[r.Name+"/"+recordType] = st
+			}
+			previousErr := st.LastError
+			st.LastChecked, st.LastError = now, ""
+			if err != nil {
+				st.LastError = err.Error()
+				errs = append(errs, fmt.Sprintf("%s %s: %v", recordType, r.Name, err))
+				// A failure is recorded once until the record recovers
+				if previousErr != err.Error() {
+					entry.Action, entry.Error = "failed", err.Error()
+				}
+			} else {
+				st.Content = addrs[recordType]
+			}
+			if entry.Action != "" {
+				if entry.Action != "failed" {
+					st.LastChanged = now
+					fmt.Printf("DDNS: %s %s %s -> %s\n", entry.Action, recordType, r.Name, entry.New)
+				} else {
+					fmt.Printf("DDNS: %s %s: %v\n", recordType, r.Name, err)
+				}
+				entry.Time, entry.Trigger = now, trigger
+				entries = append(entries, entry)
+			}
+			d.mu.Unlock()
+		}
+	}
+
+	d.mu.Lock()
+	d.addHistory(entries)
+	d.status.Running = false
+	d.status.IPv4, d.status.IPv6 = state.IPv4, state.IPv6
+	d.status.LastRun, d.status.LastTrigger = time.Now(), trigger
+	d.status.LastError = strings.Join(errs, "; ")
+	if state.IPv4 == "" && state.IPv6 == "" {
+		d.status.LastError = "public IP address is unknown"
+		if state.LastError != "" {
+			d.status.LastError += ": " + state.LastError
+		}
+	}
+	d.mu.Unlock()
+	return d.Status()
+}
+
+// syncDDNSRecord creates or updates one record. The returned entry has no
+// Action when the record already pointed at content. Changes are added to
+// the DNS record history like those made through the API.
+func syncDDNSRecord(ctx context.Context, r DDNSRecord, recordType, content string) (DDNSUpdate, error) {
+	entry := DDNSUpdate{Name: r.Name, Type: recordType, New: content}
+	ttl := r.TTL
+	if ttl == 0 || r.Proxied {
+		ttl = 1
+	}
+	provider := cloudflareProvider{}
+	zoneID, err := resolveCloudflareZone(ctx, r.Zone, r.Name)
+	if err != nil {
+		return entry, err
+	}
+	existing, err := provider.ListRecords(ctx, zoneID, dnsprovider.Filter{Type: recordType, Name: r.Name})
+	if err != nil {
+		return entry, err
+	}
+	record := dnsprovider.Record{Type: recordType, Name: r.Name, Content: content, Proxied: r.Proxied, TTL: ttl}
+	switch {
+	case len(existing) == 0:
+		created, err := provider.CreateRecord(ctx, zoneID, record)
+		if err != nil {
+			return entry, err
+		}
+		logDNSChange(provider.Name(), zoneID, "create", "ddns", nil, &created, "ddns")
+		entry.Action = "created"
+	case existing[0].Content == content && existing[0].Proxied == r.Proxied && (r.Proxied || existing[0].TTL == ttl):
+		return DDNSUpdate{}, nil
+	default:
+		// Only the first record is managed; round-robin sets are left alone
+		before := existing[0]
+		entry.Old = before.Content
+		updated, err := provider.UpdateRecord(ctx, zoneID, before.ID, record)
+		if err != nil {
+			return entry, err
+		}
+		logDNSChange(provider.Name(), zoneID, "update", "ddns", &before, &updated, "ddns")
+		entry.Action = "updated"
+	}
+	return entry, nil
+}
+
+// --- DDNS Handlers ---
+
+func getDDNSHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"config": ddns.Config(), "status": ddns.Status(), "public_ip": publicIP.State()})
+}
+
+func updateDDNSHandler(c *gin.Context) {
+	var config DDNSConfig
+	if err := c.BindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := config.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if config.Records == nil {
+		config.Records = []DDNSRecord{}
+	}
+	if err := ddns.SetConfig(config); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save DDNS settings: " + err.Error()})
+		return
+	}
+	go ddns.run("config")
+	c.JSON(http.StatusOK, gin.H{"status": "success", "config": config})
+}
+
+// syncDDNSHandler runs an update now. ?refresh=true detects the public
+// address first instead of using the cached one.
+func syncDDNSHandler(c *gin.Context) {
+	if !ddns.Config().Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "DDNS is disabled"})
+		return
+	}
+	if c.Query("refresh") == "true" {
+		// A changed address also wakes the background loop, which then
+		// finds the records up to date
+		publicIP.Refresh()
+	}
+	status := ddns.run("manual")
+	code := http.StatusOK
+	if status.LastError != "" {
+		code = http.StatusBadGateway
+	}
+	c.JSON(code, gin.H{"status": status, "history": ddns.History(10)})
+}
+
+func getDDNSHistoryHandler(c *gin.Context) {
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDDNSHistory {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDDNSHistory)})
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, gin.H{"history": ddns.History(limit)})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- Unified Diff ---
+
+const (
+	diffContextLines = 3
+	// maxDiffCells caps the LCS table (4 bytes a cell) at 16 MiB. Larger
+	// changes are shown as the whole changed region replaced.
+	maxDiffCells = 4 << 20
+)
+
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a line diff using the longest common subsequence.
+// Config files are small, so the quadratic table is acceptable up to
+// maxDiffCells.
+func diffLines(a, b []string) []diffOp {
+	// Trim common prefix/suffix to keep the table small for typical edits
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	n, m := len(midA), len(midB)
+	if n*m > maxDiffCells {
+		n, m = 0, 0 // Everything between the prefix and suffix is replaced
+	}
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', midA[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		ops = append(ops, diffOp{'-', midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		ops = append(ops, diffOp{'+', midB[j]})
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// unifiedDiff renders the difference between two texts in unified diff format.
+// It returns an empty string when both texts are identical.
+func unifiedDiff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var changes []int
+	for idx, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, idx)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for h := 0; h < len(changes); {
+		// Extend the hunk while the next change is within two context windows
+		last := h
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContextLines {
+			last++
+		}
+		start := max(0, changes[h]-diffContextLines)
+		end := min(len(ops), changes[last]+diffContextLines+1)
+
+		aStart, bStart := 0, 0
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			sb.WriteByte('\n')
+		}
+		h = last + 1
+	}
+	return sb.String()
+}
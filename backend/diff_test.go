@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "identical", from: "a\nb\n", to: "a\nb\n", want: ""},
+		{
+			name: "changed line",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "new file",
+			from: "",
+			to:   "x\ny\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name: "deleted file",
+			from: "x\n",
+			to:   "",
+			want: "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-x\n",
+		},
+		{
+			name: "separate hunks",
+			from: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			to:   "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+		{
+			name: "insertion between lines",
+			from: "a\nc\n",
+			to:   "a\nb\nc\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", tt.from, tt.to); got != tt.want {
+				t.Errorf("unifiedDiff\n got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLargeInput(t *testing.T) {
+	// 10000 changed lines on each side would need a 100M cell table
+	var from, to strings.Builder
+	from.WriteString("server {\n")
+	to.WriteString("server {\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&from, "    set $a%d %d;\n", i, i)
+		fmt.Fprintf(&to, "    set $b%d %d;\n", i, i)
+	}
+	from.WriteString("}\n")
+	to.WriteString("}\n")
+
+	start := time.Now()
+	diff := unifiedDiff("a", "b", from.String(), to.String())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("diff took %v", elapsed)
+	}
+	if !strings.Contains(diff, "@@ -1,10002 +1,10002 @@\n server {\n-    set $a0 0;\n") {
+		t.Errorf("unexpected diff header:\n%.200s", diff)
+	}
+	if got := strings.Count(diff, "\n-"); got != 10000 {
+		t.Errorf("%d removed lines, want 10000", got)
+	}
+	if got := strings.Count(diff, "\n+"); got != 10001 { // Includes the +++ line
+		t.Errorf("%d added lines, want 10000", got-1)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"system-manager/cloudflare"
+	"system-manager/dnsprovider"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- DNS Record History ---
+//
+// Every change made through the record endpoints, zone imports, DDNS and site
+// creation is stored with the record as it was before and after, under
+// <stateDir>/dns-history/<provider>/<zone>/<id>.json. Undoing a change applies
+// the reverse operation and is itself recorded, so an undo can be undone too.
+// Only the newest maxDNSHistory changes of a zone are kept.
+
+const maxDNSHistory = 1000
+
+type DNSRecordChange struct {
+	ID        int                 `json:"id"`
+	Provider  string              `json:"provider"`
+	Zone      string              `json:"zone"`             // Zone ID
+	Action    string              `json:"action"`           // "create", "update", "delete" or "undo"
+	Source    string              `json:"source"`           // "api", "import", "ddns" or "site"
+	Before    *dnsprovider.Record `json:"before,omitempty"` // Nil for creations
+	After     *dnsprovider.Record `json:"after,omitempty"`  // Nil for deletions
+	Author    string              `json:"author"`
+	Timestamp time.Time           `json:"timestamp"`
+	UndoOf    int                 `json:"undo_of,omitempty"`
+	UndoneBy  int                 `json:"undone_by,omitempty"`
+}
+
+var (
+	dnsHistoryMu sync.Mutex
+	dnsUndoing   = make(map[string]bool) // Changes an undo is in progress for, guarded by dnsHistoryMu
+)
+
+func dnsHistoryDir(provider, zone string) string {
+	return filepath.Join(stateDir, "dns-history", provider, zone)
+}
+
+func validDNSHistoryZone(zone string) bool {
+	return zone != "" && !strings.Contains(zone, "..") && !strings.ContainsAny(zone, `/\`)
+}
+
+func dnsChangePath(provider, zone string, id int) string {
+	return filepath.Join(dnsHistoryDir(provider, zone), fmt.Sprintf("%06d.json", id))
+}
+
+// dnsChangeIDs returns the IDs in a zone's history in ascending order, read
+// from the file names alone. The caller must hold dnsHistoryMu.
+func dnsChangeIDs(provider, zone string) ([]int, error) {
+	entries, err := os.ReadDir(dnsHistoryDir(provider, zone))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if id, err := strconv.Atoi(name); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// readDNSChange loads one change. The caller must hold dnsHistoryMu.
+func readDNSChange(provider, zone string, id int) (DNSRecordChange, error) {
+	data, err := os.ReadFile(dnsChangePath(provider, zone, id))
+	if err != nil {
+		return DNSRecordChange{}, err
+	}
+	var change DNSRecordChange
+	if err := json.Unmarshal(data, &change); err != nil {
+		return DNSRecordChange{}, fmt.Errorf("corrupt change %d: %w", id, err)
+	}
+	return change, nil
+}
+
+// readDNSChanges loads every change of a zone ordered by ID. The caller must
+// hold dnsHistoryMu.
+func readDNSChanges(provider, zone string) ([]DNSRecordChange, error) {
+	ids, err := dnsChangeIDs(provider, zone)
+	if err != nil {
+		return nil, err
+	}
+	var changes []DNSRecordChange
+	for _, id := range ids {
+		change, err := readDNSChange(provider, zone, id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func writeDNSChange(change DNSRecordChange) error {
+	if err := os.MkdirAll(dnsHistoryDir(change.Provider, change.Zone), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(change, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dnsChangePath(change.Provider, change.Zone, change.ID), data, 0600)
+}
+
+func loadDNSChange(provider, zone string, id int) (DNSRecordChange, error) {
+	dnsHistoryMu.Lock()
+	defer dnsHistoryMu.Unlock()
+	return readDNSChange(provider, zone, id)
+}
+
+// recordDNSChange appends a change to the zone's history and returns it with
+// its ID, dropping the oldest changes beyond maxDNSHistory.
+func recordDNSChange(change DNSRecordChange) (DNSRecordChange, error) {
+	if !validDNSHistoryZone(change.Zone) {
+		return DNSRecordChange{}, fmt.Errorf("invalid zone %q", change.Zone)
+	}
+	dnsHistoryMu.Lock()
+	defer dnsHistoryMu.Unlock()
+
+	ids, err := dnsChangeIDs(change.Provider, change.Zone)
+	if err != nil {
+		return DNSRecordChange{}, err
+	}
+	change.ID = 1
+	if len(ids) > 0 {
+		change.ID = ids[len(ids)-1] + 1
+	}
+	change.Timestamp = time.Now()
+	if err := writeDNSChange(change); err != nil {
+		return DNSRecordChange{}, err
+	}
+	ids = append(ids, change.ID)
+	for len(ids) > maxDNSHistory {
+		if err := os.Remove(dnsChangePath(change.Provider, change.Zone, ids[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("DNS: failed to prune history of %s: %v\n", change.Zone, err)
+			break
+		}
+		ids = ids[1:]
+	}
+	if change.UndoOf != 0 {
+		prev, err := readDNSChange(change.Provider, change.Zone, change.UndoOf)
+		if errors.Is(err, os.ErrNotExist) {
+			return change, nil // Pruned
+		}
+		if err != nil {
+			return change, err
+		}
+		if prev.UndoneBy == 0 {
+			prev.UndoneBy = change.ID
+			if err := writeDNSChange(prev); err != nil {
+				return change, err
+			}
+		}
+	}
+	return change, nil
+}
+
+// claimDNSUndo re-reads change under the lock and marks an undo of it as in
+// progress, so that two requests cannot both undo it. release must be called
+// once the undo is recorded or has failed.
+func claimDNSUndo(change DNSRecordChange) (current DNSRecordChange, release func(), err error) {
+	dnsHistoryMu.Lock()
+	defer dnsHistoryMu.Unlock()
+
+	current, err = readDNSChange(change.Provider, change.Zone, change.ID)
+	if err != nil {
+		return DNSRecordChange{}, nil, err
+	}
+	if current.UndoneBy != 0 {
+		return DNSRecordChange{}, nil, fmt.Errorf("Change %d was already undone by change %d", current.ID, current.UndoneBy)
+	}
+	key := filepath.Join(change.Provider, change.Zone, strconv.Itoa(change.ID))
+	if dnsUndoing[key] {
+		return DNSRecordChange{}, nil, fmt.Errorf("Change %d is already being undone", current.ID)
+	}
+	dnsUndoing[key] = true
+	return current, func() {
+		dnsHistoryMu.Lock()
+		delete(dnsUndoing, key)
+		dnsHistoryMu.Unlock()
+	}, nil
+}
+
+// logDNSChange records a change made by a handler. A failure only loses the
+// history entry, the change itself has already been applied.
+func logDNSChange(provider, zone, action, source string, before, after *dnsprovider.Record, author string) DNSRecordChange {
+	return logDNSUndo(provider, zone, action, source, 0, before, after, author)
+}
+
+// logDNSUndo is logDNSChange for a change that reverses change undoOf, such
+// as the rollback of a failed site creation.
+func logDNSUndo(provider, zone, action, source string, undoOf int, before, after *dnsprovider.Record, author string) DNSRecordChange {
+	change, err := recordDNSChange(DNSRecordChange{
+		Provider: provider, Zone: zone, Action: action, Source: source,
+		Before: before, After: after, Author: author, UndoOf: undoOf,
+	})
+	if err != nil {
+		fmt.Printf("DNS: failed to record %s of %s in %s: %v\n", action, changeRecordName(before, after), zone, err)
+	}
+	return change
+}
+
+func changeRecordName(before, after *dnsprovider.Record) string {
+	if after != nil {
+		return after.Type + " " + after.Name
+	}
+	if before != nil {
+		return before.Type + " " + before.Name
+	}
+	return ""
+}
+
+// sameDNSRecord reports whether two records hold the same data, ignoring
+// IDs, TTL and metadata.
+func sameDNSRecord(a, b dnsprovider.Record) bool {
+	return strings.EqualFold(a.Type, b.Type) &&
+		strings.EqualFold(strings.TrimSuffix(a.Name, "."), strings.TrimSuffix(b.Name, ".")) &&
+		dnsRecordValue(a) == dnsRecordValue(b) && a.Proxied == b.Proxied
+}
+
+// writableRecord strips what a provider assigns itself.
+func writableRecord(r dnsprovider.Record) dnsprovider.Record {
+	r.ID = ""
+	return r
+}
+
+// --- DNS History Handlers ---
+
+// snapshotDNSRecord fetches a record before it is changed, writing the error
+// response when it cannot.
+func snapshotDNSRecord(c *gin.Context, provider dnsprovider.Provider, zoneID, id string) (dnsprovider.Record, bool) {
+	record, err := provider.GetRecord(c.Request.Context(), zoneID, id)
+	if err != nil {
+		dnsProviderError(c, "Failed to fetch record", err)
+		return dnsprovider.Record{}, false
+	}
+	return record, true
+}
+
+// listDNSHistory returns a zone's changes, newest first. ?record_id= narrows
+// them to one record, ?limit= caps the count.
+func listDNSHistory(c *gin.Context) {
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zoneID, ok := requestProviderZone(c, provider, c.Query("name"))
+	if !ok {
+		return
+	}
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+	if !validDNSHistoryZone(zoneID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone"})
+		return
+	}
+
+	dnsHistoryMu.Lock()
+	changes, err := readDNSChanges(provider.Name(), zoneID)
+	dnsHistoryMu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read history: " + err.Error()})
+		return
+	}
+	recordID := c.Query("record_id")
+	out := []DNSRecordChange{}
+	for i := len(changes) - 1; i >= 0 && (limit == 0 || len(out) < limit); i-- {
+		ch := changes[i]
+		if recordID != "" && (ch.Before == nil || ch.Before.ID != recordID) && (ch.After == nil || ch.After.ID != recordID) {
+			continue
+		}
+		out = append(out, ch)
+	}
+	c.JSON(http.StatusOK, gin.H{"provider": provider.Name(), "zone_id": zoneID, "changes": out})
+}
+
+// requestDNSChange loads the change named by the :change parameter.
+func requestDNSChange(c *gin.Context) (dnsprovider.Provider, DNSRecordChange, bool) {
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return nil, DNSRecordChange{}, false
+	}
+	zoneID, ok := requestProviderZone(c, provider, c.Query("name"))
+	if !ok {
+		return nil, DNSRecordChange{}, false
+	}
+	id, err := strconv.Atoi(c.Param("change"))
+	if err != nil || !validDNSHistoryZone(zoneID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change id"})
+		return nil, DNSRecordChange{}, false
+	}
+	change, err := loadDNSChange(provider.Name(), zoneID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change not found"})
+		return nil, DNSRecordChange{}, false
+	}
+	return provider, change, true
+}
+
+func getDNSChange(c *gin.Context) {
+	_, change, ok := requestDNSChange(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, change)
+}
+
+// undoDNSChange restores the state before a change: a deleted record is
+// recreated, an updated one gets its previous data back and a created one is
+// deleted. The record must still look the way the change left it, unless
+// ?force=true.
+func undoDNSChange(c *gin.Context) {
+	provider, change, ok := requestDNSChange(c)
+	if !ok {
+		return
+	}
+	change, release, err := claimDNSUndo(change)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+	ctx := c.Request.Context()
+	force := c.Query("force") == "true"
+
+	var current *dnsprovider.Record
+	if change.After != nil {
+		record, err := provider.GetRecord(ctx, change.Zone, change.After.ID)
+		switch {
+		case errors.Is(err, dnsprovider.ErrNotFound) || errors.Is(err, cloudflare.ErrNotFound):
+			c.JSON(http.StatusConflict, gin.H{"error": "The record was deleted after this change"})
+			return
+		case err != nil:
+			dnsProviderError(c, "Failed to fetch record", err)
+			return
+		case !sameDNSRecord(record, *change.After) && !force:
+			c.JSON(http.StatusConflict, gin.H{"error": "The record was modified after this change (use force=true to overwrite)", "current": record})
+			return
+		}
+		current = &record
+	}
+
+	var restored *dnsprovider.Record
+	switch {
+	case change.Before == nil:
+		err = provider.DeleteRecord(ctx, change.Zone, current.ID)
+	case current == nil:
+		var created dnsprovider.Record
+		created, err = provider.CreateRecord(ctx, change.Zone, writableRecord(*change.Before))
+		restored = &created
+	default:
+		var updated dnsprovider.Record
+		updated, err = provider.UpdateRecord(ctx, change.Zone, current.ID, writableRecord(*change.Before))
+		restored = &updated
+	}
+	if err != nil {
+		dnsProviderError(c, "Failed to undo change", err)
+		return
+	}
+
+	undo, err := recordDNSChange(DNSRecordChange{
+		Provider: provider.Name(), Zone: change.Zone, Action: "undo", Source: "api",
+		Before: current, After: restored, Author: requestUser(c), UndoOf: change.ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": true, "result": restored, "warning": "Undone, but history could not be saved: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": restored, "change": undo})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"system-manager/dnsprovider"
+)
+
+func TestRecordDNSChangeRetention(t *testing.T) {
+	previousStateDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = previousStateDir })
+
+	record := &dnsprovider.Record{ID: "1", Type: "A", Name: "example.com", Content: "192.0.2.1"}
+	var last DNSRecordChange
+	for i := 0; i < maxDNSHistory+5; i++ {
+		last = logDNSChange("cloudflare", "zone", "create", "api", nil, record, "admin")
+	}
+	if last.ID != maxDNSHistory+5 {
+		t.Errorf("last change has ID %d, want %d", last.ID, maxDNSHistory+5)
+	}
+	ids, err := dnsChangeIDs("cloudflare", "zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != maxDNSHistory || ids[0] != 6 {
+		t.Errorf("kept %d changes starting at %d, want %d starting at 6", len(ids), ids[0], maxDNSHistory)
+	}
+
+	// IDs keep counting up after pruning and undo links survive it
+	undo := logDNSUndo("cloudflare", "zone", "undo", "api", last.ID, record, nil, "admin")
+	if undo.ID != last.ID+1 {
+		t.Errorf("undo has ID %d, want %d", undo.ID, last.ID+1)
+	}
+	undone, err := loadDNSChange("cloudflare", "zone", last.ID)
+	if err != nil || undone.UndoneBy != undo.ID {
+		t.Errorf("change %d undone by %d (%v), want %d", last.ID, undone.UndoneBy, err, undo.ID)
+	}
+	if change := logDNSUndo("cloudflare", "zone", "undo", "api", 1, nil, record, "admin"); change.ID == 0 {
+		t.Error("undo of a pruned change was not recorded")
+	}
+}
+
+func TestClaimDNSUndo(t *testing.T) {
+	previousStateDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = previousStateDir })
+
+	record := &dnsprovider.Record{ID: "1", Type: "A", Name: "example.com", Content: "192.0.2.1"}
+	change := logDNSChange("cloudflare", "zone", "create", "api", nil, record, "admin")
+
+	claimed, release, err := claimDNSUndo(change)
+	if err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if _, _, err := claimDNSUndo(change); err == nil {
+		t.Fatal("second claim succeeded while the first is in progress")
+	}
+	logDNSUndo("cloudflare", "zone", "undo", "api", claimed.ID, record, nil, "admin")
+	release()
+
+	// The stale copy still says the change is not undone; the claim re-reads it
+	if _, _, err := claimDNSUndo(change); err == nil {
+		t.Fatal("claim succeeded for a change that was already undone")
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"system-manager/dnsprovider"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// --- DNS Lookup ---
+//
+// Diagnostics for a name: the same question is sent to the system resolvers
+// (/etc/resolv.conf), a list of public resolvers (DNS_CHECK_RESOLVERS) and the
+// zone's authoritative nameservers, and the answers and TTLs are compared.
+// Queries are made directly so TTLs and record types the standard resolver
+// does not expose (CAA) are available.
+
+const (
+	defaultDNSCheckResolvers = "1.1.1.1,8.8.8.8,9.9.9.9,208.67.222.222"
+	dnsQueryTimeout          = 3 * time.Second
+	dnsLookupTimeout         = 10 * time.Second
+	maxDNSCheckResolvers     = 16
+)
+
+var dnsLookupTypes = map[string]dnsmessage.Type{
+	"A": dnsmessage.TypeA, "AAAA": dnsmessage.TypeAAAA, "CNAME": dnsmessage.TypeCNAME, "MX": dnsmessage.TypeMX,
+	"TXT": dnsmessage.TypeTXT, "NS": dnsmessage.TypeNS, "CAA": dnsmessage.Type(257), "SRV": dnsmessage.TypeSRV,
+}
+
+type DNSAnswer struct {
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+type DNSResolverResult struct {
+	Resolver string      `json:"resolver"`
+	Kind     string      `json:"kind"` // "system", "public" or "authoritative"
+	RCode    string      `json:"rcode,omitempty"`
+	Answers  []DNSAnswer `json:"answers"`
+	CNAME    []string    `json:"cname,omitempty"` // Aliases followed to reach the answers
+	TTL      *uint32     `json:"ttl,omitempty"`   // Lowest TTL in the answers
+	RTTMs    int64       `json:"rtt_ms"`
+	Error    string      `json:"error,omitempty"`
+	Matches  *bool       `json:"matches,omitempty"` // Every expected value is present
+}
+
+type DNSLookupReport struct {
+	Name             string              `json:"name"`
+	Type             string              `json:"type"`
+	Zone             string              `json:"zone,omitempty"`
+	Expected         []string            `json:"expected,omitempty"`
+	Results          []DNSResolverResult `json:"results"`
+	Consistent       bool                `json:"consistent"` // Every resolver that answered returned the same values
+	AuthoritativeTTL *uint32             `json:"authoritative_ttl,omitempty"`
+	// TTLConsistent is false when the authoritative servers disagree on the
+	// TTL, or a resolver holds the answer longer than the authoritative TTL
+	// (a cached copy of an older record).
+	TTLConsistent bool      `json:"ttl_consistent"`
+	Propagated    *bool     `json:"propagated,omitempty"` // Every resolver returns the expected values
+	Pending       []string  `json:"pending,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+type dnsLookupTarget struct {
+	server string
+	kind   string
+	err    string // Set when the target could not be determined
+}
+
+// dnsCheckResolvers reads DNS_CHECK_RESOLVERS.
+func dnsCheckResolvers() []string {
+	list := os.Getenv("DNS_CHECK_RESOLVERS")
+	if list == "" {
+		list = defaultDNSCheckResolvers
+	}
+	return splitList(list)
+}
+
+func splitList(list string) []string {
+	var out []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// systemNameservers returns the nameservers from /etc/resolv.conf.
+func systemNameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// dnsServerAddr adds the default port to an IP (IPv6 included) unless it
+// already has one.
+func dnsServerAddr(server string) (string, error) {
+	if _, _, err := stdnet.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+	if stdnet.ParseIP(strings.Trim(server, "[]")) == nil {
+		return "", fmt.Errorf("invalid resolver address %q", server)
+	}
+	return stdnet.JoinHostPort(strings.Trim(server, "[]"), "53"), nil
+}
+
+// queryDNS sends one question to server over UDP, retrying over TCP when the
+// answer is truncated. Recursion is requested unless the server is
+// authoritative.
+func queryDNS(ctx context.Context, target dnsLookupTarget, name string, qtype dnsmessage.Type) DNSResolverResult {
+	result := DNSResolverResult{Resolver: target.server, Kind: target.kind, Answers: []DNSAnswer{}}
+	start := time.Now()
+	defer func() { result.RTTMs = time.Since(start).Milliseconds() }()
+	if target.err != "" {
+		result.Error = target.err
+		return result
+	}
+
+	addr, err := dnsServerAddr(target.server)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	header := dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: target.kind != "authoritative"}
+	msg := dnsmessage.Message{Header: header, Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}}}
+	packed, err := msg.Pack()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+	resp, err := exchangeDNS(ctx, "udp", addr, packed)
+	if err == nil && resp.Truncated {
+		resp, err = exchangeDNS(ctx, "tcp", addr, packed)
+	}
+	if err == nil && resp.ID != header.ID {
+		err = errors.New("response does not match the query")
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.RCode = dnsRCodeName(resp.RCode)
+	for _, rr := range resp.Answers {
+		if rr.Header.Type == dnsmessage.TypeCNAME && qtype != dnsmessage.TypeCNAME {
+			result.CNAME = append(result.CNAME, dnsAnswerValue(rr.Body))
+			continue
+		}
+		if rr.Header.Type != qtype {
+			continue
+		}
+		result.Answers = append(result.Answers, DNSAnswer{Value: dnsAnswerValue(rr.Body), TTL: rr.Header.TTL})
+		if result.TTL == nil || rr.Header.TTL < *result.TTL {
+			ttl := rr.Header.TTL
+			result.TTL = &ttl
+		}
+	}
+	sort.Slice(result.Answers, func(i, j int) bool { return result.Answers[i].Value < result.Answers[j].Value })
+	if resp.RCode != dnsmessage.RCodeSuccess && resp.RCode != dnsmessage.RCodeNameError {
+		result.Error = "server answered " + result.RCode
+	}
+	return result
+}
+
+func exchangeDNS(ctx context.Context, network, addr string, query []byte) (*dnsmessage.Message, error) {
+	var dialer stdnet.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var resp []byte
+	if network == "tcp" {
+		frame := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(frame, uint16(len(query)))
+		copy(frame[2:], query)
+		if _, err := conn.Write(frame); err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp = buf[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &msg, nil
+}
+
+func dnsRCodeName(code dnsmessage.RCode) string {
+	names := map[dnsmessage.RCode]string{
+		dnsmessage.RCodeSuccess: "NOERROR", dnsmessage.RCodeFormatError: "FORMERR", dnsmessage.RCodeServerFailure: "SERVFAIL",
+		dnsmessage.RCodeNameError: "NXDOMAIN", dnsmessage.RCodeNotImplemented: "NOTIMP", dnsmessage.RCodeRefused: "REFUSED",
+	}
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return strconv.Itoa(int(code))
+}
+
+// dnsAnswerValue formats record data the way dnsRecordValue formats a
+// provider record, so answers can be compared with what was configured.
+func dnsAnswerValue(body dnsmessage.ResourceBody) string {
+	host := func(n dnsmessage.Name) string { return strings.ToLower(strings.TrimSuffix(n.String(), ".")) }
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return stdnet.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return stdnet.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return host(b.CNAME)
+	case *dnsmessage.NSResource:
+		return host(b.NS)
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, host(b.MX))
+	case *dnsmessage.TXTResource:
+		return strings.Join(b.TXT, "")
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, host(b.Target))
+	case *dnsmessage.UnknownResource:
+		// CAA: flags, tag length, tag, value
+		if b.Type == 257 && len(b.Data) >= 2 && len(b.Data) >= 2+int(b.Data[1]) {
+			tagEnd := 2 + int(b.Data[1])
+			return fmt.Sprintf("%d %s %q", b.Data[0], b.Data[2:tagEnd], b.Data[tagEnd:])
+		}
+		return fmt.Sprintf("\\# %d %x", len(b.Data), b.Data)
+	}
+	return body.GoString()
+}
+
+// dnsRecordValue is the answer a resolver should return for a provider record.
+func dnsRecordValue(r dnsprovider.Record) string {
+	switch r.Type {
+	case "A", "AAAA":
+		if ip := stdnet.ParseIP(r.Content); ip != nil {
+			return ip.String()
+		}
+	case "CNAME", "NS":
+		return strings.ToLower(strings.TrimSuffix(r.Content, "."))
+	case "MX":
+		var pref uint16
+		if r.Priority != nil {
+			pref = *r.Priority
+		}
+		return fmt.Sprintf("%d %s", pref, strings.ToLower(strings.TrimSuffix(r.Content, ".")))
+	case "SRV":
+		return fmt.Sprintf("%v %v %v %s", r.Data["priority"], r.Data["weight"], r.Data["port"],
+			strings.ToLower(strings.TrimSuffix(fmt.Sprint(r.Data["target"]), ".")))
+	case "CAA":
+		return fmt.Sprintf("%v %v %q", r.Data["flags"], r.Data["tag"], fmt.Sprint(r.Data["value"]))
+	case "TXT":
+		// Resolvers answer with the chunks joined and unquoted
+		return txtValue(r.Content)
+	}
+	return r.Content
+}
+
+// lookupDNS asks every target and compares the answers. expected may be
+// empty, in which case propagation is not evaluated.
+func lookupDNS(ctx context.Context, name, recordType string, targets []dnsLookupTarget, expected []string) DNSLookupReport {
+	qtype := dnsLookupTypes[recordType]
+	report := DNSLookupReport{Name: name, Type: recordType, Expected: expected, CheckedAt: time.Now()}
+
+	results := make([]DNSResolverResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t dnsLookupTarget) {
+			defer wg.Done()
+			results[i] = queryDNS(ctx, t, name, qtype)
+		}(i, t)
+	}
+	wg.Wait()
+	report.Results = results
+
+	var reference string
+	report.Consistent, report.TTLConsistent = true, true
+	answered := false
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		values := make([]string, 0, len(r.Answers))
+		for _, a := range r.Answers {
+			values = append(values, a.Value)
+		}
+		set := strings.Join(values, "\n")
+		if !answered {
+			reference, answered = set, true
+		} else if set != reference {
+			report.Consistent = false
+		}
+		if r.Kind == "authoritative" && r.TTL != nil {
+			if report.AuthoritativeTTL == nil {
+				report.AuthoritativeTTL = r.TTL
+			} else if *report.AuthoritativeTTL != *r.TTL {
+				report.TTLConsistent = false
+			}
+		}
+	}
+	if report.AuthoritativeTTL != nil {
+		for _, r := range results {
+			if r.Kind != "authoritative" && r.TTL != nil && *r.TTL > *report.AuthoritativeTTL {
+				report.TTLConsistent = false
+			}
+		}
+	}
+
+	if len(expected) > 0 {
+		propagated := true
+		for i := range report.Results {
+			r := &report.Results[i]
+			matches := r.Error == ""
+			for _, want := range expected {
+				found := false
+				for _, a := range r.Answers {
+					if strings.EqualFold(a.Value, want) {
+						found = true
+					}
+				}
+				matches = matches && found
+			}
+			r.Matches = &matches
+			if !matches {
+				propagated = false
+				report.Pending = append(report.Pending, r.Resolver)
+			}
+		}
+		report.Propagated = &propagated
+	}
+	return report
+}
+
+// dnsLookupTargets builds the resolver list from the query parameters:
+// resolvers (comma separated, replaces DNS_CHECK_RESOLVERS), system=false and
+// authoritative=false.
+func dnsLookupTargets(c *gin.Context, name string) ([]dnsLookupTarget, string, error) {
+	var targets []dnsLookupTarget
+	if c.Query("system") != "false" {
+		for _, s := range systemNameservers() {
+			targets = append(targets, dnsLookupTarget{server: s, kind: "system"})
+		}
+	}
+	resolvers := dnsCheckResolvers()
+	if v, ok := c.GetQuery("resolvers"); ok {
+		resolvers = splitList(v)
+	}
+	if len(resolvers) > maxDNSCheckResolvers {
+		return nil, "", fmt.Errorf("at most %d resolvers can be queried", maxDNSCheckResolvers)
+	}
+	for _, s := range resolvers {
+		if _, err := dnsServerAddr(s); err != nil {
+			return nil, "", err
+		}
+		targets = append(targets, dnsLookupTarget{server: s, kind: "public"})
+	}
+
+	var zone string
+	if c.Query("authoritative") != "false" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), dnsLookupTimeout)
+		defer cancel()
+		var servers []string
+		var err error
+		if zone, servers, err = authoritativeNameservers(ctx, name); err != nil {
+			// Reported as a failed target so the rest of the check still runs
+			targets = append(targets, dnsLookupTarget{server: "authoritative", kind: "authoritative", err: err.Error()})
+		}
+		for _, s := range servers {
+			targets = append(targets, dnsLookupTarget{server: s, kind: "authoritative"})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, "", errors.New("no resolvers to query")
+	}
+	return targets, zone, nil
+}
+
+// --- DNS Lookup Handlers ---
+
+// dnsLookupHandler resolves ?name= for ?type= (default A). Repeated ?expect=
+// values turn it into a propagation check.
+func dnsLookupHandler(c *gin.Context) {
+	name := strings.TrimSuffix(strings.TrimSpace(c.Query("name")), ".")
+	if err := validateRecordName(name); err != nil || name == "@" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be a valid domain name"})
+		return
+	}
+	recordType := strings.ToUpper(c.DefaultQuery("type", "A"))
+	if _, ok := dnsLookupTypes[recordType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of A, AAAA, CNAME, MX, TXT, NS, CAA or SRV"})
+		return
+	}
+
+	targets, zone, err := dnsLookupTargets(c, name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), dnsLookupTimeout)
+	defer cancel()
+	report := lookupDNS(ctx, name, recordType, targets, c.QueryArray("expect"))
+	report.Zone = zone
+	c.JSON(http.StatusOK, report)
+}
+
+// dnsPropagationHandler checks whether a record managed through the record
+// endpoints (?record_id=, with the usual ?provider= and ?zone=) is visible
+// on every resolver.
+func dnsPropagationHandler(c *gin.Context) {
+	id := c.Query("record_id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "record_id is required"})
+		return
+	}
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zoneID, ok := requestProviderZone(c, provider, c.Query("name"))
+	if !ok {
+		return
+	}
+	record, err := provider.GetRecord(c.Request.Context(), zoneID, id)
+	if err != nil {
+		dnsProviderError(c, "Failed to fetch record", err)
+		return
+	}
+	if _, ok := dnsLookupTypes[record.Type]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s records cannot be checked", record.Type)})
+		return
+	}
+
+	targets, zone, err := dnsLookupTargets(c, record.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Proxied records are answered with Cloudflare's addresses, so any
+	// answer means the record is live
+	expected := []string{dnsRecordValue(record)}
+	if record.Proxied {
+		expected = nil
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), dnsLookupTimeout)
+	defer cancel()
+	report := lookupDNS(ctx, record.Name, record.Type, targets, expected)
+	report.Zone = zone
+	if record.Proxied {
+		propagated := true
+		for _, r := range report.Results {
+			if r.Error != "" || len(r.Answers) == 0 {
+				propagated = false
+				report.Pending = append(report.Pending, r.Resolver)
+			}
+		}
+		report.Propagated = &propagated
+	}
+	c.JSON(http.StatusOK, gin.H{"record": record, "report": report})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"system-manager/dnsprovider"
+)
+
+func TestDNSRecordValueTXT(t *testing.T) {
+	tests := []struct{ content, want string }{
+		{`v=spf1 -all`, `v=spf1 -all`},
+		{`"v=spf1 -all"`, `v=spf1 -all`},
+		{`"v=DKIM1; k=rsa; p=MIIBIjAN" "BgkqhkiG9w0B"`, `v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0B`},
+		{`"a""b"`, `ab`},
+		{`"say \"hi\" \\ bye"`, `say "hi" \ bye`},
+		{`"unterminated`, `"unterminated`},
+		{`"quoted" trailing`, `"quoted" trailing`},
+		{`""`, ``},
+	}
+	for _, tt := range tests {
+		got := dnsRecordValue(dnsprovider.Record{Type: "TXT", Content: tt.content})
+		if got != tt.want {
+			t.Errorf("dnsRecordValue(TXT %s) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+
+	// The history compares records through dnsRecordValue too
+	quoted := dnsprovider.Record{Type: "TXT", Name: "example.com", Content: `"v=spf1" " -all"`}
+	plain := dnsprovider.Record{Type: "TXT", Name: "example.com.", Content: `v=spf1 -all`}
+	if !sameDNSRecord(quoted, plain) {
+		t.Error("quoted and plain TXT records with the same value differ")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"system-manager/cloudflare"
+	"system-manager/dnsprovider"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- DNS Providers ---
+//
+// Record endpoints work against any dnsprovider.Provider. Cloudflare is always
+// registered; an RFC 2136 server (BIND, PowerDNS, ...) is added when
+// RFC2136_SERVER is set. DNS_PROVIDER picks the default, and requests choose
+// with ?provider=<name>.
+
+var (
+	dnsProviders       = map[string]dnsprovider.Provider{}
+	defaultDNSProvider = "cloudflare"
+)
+
+func configureDNSProviders() {
+	dnsProviders["cloudflare"] = cloudflareProvider{}
+
+	if server := os.Getenv("RFC2136_SERVER"); server != "" {
+		cfg := dnsprovider.RFC2136Config{Server: server, Zones: strings.Split(os.Getenv("RFC2136_ZONES"), ",")}
+		if name := os.Getenv("RFC2136_TSIG_KEY"); name != "" {
+			cfg.Key = &dnsprovider.TSIGKey{
+				Name:      name,
+				Algorithm: os.Getenv("RFC2136_TSIG_ALGORITHM"),
+				Secret:    os.Getenv("RFC2136_TSIG_SECRET"),
+			}
+		}
+		if d, err := time.ParseDuration(os.Getenv("RFC2136_TIMEOUT")); err == nil && d > 0 {
+			cfg.Timeout = d
+		}
+		p, err := dnsprovider.NewRFC2136(cfg)
+		if err != nil {
+			fmt.Printf("DNS provider: %v\n", err)
+		} else {
+			dnsProviders[p.Name()] = p
+			fmt.Printf("DNS provider: rfc2136 at %s for %s\n", server, os.Getenv("RFC2136_ZONES"))
+		}
+	}
+
+	if name := os.Getenv("DNS_PROVIDER"); name != "" {
+		if _, ok := dnsProviders[name]; ok {
+			defaultDNSProvider = name
+		} else {
+			fmt.Printf("DNS provider: %s is not configured, using %s\n", name, defaultDNSProvider)
+		}
+	}
+}
+
+// useDNSProvider pins the provider for a route group (the /cloudflare
+// endpoints predate provider selection).
+func useDNSProvider(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("dns_provider", name)
+		c.Next()
+	}
+}
+
+// requestDNSProvider returns the provider a request is for, writing the error
+// response when it is not configured.
+func requestDNSProvider(c *gin.Context) (dnsprovider.Provider, bool) {
+	name := c.GetString("dns_provider")
+	if name == "" {
+		name = c.Query("provider")
+	}
+	if name == "" {
+		name = defaultDNSProvider
+	}
+	p, ok := dnsProviders[name]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("DNS provider %s is not configured", name)})
+		return nil, false
+	}
+	return p, true
+}
+
+// requestProviderZone resolves the zone query parameter against p.
+func requestProviderZone(c *gin.Context, p dnsprovider.Provider, name string) (string, bool) {
+	if _, ok := p.(cloudflareProvider); ok {
+		return requestZone(c, name)
+	}
+	zoneID, err := p.ResolveZone(c.Request.Context(), c.Query("zone"), name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return zoneID, true
+}
+
+// dnsProviderError writes the response for a failed provider call.
+func dnsProviderError(c *gin.Context, action string, err error) {
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) || errors.Is(err, cloudflare.ErrNoCredentials) {
+		cloudflareError(c, action, err)
+		return
+	}
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, dnsprovider.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, dnsprovider.ErrUnsupported):
+		status = http.StatusBadRequest
+	case errors.Is(err, dnsprovider.ErrRejected):
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"error": action + ": " + err.Error()})
+}
+
+// --- Cloudflare Provider ---
+
+// cloudflareProvider adapts cfClient and the zone selection in cloudflare.go.
+type cloudflareProvider struct{}
+
+func (cloudflareProvider) Name() string { return "cloudflare" }
+
+func (cloudflareProvider) ListZones(ctx context.Context) ([]dnsprovider.Zone, error) {
+	zones, err := listCloudflareZones(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]dnsprovider.Zone, 0, len(zones))
+	for _, z := range zones {
+		out = append(out, dnsprovider.Zone{ID: z.ID, Name: z.Name})
+	}
+	return out, nil
+}
+
+func (cloudflareProvider) ResolveZone(ctx context.Context, zone, name string) (string, error) {
+	return resolveCloudflareZone(ctx, zone, name)
+}
+
+func (cloudflareProvider) ListRecords(ctx context.Context, zoneID string, filter dnsprovider.Filter) ([]dnsprovider.Record, error) {
+	records, err := cfClient.ListDNSRecords(ctx, zoneID, cloudflare.RecordFilter{Type: filter.Type, Name: filter.Name})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]dnsprovider.Record, 0, len(records))
+	for _, r := range records {
+		out = append(out, providerRecordFromCloudflare(r))
+	}
+	return out, nil
+}
+
+func (cloudflareProvider) GetRecord(ctx context.Context, zoneID, id string) (dnsprovider.Record, error) {
+	record, err := cfClient.GetDNSRecord(ctx, zoneID, id)
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+	return providerRecordFromCloudflare(record), nil
+}
+
+func (cloudflareProvider) CreateRecord(ctx context.Context, zoneID string, record dnsprovider.Record) (dnsprovider.Record, error) {
+	created, err := cfClient.CreateDNSRecord(ctx, zoneID, cloudflareRecordFromProvider(record))
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+	return providerRecordFromCloudflare(created), nil
+}
+
+func (cloudflareProvider) UpdateRecord(ctx context.Context, zoneID, id string, record dnsprovider.Record) (dnsprovider.Record, error) {
+	updated, err := cfClient.UpdateDNSRecord(ctx, zoneID, id, cloudflareRecordFromProvider(record))
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+	return providerRecordFromCloudflare(updated), nil
+}
+
+func (cloudflareProvider) DeleteRecord(ctx context.Context, zoneID, id string) error {
+	return cfClient.DeleteDNSRecord(ctx, zoneID, id)
+}
+
+func providerRecordFromCloudflare(r cloudflare.DNSRecord) dnsprovider.Record {
+	return dnsprovider.Record{
+		ID: r.ID, Type: r.Type, Name: r.Name, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied,
+		Priority: r.Priority, Data: r.Data, Comment: r.Comment, Tags: r.Tags,
+	}
+}
+
+func cloudflareRecordFromProvider(r dnsprovider.Record) cloudflare.DNSRecord {
+	return cloudflare.DNSRecord{
+		ID: r.ID, Type: r.Type, Name: r.Name, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied,
+		Priority: r.Priority, Data: r.Data, Comment: r.Comment, Tags: r.Tags,
+	}
+}
+
+// --- DNS Provider Handlers ---
+
+func listDNSProvidersHandler(c *gin.Context) {
+	names := make([]string, 0, len(dnsProviders))
+	for name := range dnsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, gin.H{"providers": names, "default": defaultDNSProvider})
+}
+
+func listDNSZonesHandler(c *gin.Context) {
+	p, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zones, err := p.ListZones(c.Request.Context())
+	if err != nil {
+		dnsProviderError(c, "Failed to list zones", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"provider": p.Name(), "zones": zones})
+}
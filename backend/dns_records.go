@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"system-manager/dnsprovider"
+)
+
+// --- DNS Record Validation ---
+//
+// Records are checked before they are sent to Cloudflare so that mistakes are
+// reported per field instead of as an opaque API error.
+
+const (
+	maxTXTLength     = 2048
+	maxRecordComment = 100
+	maxRecordTags    = 20
+)
+
+var (
+	// Record names may be relative ("www"), "@" for the apex, wildcards and
+	// underscore labels (_dmarc, _sip._tcp).
+	recordNameLabel = regexp.MustCompile(`^(\*|_?[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)$`)
+	hostnameLabel   = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	recordTagRegex  = regexp.MustCompile(`^[a-zA-Z0-9_-]+:[^\s]*$|^[a-zA-Z0-9_-]+$`)
+	srvNameRegex    = regexp.MustCompile(`^_[a-zA-Z0-9-]+\._(tcp|udp|tls)(\.|$)`)
+)
+
+// proxiableTypes can be served through Cloudflare's proxy.
+var proxiableTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true}
+
+type SRVRecordData struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+type CAARecordData struct {
+	Flags uint8  `json:"flags"`
+	Tag   string `json:"tag"` // issue, issuewild, iodef
+	Value string `json:"value"`
+}
+
+// Validate checks the record for its type. Errors name the offending field.
+func (r *CloudflareRecordRequest) Validate() error {
+	r.Type = strings.ToUpper(strings.TrimSpace(r.Type))
+	r.Name = strings.TrimSpace(r.Name)
+	r.Content = strings.TrimSpace(r.Content)
+	// "data": null is what clients send for records without data
+	if string(bytes.TrimSpace(r.Data)) == "null" {
+		r.Data = nil
+	}
+
+	if err := validateRecordName(r.Name); err != nil {
+		return err
+	}
+	if r.TTL != 0 && r.TTL != 1 && (r.TTL < 60 || r.TTL > 86400) {
+		return errors.New("ttl must be 1 (auto) or between 60 and 86400")
+	}
+	if r.Proxied && !proxiableTypes[r.Type] {
+		return fmt.Errorf("proxied: %s records cannot be proxied", r.Type)
+	}
+	if r.Priority != nil && r.Type != "MX" {
+		return fmt.Errorf("priority is only used by MX records")
+	}
+	if len(r.Data) > 0 && r.Type != "SRV" && r.Type != "CAA" {
+		return fmt.Errorf("data is only used by SRV and CAA records")
+	}
+	if len(r.Comment) > maxRecordComment {
+		return fmt.Errorf("comment must be at most %d characters", maxRecordComment)
+	}
+	if len(r.Tags) > maxRecordTags {
+		return fmt.Errorf("at most %d tags are allowed", maxRecordTags)
+	}
+	for _, tag := range r.Tags {
+		if !recordTagRegex.MatchString(tag) {
+			return fmt.Errorf("tags: invalid tag %q (expected name or name:value)", tag)
+		}
+	}
+
+	switch r.Type {
+	case "A":
+		if ip := stdnet.ParseIP(r.Content); ip == nil || ip.To4() == nil || strings.Contains(r.Content, ":") {
+			return errors.New("content: A records require an IPv4 address")
+		}
+	case "AAAA":
+		if ip := stdnet.ParseIP(r.Content); ip == nil || !strings.Contains(r.Content, ":") {
+			return errors.New("content: AAAA records require an IPv6 address")
+		}
+	case "CNAME", "NS":
+		if !validHostname(r.Content) {
+			return fmt.Errorf("content: %s records require a hostname", r.Type)
+		}
+	case "MX":
+		if r.Priority == nil {
+			return errors.New("priority is required for MX records")
+		}
+		// A null MX ("." with priority 0) declares that the domain has no mail
+		if r.Content != "." && !validHostname(r.Content) {
+			return errors.New("content: MX records require a mail server hostname")
+		}
+	case "TXT":
+		if r.Content == "" {
+			return errors.New("content: TXT records cannot be empty")
+		}
+		if len(r.Content) > maxTXTLength {
+			return fmt.Errorf("content: TXT records are limited to %d characters", maxTXTLength)
+		}
+	case "SRV":
+		if !srvNameRegex.MatchString(r.Name) {
+			return errors.New("name: SRV records must be named _service._proto (e.g. _sip._tcp)")
+		}
+		var data SRVRecordData
+		if err := decodeRecordData(r.Data, &data); err != nil {
+			return err
+		}
+		if data.Port == 0 {
+			return errors.New("data.port is required for SRV records")
+		}
+		if data.Target != "." && !validHostname(data.Target) {
+			return errors.New("data.target must be a hostname")
+		}
+	case "CAA":
+		var data CAARecordData
+		if err := decodeRecordData(r.Data, &data); err != nil {
+			return err
+		}
+		if data.Flags != 0 && data.Flags != 128 {
+			return errors.New("data.flags must be 0 or 128")
+		}
+		switch data.Tag {
+		case "issue", "issuewild":
+			// An empty value (";") forbids issuance
+			if data.Value != ";" && data.Value != "" && !validHostname(strings.TrimSpace(strings.SplitN(data.Value, ";", 2)[0])) {
+				return errors.New("data.value must be a CA domain name")
+			}
+		case "iodef":
+			u, err := url.Parse(data.Value)
+			if err != nil || (u.Scheme != "mailto" && u.Scheme != "http" && u.Scheme != "https") {
+				return errors.New("data.value must be a mailto:, http: or https: URL for iodef")
+			}
+		default:
+			return errors.New("data.tag must be issue, issuewild or iodef")
+		}
+	case "":
+		return errors.New("type is required")
+	default:
+		return fmt.Errorf("unsupported record type %q", r.Type)
+	}
+	return nil
+}
+
+func validateRecordName(name string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if name == "@" {
+		return nil
+	}
+	trimmed := strings.TrimSuffix(name, ".")
+	if len(trimmed) > 253 {
+		return errors.New("name is too long")
+	}
+	for i, label := range strings.Split(trimmed, ".") {
+		if label == "*" && i != 0 {
+			return errors.New("name: a wildcard must be the first label")
+		}
+		if !recordNameLabel.MatchString(label) {
+			return fmt.Errorf("name: invalid label %q", label)
+		}
+	}
+	return nil
+}
+
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRecordData(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("data is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("data: %v", err)
+	}
+	return nil
+}
+
+// record converts a validated request for a DNS provider.
+func (r CloudflareRecordRequest) record() (dnsprovider.Record, error) {
+	// Default TTL if not set or 0
+	if r.TTL == 0 {
+		r.TTL = 1 // Auto
+	}
+	record := dnsprovider.Record{
+		Type: r.Type, Name: r.Name, Content: r.Content, Proxied: r.Proxied, TTL: r.TTL,
+		Priority: r.Priority, Comment: r.Comment, Tags: r.Tags,
+	}
+	if len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, &record.Data); err != nil {
+			return dnsprovider.Record{}, fmt.Errorf("data: %v", err)
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecordRequestNullData(t *testing.T) {
+	req := CloudflareRecordRequest{Type: "A", Name: "www", Content: "192.0.2.1", Data: []byte(" null ")}
+	if err := req.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	record, err := req.record()
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if record.Data != nil {
+		t.Errorf("record data = %v, want none", record.Data)
+	}
+
+	srv := CloudflareRecordRequest{Type: "SRV", Name: "_sip._tcp", Data: []byte(`{"priority":10,"weight":5,"port":5060,"target":"sip.example.com"}`)}
+	if err := srv.Validate(); err != nil {
+		t.Fatalf("Validate SRV: %v", err)
+	}
+	if record, err := srv.record(); err != nil || record.Data["port"] != float64(5060) {
+		t.Errorf("SRV record = %v, %v", record.Data, err)
+	}
+
+	bad := CloudflareRecordRequest{Type: "CAA", Name: "@", Data: []byte(`[1,2]`)}
+	if _, err := bad.record(); err == nil {
+		t.Error("record accepted malformed data")
+	}
+}
+
+func TestRecordRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string // Substring of the error, empty when valid
+	}{
+		{"A", `{"type":"a","name":"www","content":"192.0.2.1","proxied":true,"ttl":1}`, ""},
+		{"A apex", `{"type":"A","name":"@","content":"192.0.2.1"}`, ""},
+		{"A with IPv6", `{"type":"A","name":"www","content":"2001:db8::1"}`, "IPv4"},
+		{"A with IPv4-mapped IPv6", `{"type":"A","name":"www","content":"::ffff:192.0.2.1"}`, "IPv4"},
+		{"AAAA", `{"type":"AAAA","name":"www","content":"2001:db8::1","proxied":true}`, ""},
+		{"AAAA with IPv4", `{"type":"AAAA","name":"www","content":"192.0.2.1"}`, "IPv6"},
+		{"CNAME", `{"type":"CNAME","name":"blog","content":"example.github.io","proxied":true}`, ""},
+		{"CNAME to URL", `{"type":"CNAME","name":"blog","content":"https://example.com"}`, "hostname"},
+		{"NS", `{"type":"NS","name":"sub","content":"ns1.example.net"}`, ""},
+		{"NS proxied", `{"type":"NS","name":"sub","content":"ns1.example.net","proxied":true}`, "cannot be proxied"},
+		{"MX", `{"type":"MX","name":"@","content":"mail.example.com","priority":10,"ttl":3600}`, ""},
+		{"MX priority 0", `{"type":"MX","name":"@","content":"mail.example.com","priority":0}`, ""},
+		{"null MX", `{"type":"MX","name":"@","content":".","priority":0}`, ""},
+		{"MX without priority", `{"type":"MX","name":"@","content":"mail.example.com"}`, "priority is required"},
+		{"MX proxied", `{"type":"MX","name":"@","content":"mail.example.com","priority":10,"proxied":true}`, "cannot be proxied"},
+		{"priority on A", `{"type":"A","name":"www","content":"192.0.2.1","priority":10}`, "only used by MX"},
+		{"TXT", `{"type":"TXT","name":"@","content":"v=spf1 -all","proxied":false}`, ""},
+		{"TXT proxied", `{"type":"TXT","name":"@","content":"v=spf1 -all","proxied":true}`, "cannot be proxied"},
+		{"TXT empty", `{"type":"TXT","name":"@","content":""}`, "cannot be empty"},
+		{"TXT too long", `{"type":"TXT","name":"@","content":"` + strings.Repeat("a", maxTXTLength+1) + `"}`, "limited"},
+		{"SRV", `{"type":"SRV","name":"_sip._tcp","data":{"priority":10,"weight":5,"port":5060,"target":"sip.example.com"}}`, ""},
+		{"SRV bad name", `{"type":"SRV","name":"sip","data":{"port":5060,"target":"sip.example.com"}}`, "_service._proto"},
+		{"SRV without port", `{"type":"SRV","name":"_sip._udp","data":{"target":"sip.example.com"}}`, "data.port"},
+		{"SRV unknown field", `{"type":"SRV","name":"_sip._tcp","data":{"port":5060,"target":"sip.example.com","prio":1}}`, "data"},
+		{"CAA", `{"type":"CAA","name":"@","data":{"flags":0,"tag":"issue","value":"letsencrypt.org"}}`, ""},
+		{"CAA forbid", `{"type":"CAA","name":"@","data":{"flags":128,"tag":"issuewild","value":";"}}`, ""},
+		{"CAA iodef", `{"type":"CAA","name":"@","data":{"flags":0,"tag":"iodef","value":"mailto:ops@example.com"}}`, ""},
+		{"CAA bad tag", `{"type":"CAA","name":"@","data":{"flags":0,"tag":"issuer","value":"letsencrypt.org"}}`, "data.tag"},
+		{"CAA bad flags", `{"type":"CAA","name":"@","data":{"flags":1,"tag":"issue","value":"letsencrypt.org"}}`, "data.flags"},
+		{"data on A", `{"type":"A","name":"www","content":"192.0.2.1","data":{"port":1}}`, "only used by SRV and CAA"},
+		{"wildcard", `{"type":"A","name":"*.dev","content":"192.0.2.1"}`, ""},
+		{"wildcard not first", `{"type":"A","name":"dev.*","content":"192.0.2.1"}`, "wildcard"},
+		{"underscore label", `{"type":"TXT","name":"_dmarc","content":"v=DMARC1; p=none"}`, ""},
+		{"bad label", `{"type":"A","name":"bad name","content":"192.0.2.1"}`, "invalid label"},
+		{"missing name", `{"type":"A","content":"192.0.2.1"}`, "name is required"},
+		{"ttl too low", `{"type":"A","name":"www","content":"192.0.2.1","ttl":30}`, "ttl"},
+		{"ttl too high", `{"type":"A","name":"www","content":"192.0.2.1","ttl":86401}`, "ttl"},
+		{"bad tag", `{"type":"A","name":"www","content":"192.0.2.1","tags":["has space"]}`, "tags"},
+		{"missing type", `{"name":"www","content":"192.0.2.1"}`, "type is required"},
+		{"unsupported type", `{"type":"PTR","name":"www","content":"host.example.com"}`, "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req CloudflareRecordRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			err := req.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate accepted the record, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
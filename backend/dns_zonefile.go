@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"system-manager/cloudflare"
+	"system-manager/dnsprovider"
+	"system-manager/zonefile"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- DNS Zone Export/Import ---
+//
+// Zones are exported as BIND zone files. An import is diffed against the
+// records in Cloudflare and turned into a plan of creates, updates and
+// deletes, which is only applied when dry_run is not set. Proxied records
+// carry Cloudflare's own marker comment, "cf_tags=cf-proxied:true".
+
+const (
+	maxZoneFileSize   = 4 << 20
+	cfProxiedMarker   = "cf-proxied:true"
+	zoneImportTimeout = 5 * time.Minute
+)
+
+// zoneRecordTypes are the types that can be exported and imported. Records
+// of other types are left alone.
+var zoneRecordTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "MX": true, "TXT": true, "NS": true, "SRV": true, "CAA": true}
+
+type ZoneRecordUpdate struct {
+	ID     string               `json:"id"`
+	Before cloudflare.DNSRecord `json:"before"`
+	After  cloudflare.DNSRecord `json:"after"`
+}
+
+type ZoneImportSkip struct {
+	Line   int    `json:"line,omitempty"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type ZoneImportPlan struct {
+	Zone      string                 `json:"zone"`
+	ZoneID    string                 `json:"zone_id"`
+	Creates   []cloudflare.DNSRecord `json:"creates"`
+	Updates   []ZoneRecordUpdate     `json:"updates"`
+	Deletes   []cloudflare.DNSRecord `json:"deletes"`
+	Unchanged int                    `json:"unchanged"`
+	Skipped   []ZoneImportSkip       `json:"skipped"`
+}
+
+// --- Conversion ---
+
+// txtValue undoes the quoting Cloudflare may return TXT content with: one or
+// more quoted strings (long values come back as "chunk1" "chunk2"), with
+// backslash escapes. Content that is not entirely quoted is returned as is.
+func txtValue(content string) string {
+	s := strings.TrimSpace(content)
+	if !strings.HasPrefix(s, `"`) {
+		return content
+	}
+	var out strings.Builder
+	for s != "" {
+		if s[0] != '"' {
+			return content
+		}
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			out.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return content // Unterminated
+		}
+		s = strings.TrimLeft(s[i+1:], " \t")
+	}
+	return out.String()
+}
+
+func dataField(data map[string]interface{}, key string) string {
+	switch v := data[key].(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func fqdn(name string) string {
+	if name == "." {
+		return name
+	}
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// zoneRecordFromCloudflare renders a record for the zone file.
+func zoneRecordFromCloudflare(r cloudflare.DNSRecord) (zonefile.Record, bool) {
+	rec := zonefile.Record{Name: r.Name, TTL: uint32(r.TTL), Class: "IN", Type: r.Type}
+	switch r.Type {
+	case "A", "AAAA":
+		rec.RData = []string{r.Content}
+	case "CNAME", "NS":
+		rec.RData = []string{fqdn(r.Content)}
+	case "MX":
+		var priority uint16
+		if r.Priority != nil {
+			priority = *r.Priority
+		}
+		rec.RData = []string{strconv.Itoa(int(priority)), fqdn(r.Content)}
+	case "TXT":
+		rec.RData = []string{zonefile.Quote(txtValue(r.Content))}
+	case "SRV":
+		rec.RData = []string{dataField(r.Data, "priority"), dataField(r.Data, "weight"), dataField(r.Data, "port"), fqdn(dataField(r.Data, "target"))}
+	case "CAA":
+		rec.RData = []string{dataField(r.Data, "flags"), dataField(r.Data, "tag"), zonefile.Quote(dataField(r.Data, "value"))}
+	default:
+		return rec, false
+	}
+	if r.Proxied {
+		rec.Comment = "cf_tags=" + cfProxiedMarker
+	}
+	return rec, true
+}
+
+// recordRequestFromZone turns a zone file record into a validated request.
+func recordRequestFromZone(z zonefile.Record) (CloudflareRecordRequest, error) {
+	req := CloudflareRecordRequest{Type: z.Type, Name: z.Name, TTL: int(z.TTL)}
+	switch {
+	case req.TTL < 60:
+		req.TTL = 1 // Automatic
+	case req.TTL > 86400:
+		req.TTL = 86400 // Cloudflare's maximum; files often use $TTL 1w
+	}
+	req.Proxied = strings.Contains(z.Comment, cfProxiedMarker)
+
+	need := func(n int) error {
+		if len(z.RData) != n {
+			return fmt.Errorf("%s record needs %d fields, got %d", z.Type, n, len(z.RData))
+		}
+		return nil
+	}
+	parseUint := func(s string, bits int) (uint64, error) {
+		v, err := strconv.ParseUint(s, 10, bits)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+		return v, nil
+	}
+
+	switch z.Type {
+	case "A", "AAAA":
+		if err := need(1); err != nil {
+			return req, err
+		}
+		req.Content = z.RData[0]
+	case "CNAME", "NS":
+		if err := need(1); err != nil {
+			return req, err
+		}
+		req.Content = zonefile.Absolute(z.RData[0], z.Origin)
+	case "MX":
+		if err := need(2); err != nil {
+			return req, err
+		}
+		priority, err := parseUint(z.RData[0], 16)
+		if err != nil {
+			return req, err
+		}
+		p := uint16(priority)
+		req.Priority = &p
+		req.Content = z.RData[1]
+		if req.Content != "." {
+			req.Content = zonefile.Absolute(req.Content, z.Origin)
+		}
+	case "TXT":
+		req.Content = strings.Join(z.RData, "")
+	case "SRV":
+		if err := need(4); err != nil {
+			return req, err
+		}
+		data := map[string]interface{}{"target": strings.TrimSuffix(z.RData[3], ".")}
+		for i, key := range []string{"priority", "weight", "port"} {
+			v, err := parseUint(z.RData[i], 16)
+			if err != nil {
+				return req, err
+			}
+			data[key] = v
+		}
+		req.Data, _ = json.Marshal(data)
+	case "CAA":
+		if err := need(3); err != nil {
+			return req, err
+		}
+		flags, err := parseUint(z.RData[0], 8)
+		if err != nil {
+			return req, err
+		}
+		req.Data, _ = json.Marshal(map[string]interface{}{"flags": flags, "tag": z.RData[1], "value": z.RData[2]})
+	}
+	return req, req.Validate()
+}
+
+// recordValue is the comparable identity of a record's data.
+func recordValue(r cloudflare.DNSRecord) string {
+	switch r.Type {
+	case "A", "AAAA":
+		if ip := stdnet.ParseIP(r.Content); ip != nil {
+			return ip.String()
+		}
+	case "CNAME", "NS":
+		return strings.ToLower(strings.TrimSuffix(r.Content, "."))
+	case "MX":
+		priority := 0
+		if r.Priority != nil {
+			priority = int(*r.Priority)
+		}
+		return fmt.Sprintf("%d %s", priority, strings.ToLower(strings.TrimSuffix(r.Content, ".")))
+	case "TXT":
+		return txtValue(r.Content)
+	case "SRV":
+		return strings.Join([]string{dataField(r.Data, "priority"), dataField(r.Data, "weight"), dataField(r.Data, "port"), strings.ToLower(strings.TrimSuffix(dataField(r.Data, "target"), "."))}, " ")
+	case "CAA":
+		return strings.Join([]string{dataField(r.Data, "flags"), strings.ToLower(dataField(r.Data, "tag")), dataField(r.Data, "value")}, " ")
+	}
+	return r.Content
+}
+
+// --- Planning ---
+
+// planZoneImport diffs the desired records against the zone. Existing
+// records absent from the file are deleted unless keepMissing is set. A
+// type+name group with a skipped entry is never pruned: the skipped record
+// may well be the live one.
+func planZoneImport(zone cloudflare.Zone, existing []cloudflare.DNSRecord, records []zonefile.Record, keepMissing bool) ZoneImportPlan {
+	plan := ZoneImportPlan{
+		Zone: zone.Name, ZoneID: zone.ID,
+		Creates: []cloudflare.DNSRecord{}, Updates: []ZoneRecordUpdate{}, Deletes: []cloudflare.DNSRecord{}, Skipped: []ZoneImportSkip{},
+	}
+
+	groupKey := func(recordType, name string) string {
+		return recordType + " " + strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+	desired := make(map[string][]cloudflare.DNSRecord)
+	partial := make(map[string]bool)
+	for _, z := range records {
+		skip := ZoneImportSkip{Line: z.Line, Name: z.Name, Type: z.Type}
+		switch {
+		case z.Type == "SOA":
+			skip.Reason = "SOA is managed by Cloudflare"
+		case z.Type == "NS" && strings.EqualFold(z.Name, zone.Name):
+			skip.Reason = "apex NS records are managed by Cloudflare"
+		case !zoneRecordTypes[z.Type]:
+			skip.Reason = "unsupported record type"
+		case z.Name != zone.Name && !strings.HasSuffix(strings.ToLower(z.Name), "."+strings.ToLower(zone.Name)):
+			skip.Reason = "name is outside the zone"
+		}
+		if skip.Reason == "" {
+			req, err := recordRequestFromZone(z)
+			var record dnsprovider.Record
+			if err == nil {
+				record, err = req.record()
+			}
+			if err == nil {
+				desired[groupKey(req.Type, req.Name)] = append(desired[groupKey(req.Type, req.Name)], cloudflareRecordFromProvider(record))
+				continue
+			}
+			skip.Reason = err.Error()
+		}
+		plan.Skipped = append(plan.Skipped, skip)
+		partial[groupKey(z.Type, z.Name)] = true
+	}
+
+	current := make(map[string][]cloudflare.DNSRecord)
+	for _, r := range existing {
+		if zoneRecordTypes[r.Type] {
+			current[groupKey(r.Type, r.Name)] = append(current[groupKey(r.Type, r.Name)], r)
+		}
+	}
+
+	keys := make([]string, 0, len(desired)+len(current))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	update := func(before, after cloudflare.DNSRecord) {
+		// Keep what the file cannot express
+		after.Comment, after.Tags = before.Comment, before.Tags
+		plan.Updates = append(plan.Updates, ZoneRecordUpdate{ID: before.ID, Before: before, After: after})
+	}
+	for _, k := range keys {
+		want, have := desired[k], current[k]
+		matched := make([]bool, len(have))
+		var unmatched []cloudflare.DNSRecord
+		for _, d := range want {
+			found := false
+			for i, e := range have {
+				if matched[i] || recordValue(e) != recordValue(d) {
+					continue
+				}
+				matched[i], found = true, true
+				if e.TTL != d.TTL || e.Proxied != d.Proxied {
+					update(e, d)
+				} else {
+					plan.Unchanged++
+				}
+				break
+			}
+			if !found {
+				unmatched = append(unmatched, d)
+			}
+		}
+		// Pair leftovers into in-place updates, then create or delete the rest
+		for i, e := range have {
+			if matched[i] || partial[k] {
+				continue
+			}
+			if len(unmatched) > 0 {
+				update(e, unmatched[0])
+				unmatched = unmatched[1:]
+			} else if !keepMissing {
+				plan.Deletes = append(plan.Deletes, e)
+			}
+		}
+		plan.Creates = append(plan.Creates, unmatched...)
+	}
+	return plan
+}
+
+// applyZoneImport runs the plan: deletes first so that a CNAME can replace
+// other records, then updates and creates. It stops at the first error.
+func applyZoneImport(ctx context.Context, plan ZoneImportPlan, author string) (int, error) {
+	applied := 0
+	for _, r := range plan.Deletes {
+		if err := cfClient.DeleteDNSRecord(ctx, plan.ZoneID, r.ID); err != nil {
+			return applied, fmt.Errorf("delete %s %s: %w", r.Type, r.Name, err)
+		}
+		before := providerRecordFromCloudflare(r)
+		logDNSChange("cloudflare", plan.ZoneID, "delete", "import", &before, nil, author)
+		applied++
+	}
+	for _, u := range plan.Updates {
+		updated, err := cfClient.UpdateDNSRecord(ctx, plan.ZoneID, u.ID, u.After)
+		if err != nil {
+			return applied, fmt.Errorf("update %s %s: %w", u.After.Type, u.After.Name, err)
+		}
+		before, after := providerRecordFromCloudflare(u.Before), providerRecordFromCloudflare(updated)
+		logDNSChange("cloudflare", plan.ZoneID, "update", "import", &before, &after, author)
+		applied++
+	}
+	for _, r := range plan.Creates {
+		created, err := cfClient.CreateDNSRecord(ctx, plan.ZoneID, r)
+		if err != nil {
+			return applied, fmt.Errorf("create %s %s: %w", r.Type, r.Name, err)
+		}
+		after := providerRecordFromCloudflare(created)
+		logDNSChange("cloudflare", plan.ZoneID, "create", "import", nil, &after, author)
+		applied++
+	}
+	return applied, nil
+}
+
+// --- Zone File Handlers ---
+
+func requestZoneInfo(c *gin.Context) (cloudflare.Zone, bool) {
+	zoneID, ok := requestZone(c, "")
+	if !ok {
+		return cloudflare.Zone{}, false
+	}
+	zone, err := cfClient.GetZone(c.Request.Context(), zoneID)
+	if err != nil {
+		cloudflareError(c, "Failed to fetch zone", err)
+		return cloudflare.Zone{}, false
+	}
+	return zone, true
+}
+
+func exportDNSZone(c *gin.Context) {
+	zone, ok := requestZoneInfo(c)
+	if !ok {
+		return
+	}
+	records, err := cfClient.ListDNSRecords(c.Request.Context(), zone.ID, cloudflare.RecordFilter{})
+	if err != nil {
+		cloudflareError(c, "Failed to fetch records", err)
+		return
+	}
+
+	var out []zonefile.Record
+	var skipped []string
+	for _, r := range records {
+		if rec, ok := zoneRecordFromCloudflare(r); ok {
+			out = append(out, rec)
+		} else {
+			skipped = append(skipped, r.Type+" "+r.Name)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Type < out[j].Type
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, ";; Zone %s exported from Cloudflare on %s\n", zone.Name, time.Now().UTC().Format(time.RFC3339))
+	for _, s := range skipped {
+		fmt.Fprintf(&buf, ";; Not exported (unsupported type): %s\n", s)
+	}
+	zonefile.Write(&buf, zone.Name, 300, out)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", zone.Name+".zone"))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+// importDNSZone takes the zone file as the request body. Query parameters:
+// dry_run=true returns the plan only, keep_missing=true does not delete
+// records that are absent from the file.
+func importDNSZone(c *gin.Context) {
+	zone, ok := requestZoneInfo(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxZoneFileSize+1))
+	if err != nil || len(body) > maxZoneFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Zone file missing or too large"})
+		return
+	}
+	records, err := zonefile.Parse(bytes.NewReader(body), zone.Name, 1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone file: " + err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), zoneImportTimeout)
+	defer cancel()
+	existing, err := cfClient.ListDNSRecords(ctx, zone.ID, cloudflare.RecordFilter{})
+	if err != nil {
+		cloudflareError(c, "Failed to fetch records", err)
+		return
+	}
+	plan := planZoneImport(zone, existing, records, c.Query("keep_missing") == "true")
+
+	if c.Query("dry_run") == "true" {
+		c.JSON(http.StatusOK, gin.H{"status": "dry_run", "plan": plan})
+		return
+	}
+	applied, err := applyZoneImport(ctx, plan, requestUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Import stopped: " + err.Error(), "applied": applied, "plan": plan})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "applied": applied, "plan": plan})
+}
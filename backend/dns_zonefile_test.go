@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"system-manager/cloudflare"
+	"system-manager/zonefile"
+)
+
+func TestPlanZoneImport(t *testing.T) {
+	zone := cloudflare.Zone{ID: "023e105f4ecef8ad9ca31a8372d0c353", Name: "example.com"}
+	existing := []cloudflare.DNSRecord{
+		{ID: "a1", Type: "A", Name: "example.com", Content: "192.0.2.1", TTL: 3600},
+		{ID: "a2", Type: "A", Name: "www.example.com", Content: "192.0.2.2", TTL: 300},
+		{ID: "t1", Type: "TXT", Name: "s1_key.example.com", Content: "v=DKIM1; p=abc", TTL: 1},
+		{ID: "c1", Type: "CAA", Name: "example.com", TTL: 1, Data: map[string]interface{}{"flags": float64(0), "tag": "issue", "value": "letsencrypt.org"}},
+		{ID: "c2", Type: "CAA", Name: "example.com", TTL: 1, Data: map[string]interface{}{"flags": float64(0), "tag": "contactemail", "value": "ops@example.com"}},
+		{ID: "m1", Type: "MX", Name: "example.com", Content: "old-mx.example.com", TTL: 1, Priority: func() *uint16 { p := uint16(10); return &p }()},
+		{ID: "g1", Type: "A", Name: "gone.example.com", Content: "192.0.2.9", TTL: 1},
+	}
+	file := `$ORIGIN example.com.
+$TTL 1w
+@        IN A     192.0.2.1
+www  2d  IN A     192.0.2.2
+s1_key   IN TXT   "v=DKIM1; p=abc"
+@        IN CAA   0 issue "letsencrypt.org"
+@        IN CAA   0 contactemail "ops@example.com"
+@    300 IN MX    10 mx.example.com.
+`
+	records, err := zonefile.Parse(strings.NewReader(file), "example.com", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := planZoneImport(zone, existing, records, false)
+
+	// TTLs above Cloudflare's maximum are clamped, not skipped
+	updated := map[string]int{}
+	for _, u := range plan.Updates {
+		updated[u.ID] = u.After.TTL
+	}
+	if updated["a1"] != 86400 || updated["a2"] != 86400 {
+		t.Errorf("updates = %v, want a1 and a2 clamped to 86400", updated)
+	}
+
+	// Records whose group had a skipped entry are kept; only the record that
+	// is really gone from the file is deleted
+	var deleted []string
+	for _, d := range plan.Deletes {
+		deleted = append(deleted, d.ID)
+	}
+	sort.Strings(deleted)
+	if strings.Join(deleted, ",") != "g1" {
+		t.Errorf("deletes = %v, want only g1", deleted)
+	}
+	skipped := map[string]bool{}
+	for _, s := range plan.Skipped {
+		skipped[s.Type+" "+s.Name] = true
+	}
+	for _, want := range []string{"TXT s1_key.example.com", "CAA example.com"} {
+		if !skipped[want] {
+			t.Errorf("%s was not reported as skipped: %+v", want, plan.Skipped)
+		}
+	}
+
+	// The MX group is complete, so its old record is replaced in place
+	if _, ok := updated["m1"]; !ok {
+		t.Errorf("MX was not updated: %+v", plan.Updates)
+	}
+	for _, c := range plan.Creates {
+		if c.Type == "CAA" && dataField(c.Data, "tag") == "issue" {
+			t.Errorf("existing CAA issue record was created again")
+		}
+	}
+
+	keep := planZoneImport(zone, existing, records, true)
+	if len(keep.Deletes) != 0 {
+		t.Errorf("keep_missing plan deletes %v", keep.Deletes)
+	}
+}
@@ -0,0 +1,99 @@
+// Package dnsprovider defines the interface the DNS manager uses to read and
+// change records, so zones hosted outside Cloudflare can be managed too.
+package dnsprovider
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNotFound    = errors.New("dns provider: not found")
+	ErrUnsupported = errors.New("dns provider: not supported")
+	ErrRejected    = errors.New("dns provider: request rejected")
+)
+
+type Zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Record is a provider independent DNS record. Fields a provider has no use
+// for (Proxied, Comment, Tags outside Cloudflare) must be left empty.
+type Record struct {
+	ID       string                 `json:"id,omitempty"`
+	Type     string                 `json:"type"`
+	Name     string                 `json:"name"`
+	Content  string                 `json:"content,omitempty"`
+	TTL      int                    `json:"ttl"`
+	Proxied  bool                   `json:"proxied"`
+	Priority *uint16                `json:"priority,omitempty"` // MX
+	Data     map[string]interface{} `json:"data,omitempty"`     // SRV and CAA fields
+	Comment  string                 `json:"comment,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+}
+
+// Filter narrows ListRecords. Empty fields match everything.
+type Filter struct {
+	Type string
+	Name string // Relative to the zone or fully qualified, as in Qualify
+}
+
+// Matches reports whether r, a record of zone, passes the filter.
+func (f Filter) Matches(zone string, r Record) bool {
+	if f.Type != "" && !strings.EqualFold(f.Type, r.Type) {
+		return false
+	}
+	if f.Name != "" && !strings.EqualFold(Qualify(f.Name, zone), Qualify(r.Name, zone)) {
+		return false
+	}
+	return true
+}
+
+// Qualify returns name as a fully qualified name without the trailing dot,
+// reading it the way Cloudflare does: "@" is the zone apex, and a name that
+// does not end in the zone name is relative to it.
+func Qualify(name, zone string) string {
+	name = strings.TrimSpace(name)
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	if name == "" || name == "@" {
+		return zone
+	}
+	lower := strings.ToLower(name)
+	if zone == "" || lower == zone || strings.HasSuffix(lower, "."+zone) {
+		return name
+	}
+	return name + "." + zone
+}
+
+// Provider manages the records of one or more zones. Zones are referred to
+// by the ID returned from ListZones or ResolveZone.
+type Provider interface {
+	Name() string
+	ListZones(ctx context.Context) ([]Zone, error)
+	// ResolveZone turns a zone ID or name into a zone ID. An empty zone is
+	// selected from the record name.
+	ResolveZone(ctx context.Context, zone, name string) (string, error)
+	ListRecords(ctx context.Context, zoneID string, filter Filter) ([]Record, error)
+	GetRecord(ctx context.Context, zoneID, id string) (Record, error)
+	CreateRecord(ctx context.Context, zoneID string, record Record) (Record, error)
+	UpdateRecord(ctx context.Context, zoneID, id string, record Record) (Record, error)
+	DeleteRecord(ctx context.Context, zoneID, id string) error
+}
+
+// ZoneForName returns the zone with the longest name that name falls under.
+func ZoneForName(zones []Zone, name string) (Zone, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var best Zone
+	for _, z := range zones {
+		zoneName := strings.ToLower(strings.TrimSuffix(z.Name, "."))
+		if (name == zoneName || strings.HasSuffix(name, "."+zoneName)) && len(zoneName) > len(best.Name) {
+			best = z
+		}
+	}
+	return best, best.ID != ""
+}
@@ -0,0 +1,639 @@
+package dnsprovider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// --- RFC 2136 Dynamic Update ---
+//
+// Works with BIND, PowerDNS, Knot and anything else that accepts signed
+// dynamic updates. Records are listed with a zone transfer (AXFR), so the
+// server must allow transfers to the same TSIG key. DNS has no record IDs;
+// the ID handed out encodes the record data, which is what an update or
+// delete has to match. Record names may be relative to the zone ("www", "@")
+// as they can be with Cloudflare; they are qualified before they are sent.
+
+const (
+	defaultRFC2136Timeout = 10 * time.Second
+	typeCAA               = dnsmessage.Type(257)
+	classNONE             = dnsmessage.Class(254)
+	opcodeUpdate          = dnsmessage.OpCode(5)
+	maxTXTChunk           = 255
+)
+
+type RFC2136Config struct {
+	Server  string   // host or host:port of the primary, port 53 by default
+	Zones   []string // Zones this server is authoritative for
+	Key     *TSIGKey // Unsigned updates when nil
+	Timeout time.Duration
+}
+
+type RFC2136 struct {
+	server  string
+	zones   []Zone
+	signer  *tsigSigner
+	timeout time.Duration
+}
+
+func NewRFC2136(cfg RFC2136Config) (*RFC2136, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("rfc2136: server is required")
+	}
+	p := &RFC2136{server: cfg.Server, timeout: cfg.Timeout}
+	if _, _, err := net.SplitHostPort(p.server); err != nil {
+		p.server = net.JoinHostPort(p.server, "53")
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultRFC2136Timeout
+	}
+	for _, z := range cfg.Zones {
+		z = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(z), "."))
+		if z != "" {
+			p.zones = append(p.zones, Zone{ID: z, Name: z})
+		}
+	}
+	if len(p.zones) == 0 {
+		return nil, errors.New("rfc2136: at least one zone is required")
+	}
+	if cfg.Key != nil {
+		signer, err := newTSIGSigner(*cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: %v", err)
+		}
+		p.signer = signer
+	}
+	return p, nil
+}
+
+func (p *RFC2136) Name() string { return "rfc2136" }
+
+func (p *RFC2136) ListZones(ctx context.Context) ([]Zone, error) {
+	return append([]Zone(nil), p.zones...), nil
+}
+
+func (p *RFC2136) ResolveZone(ctx context.Context, zone, name string) (string, error) {
+	zone = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(zone), "."))
+	if zone != "" {
+		for _, z := range p.zones {
+			if z.ID == zone {
+				return z.ID, nil
+			}
+		}
+		return "", fmt.Errorf("%w: zone %s is not configured", ErrNotFound, zone)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "@" {
+		if len(p.zones) == 1 {
+			return p.zones[0].ID, nil
+		}
+		return "", errors.New("zone is required")
+	}
+	if z, ok := ZoneForName(p.zones, name); ok {
+		return z.ID, nil
+	}
+	// Anything else is relative, which only says which zone it is in when
+	// there is just one
+	if !strings.HasSuffix(name, ".") {
+		if len(p.zones) == 1 {
+			return p.zones[0].ID, nil
+		}
+		return "", fmt.Errorf("zone is required: %s is not under any configured zone", name)
+	}
+	return "", fmt.Errorf("%w: no zone matches %s", ErrNotFound, name)
+}
+
+// ListRecords transfers the zone and returns the records the DNS manager
+// understands (SOA and unknown types are left out).
+func (p *RFC2136) ListRecords(ctx context.Context, zoneID string, filter Filter) ([]Record, error) {
+	zoneName, err := dnsmessage.NewName(fqdn(zoneID))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := newMessage(dnsmessage.Header{}, dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	soaSeen := 0
+	err = p.exchange(ctx, msg, func(resp []byte) (bool, error) {
+		var parser dnsmessage.Parser
+		h, err := parser.Start(resp)
+		if err != nil {
+			return false, err
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return false, rcodeError("zone transfer", h.RCode)
+		}
+		if err := parser.SkipAllQuestions(); err != nil {
+			return false, err
+		}
+		answers, err := parser.AllAnswers()
+		if err != nil {
+			return false, err
+		}
+		for _, rr := range answers {
+			if rr.Header.Type == dnsmessage.TypeSOA {
+				soaSeen++
+				continue
+			}
+			if r, ok := recordFromResource(rr); ok && filter.Matches(zoneID, r) {
+				records = append(records, r)
+			}
+		}
+		// A transfer starts and ends with the SOA record
+		return soaSeen >= 2, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (p *RFC2136) CreateRecord(ctx context.Context, zoneID string, record Record) (Record, error) {
+	if err := checkSupported(record); err != nil {
+		return Record{}, err
+	}
+	record.Name = Qualify(record.Name, zoneID)
+	if err := p.update(ctx, zoneID, nil, &record); err != nil {
+		return Record{}, err
+	}
+	record.ID = recordID(record)
+	return record, nil
+}
+
+func (p *RFC2136) UpdateRecord(ctx context.Context, zoneID, id string, record Record) (Record, error) {
+	if err := checkSupported(record); err != nil {
+		return Record{}, err
+	}
+	record.Name = Qualify(record.Name, zoneID)
+	old, err := p.GetRecord(ctx, zoneID, id)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := p.update(ctx, zoneID, &old, &record); err != nil {
+		return Record{}, err
+	}
+	record.ID = recordID(record)
+	return record, nil
+}
+
+func (p *RFC2136) DeleteRecord(ctx context.Context, zoneID, id string) error {
+	old, err := p.GetRecord(ctx, zoneID, id)
+	if err != nil {
+		return err
+	}
+	return p.update(ctx, zoneID, &old, nil)
+}
+
+func checkSupported(r Record) error {
+	if r.Proxied {
+		return fmt.Errorf("%w: proxied records are a Cloudflare feature", ErrUnsupported)
+	}
+	if r.Comment != "" || len(r.Tags) > 0 {
+		return fmt.Errorf("%w: comments and tags are a Cloudflare feature", ErrUnsupported)
+	}
+	return nil
+}
+
+// update sends one UPDATE message that deletes remove and adds add (either
+// may be nil).
+func (p *RFC2136) update(ctx context.Context, zoneID string, remove, add *Record) error {
+	zoneName, err := dnsmessage.NewName(fqdn(zoneID))
+	if err != nil {
+		return err
+	}
+	var updates []rr
+	if remove != nil {
+		updates = append(updates, rr{record: *remove, class: classNONE, ttl: 0})
+	}
+	if add != nil {
+		ttl := add.TTL
+		if ttl <= 1 {
+			ttl = 300 // No "automatic" TTL outside Cloudflare
+			add.TTL = ttl
+		}
+		updates = append(updates, rr{record: *add, class: dnsmessage.ClassINET, ttl: uint32(ttl)})
+	}
+	// The zone section reuses the question section: zone name, type SOA
+	msg, err := newMessage(dnsmessage.Header{OpCode: opcodeUpdate}, dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}, updates)
+	if err != nil {
+		return err
+	}
+	return p.exchange(ctx, msg, func(resp []byte) (bool, error) {
+		var parser dnsmessage.Parser
+		h, err := parser.Start(resp)
+		if err != nil {
+			return false, err
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return false, rcodeError("update", h.RCode)
+		}
+		return true, nil
+	})
+}
+
+// GetRecord queries the server for the record the ID describes, so that
+// updating or deleting a record that is gone reports ErrNotFound instead of
+// succeeding silently.
+func (p *RFC2136) GetRecord(ctx context.Context, zoneID, id string) (Record, error) {
+	r, err := decodeRecordID(id)
+	if err != nil {
+		return Record{}, err
+	}
+	name, err := dnsmessage.NewName(fqdn(r.Name))
+	if err != nil {
+		return Record{}, err
+	}
+	qtype, err := recordType(r.Type)
+	if err != nil {
+		return Record{}, err
+	}
+	msg, err := newMessage(dnsmessage.Header{}, dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}, nil)
+	if err != nil {
+		return Record{}, err
+	}
+	var found *Record
+	err = p.exchange(ctx, msg, func(resp []byte) (bool, error) {
+		var parser dnsmessage.Parser
+		h, err := parser.Start(resp)
+		if err != nil {
+			return false, err
+		}
+		if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+			return false, rcodeError("query", h.RCode)
+		}
+		if err := parser.SkipAllQuestions(); err != nil {
+			return false, err
+		}
+		answers, err := parser.AllAnswers()
+		if err != nil {
+			return false, err
+		}
+		for _, answer := range answers {
+			if existing, ok := recordFromResource(answer); ok && existing.ID == id {
+				found = &existing
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	if found == nil {
+		return Record{}, fmt.Errorf("%w: %s %s", ErrNotFound, r.Type, r.Name)
+	}
+	return *found, nil
+}
+
+func rcodeError(op string, code dnsmessage.RCode) error {
+	switch code {
+	case dnsmessage.RCodeRefused, dnsmessage.RCode(9): // NOTAUTH
+		return fmt.Errorf("%w: %s refused by server (%s)", ErrRejected, op, rcodeName(code))
+	case dnsmessage.RCodeServerFailure:
+		return fmt.Errorf("%s failed: server failure", op)
+	}
+	return fmt.Errorf("%w: %s failed (%s)", ErrRejected, op, rcodeName(code))
+}
+
+func rcodeName(code dnsmessage.RCode) string {
+	names := map[dnsmessage.RCode]string{6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE"}
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return strings.TrimPrefix(code.String(), "RCode")
+}
+
+// --- Transport ---
+
+// exchange sends msg over TCP and passes every response message to handle
+// until it reports done.
+func (p *RFC2136) exchange(ctx context.Context, msg []byte, handle func([]byte) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var verifier *tsigVerifier
+	if p.signer != nil {
+		var mac []byte
+		msg, mac = p.signer.sign(msg)
+		verifier = p.signer.verifier(mac)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	if _, err := conn.Write(frame); err != nil {
+		return err
+	}
+
+	for {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+		if len(resp) < headerSize || resp[0] != msg[0] || resp[1] != msg[1] {
+			return errors.New("response does not match the request")
+		}
+		if verifier != nil {
+			if err := verifier.verify(resp); err != nil {
+				// A server that rejects the key answers unsigned; its RCODE
+				// explains why better than the missing signature does
+				if herr := headerError(resp); herr != nil {
+					return herr
+				}
+				return err
+			}
+		}
+		done, err := handle(resp)
+		if err != nil {
+			return err
+		}
+		if done {
+			if verifier != nil {
+				return verifier.done()
+			}
+			return nil
+		}
+	}
+}
+
+func headerError(resp []byte) error {
+	var parser dnsmessage.Parser
+	h, err := parser.Start(resp)
+	if err != nil || h.RCode == dnsmessage.RCodeSuccess {
+		return nil
+	}
+	return rcodeError("request", h.RCode)
+}
+
+// --- Message Encoding ---
+
+type rr struct {
+	record Record
+	class  dnsmessage.Class
+	ttl    uint32
+}
+
+func newMessage(h dnsmessage.Header, q dnsmessage.Question, updates []rr) ([]byte, error) {
+	var id [2]byte
+	rand.Read(id[:])
+	h.ID = binary.BigEndian.Uint16(id[:])
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), h)
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	// Updates go in the authority section ("update section" in RFC 2136)
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	for _, u := range updates {
+		if err := addResource(&b, u); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func addResource(b *dnsmessage.Builder, u rr) error {
+	r := u.record
+	name, err := dnsmessage.NewName(fqdn(r.Name))
+	if err != nil {
+		return fmt.Errorf("invalid name %q: %v", r.Name, err)
+	}
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: u.class, TTL: u.ttl}
+	target := func(s string) (dnsmessage.Name, error) {
+		n, err := dnsmessage.NewName(fqdn(s))
+		if err != nil {
+			return n, fmt.Errorf("invalid target %q: %v", s, err)
+		}
+		return n, nil
+	}
+
+	switch r.Type {
+	case "A":
+		ip := net.ParseIP(r.Content).To4()
+		if ip == nil {
+			return fmt.Errorf("invalid IPv4 address %q", r.Content)
+		}
+		var a [4]byte
+		copy(a[:], ip)
+		return b.AResource(hdr, dnsmessage.AResource{A: a})
+	case "AAAA":
+		ip := net.ParseIP(r.Content)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", r.Content)
+		}
+		var a [16]byte
+		copy(a[:], ip)
+		return b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: a})
+	case "CNAME":
+		n, err := target(r.Content)
+		if err != nil {
+			return err
+		}
+		return b.CNAMEResource(hdr, dnsmessage.CNAMEResource{CNAME: n})
+	case "NS":
+		n, err := target(r.Content)
+		if err != nil {
+			return err
+		}
+		return b.NSResource(hdr, dnsmessage.NSResource{NS: n})
+	case "MX":
+		n, err := target(r.Content)
+		if err != nil {
+			return err
+		}
+		var pref uint16
+		if r.Priority != nil {
+			pref = *r.Priority
+		}
+		return b.MXResource(hdr, dnsmessage.MXResource{Pref: pref, MX: n})
+	case "TXT":
+		return b.TXTResource(hdr, dnsmessage.TXTResource{TXT: txtChunks(r.Content)})
+	case "SRV":
+		n, err := target(dataString(r.Data, "target"))
+		if err != nil {
+			return err
+		}
+		return b.SRVResource(hdr, dnsmessage.SRVResource{
+			Priority: dataUint16(r.Data, "priority"), Weight: dataUint16(r.Data, "weight"),
+			Port: dataUint16(r.Data, "port"), Target: n,
+		})
+	case "CAA":
+		tag, value := dataString(r.Data, "tag"), dataString(r.Data, "value")
+		data := append([]byte{byte(dataUint16(r.Data, "flags")), byte(len(tag))}, tag...)
+		data = append(data, value...)
+		hdr.Type = typeCAA
+		return b.UnknownResource(hdr, dnsmessage.UnknownResource{Type: typeCAA, Data: data})
+	}
+	return fmt.Errorf("%w: record type %s", ErrUnsupported, r.Type)
+}
+
+func recordType(t string) (dnsmessage.Type, error) {
+	types := map[string]dnsmessage.Type{
+		"A": dnsmessage.TypeA, "AAAA": dnsmessage.TypeAAAA, "CNAME": dnsmessage.TypeCNAME, "NS": dnsmessage.TypeNS,
+		"MX": dnsmessage.TypeMX, "TXT": dnsmessage.TypeTXT, "SRV": dnsmessage.TypeSRV, "CAA": typeCAA,
+	}
+	if v, ok := types[t]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("%w: record type %s", ErrUnsupported, t)
+}
+
+// recordFromResource converts a record read from the server.
+func recordFromResource(res dnsmessage.Resource) (Record, bool) {
+	r := Record{Name: trimDot(res.Header.Name.String()), TTL: int(res.Header.TTL)}
+	switch body := res.Body.(type) {
+	case *dnsmessage.AResource:
+		r.Type, r.Content = "A", net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		r.Type, r.Content = "AAAA", net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		r.Type, r.Content = "CNAME", trimDot(body.CNAME.String())
+	case *dnsmessage.NSResource:
+		r.Type, r.Content = "NS", trimDot(body.NS.String())
+	case *dnsmessage.MXResource:
+		pref := body.Pref
+		r.Type, r.Content, r.Priority = "MX", trimDot(body.MX.String()), &pref
+	case *dnsmessage.TXTResource:
+		r.Type, r.Content = "TXT", strings.Join(body.TXT, "")
+	case *dnsmessage.SRVResource:
+		r.Type = "SRV"
+		r.Data = map[string]interface{}{"priority": body.Priority, "weight": body.Weight, "port": body.Port, "target": trimDot(body.Target.String())}
+	case *dnsmessage.UnknownResource:
+		if body.Type != typeCAA || len(body.Data) < 2 || len(body.Data) < 2+int(body.Data[1]) {
+			return r, false
+		}
+		tagEnd := 2 + int(body.Data[1])
+		r.Type = "CAA"
+		r.Data = map[string]interface{}{"flags": body.Data[0], "tag": string(body.Data[2:tagEnd]), "value": string(body.Data[tagEnd:])}
+	default:
+		return r, false
+	}
+	r.ID = recordID(r)
+	return r, true
+}
+
+// --- Record IDs ---
+
+// recordIdentity is what an ID encodes: enough to rebuild the record data.
+type recordIdentity struct {
+	Type     string                 `json:"t"`
+	Name     string                 `json:"n"`
+	Content  string                 `json:"c,omitempty"`
+	Priority *uint16                `json:"p,omitempty"`
+	Data     map[string]interface{} `json:"d,omitempty"`
+}
+
+func recordID(r Record) string {
+	id := recordIdentity{Type: r.Type, Name: strings.ToLower(trimDot(r.Name)), Content: r.Content, Priority: r.Priority}
+	switch r.Type {
+	case "A", "AAAA":
+		if ip := net.ParseIP(r.Content); ip != nil {
+			id.Content = ip.String()
+		}
+	case "CNAME", "NS", "MX":
+		id.Content = strings.ToLower(trimDot(r.Content))
+	case "SRV":
+		id.Content = ""
+		id.Data = map[string]interface{}{
+			"priority": dataUint16(r.Data, "priority"), "weight": dataUint16(r.Data, "weight"),
+			"port": dataUint16(r.Data, "port"), "target": strings.ToLower(trimDot(dataString(r.Data, "target"))),
+		}
+	case "CAA":
+		id.Content = ""
+		id.Data = map[string]interface{}{"flags": dataUint16(r.Data, "flags"), "tag": dataString(r.Data, "tag"), "value": dataString(r.Data, "value")}
+	}
+	data, _ := json.Marshal(id)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeRecordID(id string) (Record, error) {
+	data, err := base64.RawURLEncoding.DecodeString(id)
+	var ident recordIdentity
+	if err == nil {
+		err = json.Unmarshal(data, &ident)
+	}
+	if err != nil || ident.Type == "" || ident.Name == "" {
+		return Record{}, fmt.Errorf("%w: invalid record id", ErrNotFound)
+	}
+	return Record{Type: ident.Type, Name: ident.Name, Content: ident.Content, Priority: ident.Priority, Data: ident.Data}, nil
+}
+
+// --- Helpers ---
+
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+func trimDot(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
+
+func txtChunks(s string) []string {
+	chunks := []string{}
+	for len(s) > maxTXTChunk {
+		chunks = append(chunks, s[:maxTXTChunk])
+		s = s[maxTXTChunk:]
+	}
+	return append(chunks, s)
+}
+
+func dataString(data map[string]interface{}, key string) string {
+	if v, ok := data[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// dataUint16 reads a number that may have been decoded from JSON (float64)
+// or set directly.
+func dataUint16(data map[string]interface{}, key string) uint16 {
+	switch v := data[key].(type) {
+	case float64:
+		return uint16(v)
+	case int:
+		return uint16(v)
+	case uint8:
+		return uint16(v)
+	case uint16:
+		return v
+	case uint64:
+		return uint16(v)
+	case string:
+		n, _ := strconv.ParseUint(v, 10, 16)
+		return uint16(n)
+	}
+	return 0
+}
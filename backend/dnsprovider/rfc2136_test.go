@@ -0,0 +1,283 @@
+package dnsprovider
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNSServer is an authoritative server for a set of zones that answers
+// queries, zone transfers and unsigned updates over TCP. Like BIND it
+// rejects updates for names outside the zone with NOTZONE.
+type fakeDNSServer struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	zones   []string
+	records []dnsmessage.Resource
+}
+
+func newFakeDNSServer(t *testing.T, zones ...string) *fakeDNSServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNSServer{ln: ln, zones: zones}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeDNSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		resp := s.handle(req)
+		frame := make([]byte, 2+len(resp))
+		binary.BigEndian.PutUint16(frame, uint16(len(resp)))
+		copy(frame[2:], resp)
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeDNSServer) handle(req []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(req); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	q := msg.Questions[0]
+	resp := dnsmessage.Message{Header: dnsmessage.Header{ID: msg.ID, Response: true, OpCode: msg.OpCode, Authoritative: true}, Questions: msg.Questions}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case msg.OpCode == opcodeUpdate:
+		resp.RCode = s.update(q.Name, msg.Authorities)
+	case q.Type == dnsmessage.TypeAXFR:
+		zone := trimDot(q.Name.String())
+		soa := dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body:   &dnsmessage.SOAResource{NS: mustName("ns1." + zone), MBox: mustName("hostmaster." + zone), Serial: 1, MinTTL: 300},
+		}
+		resp.Answers = append(resp.Answers, soa)
+		for _, r := range s.records {
+			if s.zoneFor(trimDot(r.Header.Name.String())) == zone {
+				resp.Answers = append(resp.Answers, r)
+			}
+		}
+		resp.Answers = append(resp.Answers, soa)
+	default:
+		for _, r := range s.records {
+			if strings.EqualFold(r.Header.Name.String(), q.Name.String()) && r.Header.Type == q.Type {
+				resp.Answers = append(resp.Answers, r)
+			}
+		}
+	}
+	out, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (s *fakeDNSServer) update(zoneName dnsmessage.Name, updates []dnsmessage.Resource) dnsmessage.RCode {
+	zone := trimDot(zoneName.String())
+	if s.zoneFor(zone) != zone {
+		return dnsmessage.RCode(9) // NOTAUTH
+	}
+	for _, u := range updates {
+		if !inZone(trimDot(u.Header.Name.String()), zone) {
+			return dnsmessage.RCode(10) // NOTZONE
+		}
+	}
+	for _, u := range updates {
+		r, ok := recordFromResource(u)
+		if !ok {
+			return dnsmessage.RCodeFormatError
+		}
+		if u.Header.Class == classNONE {
+			kept := s.records[:0]
+			for _, existing := range s.records {
+				if e, _ := recordFromResource(existing); e.ID != r.ID {
+					kept = append(kept, existing)
+				}
+			}
+			s.records = kept
+			continue
+		}
+		s.records = append(s.records, u)
+	}
+	return dnsmessage.RCodeSuccess
+}
+
+// zoneFor returns the most specific zone name falls under.
+func (s *fakeDNSServer) zoneFor(name string) string {
+	best := ""
+	for _, z := range s.zones {
+		if inZone(name, z) && len(z) > len(best) {
+			best = z
+		}
+	}
+	return best
+}
+
+func inZone(name, zone string) bool {
+	name = strings.ToLower(name)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func mustName(s string) dnsmessage.Name {
+	return dnsmessage.MustNewName(fqdn(s))
+}
+
+func TestRFC2136RelativeNames(t *testing.T) {
+	srv := newFakeDNSServer(t, "example.com", "sub.example.com", "example.org")
+	p, err := NewRFC2136(RFC2136Config{Server: srv.ln.Addr().String(), Zones: srv.zones})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	apex, err := p.CreateRecord(ctx, "example.com", Record{Type: "A", Name: "@", Content: "192.0.2.1", TTL: 300})
+	if err != nil {
+		t.Fatalf("create @: %v", err)
+	}
+	if apex.Name != "example.com" {
+		t.Errorf("@ created as %q, want example.com", apex.Name)
+	}
+	www, err := p.CreateRecord(ctx, "example.com", Record{Type: "A", Name: "www", Content: "192.0.2.2", TTL: 300})
+	if err != nil {
+		t.Fatalf("create www: %v", err)
+	}
+	if www.Name != "www.example.com" {
+		t.Errorf("www created as %q, want www.example.com", www.Name)
+	}
+	if _, err := p.CreateRecord(ctx, "example.org", Record{Type: "TXT", Name: "www.example.org", Content: "hello"}); err != nil {
+		t.Fatalf("create fully qualified name: %v", err)
+	}
+	if _, err := p.CreateRecord(ctx, "sub.example.com", Record{Type: "A", Name: "www", Content: "192.0.2.3"}); err != nil {
+		t.Fatalf("create in nested zone: %v", err)
+	}
+
+	updated, err := p.UpdateRecord(ctx, "example.com", www.ID, Record{Type: "A", Name: "www", Content: "192.0.2.20", TTL: 600})
+	if err != nil {
+		t.Fatalf("update www: %v", err)
+	}
+	if updated.Name != "www.example.com" || updated.Content != "192.0.2.20" {
+		t.Errorf("updated record = %+v", updated)
+	}
+
+	for _, tt := range []struct {
+		zone, name string
+		want       []string
+	}{
+		{"example.com", "www", []string{"192.0.2.20"}},
+		{"example.com", "@", []string{"192.0.2.1"}},
+		{"example.com", "WWW.example.com.", []string{"192.0.2.20"}},
+		{"sub.example.com", "www", []string{"192.0.2.3"}},
+		{"example.com", "", []string{"192.0.2.1", "192.0.2.20"}},
+	} {
+		records, err := p.ListRecords(ctx, tt.zone, Filter{Type: "A", Name: tt.name})
+		if err != nil {
+			t.Fatalf("list %s in %s: %v", tt.name, tt.zone, err)
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.Content)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("list %q in %s = %v, want %v", tt.name, tt.zone, got, tt.want)
+		}
+	}
+
+	if err := p.DeleteRecord(ctx, "example.com", updated.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := p.GetRecord(ctx, "example.com", updated.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecord after delete: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRFC2136ResolveZone(t *testing.T) {
+	several, err := NewRFC2136(RFC2136Config{Server: "127.0.0.1", Zones: []string{"example.com", "Sub.Example.com.", "example.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := NewRFC2136(RFC2136Config{Server: "127.0.0.1", Zones: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p          *RFC2136
+		zone, name string
+		want       string
+		wantErr    bool
+	}{
+		{p: several, zone: "example.org", name: "www", want: "example.org"},
+		{p: several, zone: "SUB.example.com.", want: "sub.example.com"},
+		{p: several, name: "www.sub.example.com", want: "sub.example.com"},
+		{p: several, name: "www.example.com.", want: "example.com"},
+		{p: several, name: "www", wantErr: true},
+		{p: several, name: "@", wantErr: true},
+		{p: several, name: "www.example.net.", wantErr: true},
+		{p: several, zone: "example.net", wantErr: true},
+		{p: single, name: "www", want: "example.com"},
+		{p: single, name: "@", want: "example.com"},
+		{p: single, want: "example.com"},
+	}
+	for _, tt := range tests {
+		got, err := tt.p.ResolveZone(context.Background(), tt.zone, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ResolveZone(%q, %q) = %q, want an error", tt.zone, tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveZone(%q, %q) = %q, %v, want %q", tt.zone, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	tests := []struct{ name, zone, want string }{
+		{"@", "example.com", "example.com"},
+		{"", "example.com.", "example.com"},
+		{"www", "example.com", "www.example.com"},
+		{"www.example.com", "example.com", "www.example.com"},
+		{"WWW.Example.COM", "example.com", "WWW.Example.COM"},
+		{"www.example.com.", "example.com", "www.example.com"},
+		{"mail.other.org", "example.com", "mail.other.org.example.com"},
+		{"notexample.com", "example.com", "notexample.com.example.com"},
+	}
+	for _, tt := range tests {
+		if got := Qualify(tt.name, tt.zone); got != tt.want {
+			t.Errorf("Qualify(%q, %q) = %q, want %q", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// --- TSIG (RFC 8945) ---
+//
+// Messages are signed by appending a TSIG record whose MAC covers the message
+// and the TSIG variables. Responses are verified the same way, chaining the
+// request MAC (and, for zone transfers, the previous MAC) into the digest.
+
+const (
+	typeTSIG   = 250
+	classANY   = 255
+	tsigFudge  = 300
+	headerSize = 12
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1.":   sha1.New,
+	"hmac-sha256.": sha256.New,
+	"hmac-sha384.": sha512.New384,
+	"hmac-sha512.": sha512.New,
+}
+
+var errTSIGMissing = errors.New("response is not signed")
+
+// TSIGKey is a shared secret as configured in named.conf.
+type TSIGKey struct {
+	Name      string // e.g. "ddns-key."
+	Algorithm string // hmac-sha256 (default), hmac-sha1, hmac-sha384, hmac-sha512
+	Secret    string // Base64
+}
+
+type tsigSigner struct {
+	name      string
+	algorithm string
+	newHash   func() hash.Hash
+	secret    []byte
+	now       func() time.Time
+}
+
+func newTSIGSigner(key TSIGKey) (*tsigSigner, error) {
+	alg := strings.ToLower(key.Algorithm)
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+	alg = strings.TrimSuffix(alg, ".") + "."
+	newHash, ok := tsigAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", key.Algorithm)
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret: %v", err)
+	}
+	name := strings.ToLower(strings.TrimSuffix(key.Name, ".") + ".")
+	return &tsigSigner{name: name, algorithm: alg, newHash: newHash, secret: secret, now: time.Now}, nil
+}
+
+// nameWire encodes a domain name without compression, lower-cased as the
+// canonical form requires.
+func nameWire(name string) []byte {
+	var b bytes.Buffer
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		b.WriteByte(byte(len(label)))
+		b.WriteString(label)
+	}
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+func put48(b *bytes.Buffer, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b.Write(buf[2:])
+}
+
+func put16(b *bytes.Buffer, v uint16) {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	b.Write(buf[:])
+}
+
+// timers are the Time Signed and Fudge fields.
+func timers(signed uint64) []byte {
+	var b bytes.Buffer
+	put48(&b, signed)
+	put16(&b, tsigFudge)
+	return b.Bytes()
+}
+
+// variables are the TSIG fields covered by the MAC of a single message.
+func (s *tsigSigner) variables(signed uint64, tsigErr uint16, other []byte) []byte {
+	var b bytes.Buffer
+	b.Write(nameWire(s.name))
+	put16(&b, classANY)
+	b.Write([]byte{0, 0, 0, 0}) // TTL
+	b.Write(nameWire(s.algorithm))
+	b.Write(timers(signed))
+	put16(&b, tsigErr)
+	put16(&b, uint16(len(other)))
+	b.Write(other)
+	return b.Bytes()
+}
+
+func (s *tsigSigner) mac(parts ...[]byte) []byte {
+	h := hmac.New(s.newHash, s.secret)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// sign appends a TSIG record to msg and returns the signed message and its
+// MAC, which the response is verified against.
+func (s *tsigSigner) sign(msg []byte) ([]byte, []byte) {
+	signed := uint64(s.now().Unix())
+	mac := s.mac(msg, s.variables(signed, 0, nil))
+
+	var rdata bytes.Buffer
+	rdata.Write(nameWire(s.algorithm))
+	rdata.Write(timers(signed))
+	put16(&rdata, uint16(len(mac)))
+	rdata.Write(mac)
+	rdata.Write(msg[0:2]) // Original ID
+	put16(&rdata, 0)      // Error
+	put16(&rdata, 0)      // Other Len
+
+	var out bytes.Buffer
+	out.Write(msg)
+	out.Write(nameWire(s.name))
+	put16(&out, typeTSIG)
+	put16(&out, classANY)
+	out.Write([]byte{0, 0, 0, 0})
+	put16(&out, uint16(rdata.Len()))
+	out.Write(rdata.Bytes())
+
+	signedMsg := out.Bytes()
+	arcount := binary.BigEndian.Uint16(signedMsg[10:12])
+	binary.BigEndian.PutUint16(signedMsg[10:12], arcount+1)
+	return signedMsg, mac
+}
+
+type tsigRecord struct {
+	offset  int // Where the TSIG record starts in the message
+	signed  uint64
+	mac     []byte
+	origID  []byte
+	errCode uint16
+	other   []byte
+}
+
+// findTSIG locates the TSIG record, which is always the last record and is
+// never compressed. The key name is matched as configured (lower case).
+func (s *tsigSigner) findTSIG(msg []byte) (*tsigRecord, error) {
+	if len(msg) < headerSize || binary.BigEndian.Uint16(msg[10:12]) == 0 {
+		return nil, errTSIGMissing
+	}
+	marker := append(nameWire(s.name), 0, typeTSIG, 0, classANY)
+	offset := bytes.LastIndex(msg, marker)
+	if offset < headerSize {
+		return nil, errTSIGMissing
+	}
+	rd := msg[offset+len(marker)+4:] // Skip TTL
+	if len(rd) < 2 {
+		return nil, errors.New("truncated TSIG record")
+	}
+	rdlen := int(binary.BigEndian.Uint16(rd[:2]))
+	rd = rd[2:]
+	if len(rd) != rdlen {
+		return nil, errors.New("TSIG record is not the last record")
+	}
+	alg := nameWire(s.algorithm)
+	if len(rd) < len(alg)+10 || !bytes.EqualFold(rd[:len(alg)], alg) {
+		return nil, errors.New("TSIG algorithm mismatch")
+	}
+	rd = rd[len(alg):]
+	rec := &tsigRecord{offset: offset}
+	rec.signed = uint64(rd[0])<<40 | uint64(rd[1])<<32 | uint64(binary.BigEndian.Uint32(rd[2:6]))
+	macLen := int(binary.BigEndian.Uint16(rd[8:10]))
+	rd = rd[10:]
+	if len(rd) < macLen+6 {
+		return nil, errors.New("truncated TSIG record")
+	}
+	rec.mac, rd = rd[:macLen], rd[macLen:]
+	rec.origID = rd[0:2]
+	rec.errCode = binary.BigEndian.Uint16(rd[2:4])
+	otherLen := int(binary.BigEndian.Uint16(rd[4:6]))
+	if len(rd) < 6+otherLen {
+		return nil, errors.New("truncated TSIG record")
+	}
+	rec.other = rd[6 : 6+otherLen]
+	return rec, nil
+}
+
+// stripTSIG returns msg without its TSIG record, with ARCOUNT decremented
+// and the original ID restored.
+func stripTSIG(msg []byte, rec *tsigRecord) []byte {
+	out := append([]byte(nil), msg[:rec.offset]...)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])-1)
+	copy(out[0:2], rec.origID)
+	return out
+}
+
+// tsigVerifier checks a response, or the stream of messages of a zone
+// transfer, against the request MAC.
+type tsigVerifier struct {
+	s        *tsigSigner
+	prevMAC  []byte
+	unsigned [][]byte
+	first    bool
+}
+
+func (s *tsigSigner) verifier(requestMAC []byte) *tsigVerifier {
+	return &tsigVerifier{s: s, prevMAC: requestMAC, first: true}
+}
+
+// verify checks one message. Later messages of a transfer may be unsigned
+// (up to 99 in a row); they are covered by the next signed one.
+func (v *tsigVerifier) verify(msg []byte) error {
+	rec, err := v.s.findTSIG(msg)
+	if err == errTSIGMissing && !v.first && len(v.unsigned) < 99 {
+		v.unsigned = append(v.unsigned, msg)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rec.errCode != 0 {
+		return fmt.Errorf("server rejected TSIG: %s", tsigErrorName(rec.errCode))
+	}
+
+	var prev bytes.Buffer
+	put16(&prev, uint16(len(v.prevMAC)))
+	prev.Write(v.prevMAC)
+	parts := [][]byte{prev.Bytes()}
+	parts = append(parts, v.unsigned...)
+	parts = append(parts, stripTSIG(msg, rec))
+	if v.first {
+		parts = append(parts, v.s.variables(rec.signed, rec.errCode, rec.other))
+	} else {
+		parts = append(parts, timers(rec.signed))
+	}
+	if !hmac.Equal(v.s.mac(parts...), rec.mac) {
+		return errors.New("TSIG signature mismatch")
+	}
+	now := uint64(v.s.now().Unix())
+	if now+tsigFudge < rec.signed || rec.signed+tsigFudge < now {
+		return errors.New("TSIG time outside the allowed window")
+	}
+	v.prevMAC, v.unsigned, v.first = rec.mac, nil, false
+	return nil
+}
+
+func tsigErrorName(code uint16) string {
+	switch code {
+	case 16:
+		return "BADSIG"
+	case 17:
+		return "BADKEY"
+	case 18:
+		return "BADTIME"
+	case 22:
+		return "BADTRUNC"
+	}
+	return fmt.Sprintf("error %d", code)
+}
+
+// done reports an error if the transfer ended with unsigned messages.
+func (v *tsigVerifier) done() error {
+	if len(v.unsigned) > 0 {
+		return errors.New("last message of the transfer is not signed")
+	}
+	return nil
+}
@@ -2,20 +2,23 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"text/template"
+	"syscall"
 	"time"
 
 	"system-manager/database"
+	"system-manager/dnsprovider"
 
 	"github.com/creack/pty"
 	"github.com/gin-gonic/gin"
@@ -34,9 +37,12 @@ var jwtSecret = []byte("SUPER_SECRET_SYSTEM_KEY_CHANGE_THIS") // In production,
 
 var (
 	CloudflareAPIToken string
-	CloudflareZoneID   string
+	CloudflareZoneID   string // Default zone when a request names none and no zone matches
 )
 
+// Persistent panel data (nginx history, ...). Override with SYSTEM_MANAGER_STATE_DIR.
+var stateDir = "/var/lib/system-manager"
+
 // --- Structs ---
 
 type LoginRequest struct {
@@ -71,15 +77,23 @@ type DiskInfo struct {
 }
 
 type NetworkInfo struct {
-	Interfaces []InterfaceInfo `json:"interfaces"`
-	Stats      NetStats        `json:"stats"`
-	PublicIP   string          `json:"public_ip"`
+	Interfaces        []InterfaceInfo `json:"interfaces"`
+	Stats             NetStats        `json:"stats"`
+	PublicIP          string          `json:"public_ip"`
+	PublicIPv6        string          `json:"public_ipv6"`
+	PublicIPUpdatedAt time.Time       `json:"public_ip_updated_at"`
 }
 
 type InterfaceInfo struct {
-	Name string   `json:"name"`
-	IPv4 []string `json:"ipv4"`
-	IPv6 []string `json:"ipv6"`
+	Name      string             `json:"name"`
+	IPv4      []string           `json:"ipv4"`
+	IPv6      []string           `json:"ipv6"`
+	MTU       int                `json:"mtu"`
+	MAC       string             `json:"mac"`
+	Flags     []string           `json:"flags"`
+	LinkState string             `json:"link_state"`
+	Counters  *InterfaceCounters `json:"counters,omitempty"`
+	Rates     *InterfaceRates    `json:"rates,omitempty"` // Only when a sampling window is requested
 }
 
 type NetStats struct {
@@ -97,6 +111,8 @@ type ProcessInfo struct {
 type NginxFile struct {
 	Name    string `json:"name"`
 	Content string `json:"content,omitempty"`
+	Message string `json:"message,omitempty"` // Revision message stored in the history
+	ETag    string `json:"etag,omitempty"`    // Hash of the content the edit is based on (or If-Match header)
 }
 
 type FirewallRule struct {
@@ -108,18 +124,31 @@ type FirewallRule struct {
 }
 
 type CreateSiteRequest struct {
-	Domain string `json:"domain"`
-	Type   string `json:"type"`   // "proxy" or "static"
-	Target string `json:"target"` // port or path
-	SSL    bool   `json:"ssl"`
+	Domain string          `json:"domain"`
+	Type   string          `json:"type"`             // Registered site template, see GET /nginx/templates
+	Target string          `json:"target"`           // Legacy: port (proxy) or path (static, php, spa)
+	Params json.RawMessage `json:"params,omitempty"` // Template specific parameters
+	SSL    bool            `json:"ssl"`
+	// SSLMethod selects how the certificate is obtained: "certbot" (HTTP-01,
+	// default) or "dns" (built-in ACME client with Cloudflare DNS-01).
+	SSLMethod string `json:"ssl_method,omitempty"`
+
+	Hardening *SiteHardening `json:"hardening,omitempty"`
+	// DNS points the domain at this server (Cloudflare A/AAAA records) and
+	// waits for it to propagate before the certificate is requested.
+	DNS *SiteDNSOptions `json:"dns,omitempty"`
 }
 
 type CloudflareRecordRequest struct {
-	Type    string `json:"type"`
-	Name    string `json:"name"`
-	Content string `json:"content"`
-	Proxied bool   `json:"proxied"`
-	TTL     int    `json:"ttl"` // Optional, default 1 (auto)
+	Type     string          `json:"type"`
+	Name     string          `json:"name"`
+	Content  string          `json:"content"`
+	Proxied  bool            `json:"proxied"`
+	TTL      int             `json:"ttl"`                // Optional, default 1 (auto)
+	Priority *uint16         `json:"priority,omitempty"` // MX
+	Data     json.RawMessage `json:"data,omitempty"`     // SRV and CAA fields, see dns_records.go
+	Comment  string          `json:"comment,omitempty"`
+	Tags     []string        `json:"tags,omitempty"`
 }
 
 // --- Middleware ---
@@ -149,25 +178,58 @@ func authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if username, ok := claims["username"].(string); ok {
+				c.Set("username", username)
+			}
+		}
+
 		c.Next()
 	}
 }
 
+// requestUser returns the authenticated username, or "anonymous" while the
+// token bypass is active.
+func requestUser(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return username
+	}
+	return "anonymous"
+}
+
 // --- Main ---
 
 func main() {
 	// Direct assignment to ensure no env var issues
 	CloudflareAPIToken = "sua key aqui"
 	CloudflareZoneID = "sua zona aqui"
+	cfClient = newCloudflareClient(CloudflareAPIToken)
+	configureDNSProviders()
+
+	if dir := os.Getenv("SYSTEM_MANAGER_STATE_DIR"); dir != "" {
+		stateDir = dir
+	}
 
 	fmt.Println("System Manager Starting...")
-	fmt.Printf("Configured Cloudflare Zone: %s\n", CloudflareZoneID)
+	fmt.Printf("Configured Cloudflare Zone: %s (default)\n", CloudflareZoneID)
 	if len(CloudflareAPIToken) > 10 {
 		fmt.Printf("Configured Cloudflare Token: %s...%s\n", CloudflareAPIToken[:4], CloudflareAPIToken[len(CloudflareAPIToken)-4:])
 	} else {
 		fmt.Println("Configured Cloudflare Token: (Invalid/Empty)")
 	}
 
+	ipResolvers, ipInterval, ipTimeout := publicIPConfigFromEnv()
+	svc, err := newPublicIPService(ipResolvers, ipInterval, ipTimeout)
+	if err != nil {
+		fmt.Printf("Public IP: %v, falling back to defaults\n", err)
+		svc, _ = newPublicIPService(strings.Split(defaultPublicIPResolvers, ","), ipInterval, ipTimeout)
+	}
+	publicIP = svc
+	publicIP.Start()
+	startCertificateMonitor()
+	startACMERenewer()
+	startDDNS()
+
 	r := gin.Default()
 
 	// CORS
@@ -195,18 +257,85 @@ func main() {
 	{
 		protected.GET("/system", getSystemInfo)
 		protected.GET("/network", getNetworkInfo)
+		protected.GET("/network/connections", getConnections)
+		protected.GET("/network/public-ip", getPublicIP)
+		protected.POST("/network/public-ip/refresh", refreshPublicIP)
 		protected.GET("/processes", getProcesses)
 		protected.POST("/processes/kill", killProcess)
+		protected.POST("/processes/renice", reniceProcess)
+		protected.POST("/processes/affinity", setProcessAffinity)
+		protected.GET("/processes/:pid", getProcessDetail)
 		protected.GET("/nginx/files", listNginxFiles)
 		protected.GET("/nginx/file", getNginxFile)
 		protected.POST("/nginx/file", saveNginxFile)
 		protected.POST("/nginx/create-site", createSite)
+		protected.GET("/nginx/jobs", listProvisionJobs)
+		protected.GET("/nginx/jobs/:id", getProvisionJobHandler)
+		protected.GET("/nginx/jobs/:id/events", provisionJobEvents)
+		protected.GET("/nginx/templates", listSiteTemplates)
+		protected.GET("/nginx/status", getNginxStatus)
+		protected.POST("/nginx/test", testNginxConfig)
+		protected.POST("/nginx/reload", controlNginx("reload"))
+		protected.POST("/nginx/restart", controlNginx("restart"))
+		protected.GET("/nginx/site/:name/hardening", getSiteHardening)
+		protected.PUT("/nginx/site/:name/hardening", updateSiteHardening)
+		protected.GET("/nginx/site/:name/htpasswd", listHtpasswdUsers)
+		protected.PUT("/nginx/site/:name/htpasswd", setHtpasswdUser)
+		protected.DELETE("/nginx/site/:name/htpasswd/:user", deleteHtpasswdUser)
+		protected.GET("/nginx/site/:name/logs", getSiteLogs)
+		protected.GET("/nginx/site/:name/logs/entries", getSiteLogEntries)
+		protected.GET("/nginx/site/:name/logs/tail", tailSiteLog)
+		protected.GET("/nginx/site/:name/logs/stats", getSiteLogStats)
+		protected.GET("/nginx/sites", getNginxSites)
+		protected.GET("/nginx/sites/state", getNginxSiteStates)
+		protected.POST("/nginx/site/enable", toggleNginxSite(true))
+		protected.POST("/nginx/site/disable", toggleNginxSite(false))
+		protected.DELETE("/nginx/site/:name", deleteNginxSiteHandler)
+		protected.GET("/nginx/revisions", listNginxRevisions)
+		protected.GET("/nginx/revision", getNginxRevision)
+		protected.GET("/nginx/revisions/diff", diffNginxRevisions)
+		protected.POST("/nginx/revisions/restore", restoreNginxRevision)
+
+		// Certificates
+		protected.GET("/certificates", getCertificates)
+		protected.GET("/certificates/warnings", getCertificateWarnings)
+		protected.GET("/certificates/inspect", inspectCertificate)
+		protected.POST("/certificates/renew", renewCertificate)
+		protected.POST("/certificates/revoke", revokeCertificate)
+		protected.GET("/acme/certificates", listACMECertificatesHandler)
+		protected.POST("/acme/certificates", issueACMECertificateHandler)
+		protected.POST("/acme/certificates/:name/renew", renewACMECertificateHandler)
+		protected.POST("/acme/certificates/:name/install", installACMECertificateHandler)
+		protected.DELETE("/acme/certificates/:name", deleteACMECertificateHandler)
 		
-		// Cloudflare
-		protected.POST("/cloudflare/add-record", addDNSRecord)
-		protected.GET("/cloudflare/records", listDNSRecords)
-		protected.PUT("/cloudflare/record/:id", updateDNSRecord)
-		protected.DELETE("/cloudflare/record/:id", deleteDNSRecord)
+		// Cloudflare (DNS endpoints take ?zone=<id or name>)
+		protected.GET("/cloudflare/zones", listCloudflareZonesHandler)
+		cf := protected.Group("/cloudflare", useDNSProvider("cloudflare"))
+		cf.POST("/add-record", addDNSRecord)
+		cf.GET("/records", listDNSRecords)
+		cf.PUT("/record/:id", updateDNSRecord)
+		cf.DELETE("/record/:id", deleteDNSRecord)
+		protected.GET("/cloudflare/export", exportDNSZone)
+		protected.POST("/cloudflare/import", importDNSZone)
+
+		// Dynamic DNS
+		protected.GET("/ddns", getDDNSHandler)
+		protected.PUT("/ddns", updateDDNSHandler)
+		protected.POST("/ddns/sync", syncDDNSHandler)
+		protected.GET("/ddns/history", getDDNSHistoryHandler)
+
+		// DNS records through any configured provider (?provider=cloudflare|rfc2136)
+		protected.GET("/dns/providers", listDNSProvidersHandler)
+		protected.GET("/dns/zones", listDNSZonesHandler)
+		protected.GET("/dns/records", listDNSRecords)
+		protected.POST("/dns/records", addDNSRecord)
+		protected.PUT("/dns/records/:id", updateDNSRecord)
+		protected.DELETE("/dns/records/:id", deleteDNSRecord)
+		protected.GET("/dns/lookup", dnsLookupHandler)
+		protected.GET("/dns/propagation", dnsPropagationHandler)
+		protected.GET("/dns/history", listDNSHistory)
+		protected.GET("/dns/history/:change", getDNSChange)
+		protected.POST("/dns/history/:change/undo", undoDNSChange)
 
 		// Firewall
 		protected.GET("/firewall", getFirewallStatus)
@@ -575,35 +704,42 @@ func getSystemInfo(c *gin.Context) {
 }
 
 func getNetworkInfo(c *gin.Context) {
-	ifaces, _ := net.Interfaces()
-	var interfaces []InterfaceInfo
-	for _, i := range ifaces {
-		var ipv4s, ipv6s []string
-		for _, addr := range i.Addrs {
-			ip := addr.Addr
-			if strings.Contains(ip, ":") {
-				ipv6s = append(ipv6s, ip)
-			} else {
-				ipv4s = append(ipv4s, ip)
-			}
+	filter := interfaceFilter{
+		ExcludeLoopback: c.Query("exclude_loopback") == "true",
+		ExcludeVirtual:  c.Query("exclude_virtual") == "true",
+	}
+	var window time.Duration
+	if w := c.Query("window"); w != "" {
+		secs, err := strconv.ParseFloat(w, 64)
+		if err != nil || secs < 0 || time.Duration(secs*float64(time.Second)) > maxSampleWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("window must be between 0 and %.0f seconds", maxSampleWindow.Seconds())})
+			return
 		}
-		interfaces = append(interfaces, InterfaceInfo{Name: i.Name, IPv4: ipv4s, IPv6: ipv6s})
+		window = time.Duration(secs * float64(time.Second))
 	}
-	io, _ := net.IOCounters(false)
+
+	interfaces := collectInterfaces(filter, window)
 	stats := NetStats{}
-	if len(io) > 0 {
-		stats.BytesSent = io[0].BytesSent
-		stats.BytesRecv = io[0].BytesRecv
+	for _, i := range interfaces {
+		if i.Counters != nil {
+			stats.BytesSent += i.Counters.BytesSent
+			stats.BytesRecv += i.Counters.BytesRecv
+		}
 	}
-	publicIP := "Unknown" 
-	resp, err := http.Get("https://api.ipify.org")
-	if err == nil {
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		publicIP = string(body)
+	// Served from the background refresher, never fetched inline
+	ipState := publicIP.State()
+	publicIPv4 := ipState.IPv4
+	if publicIPv4 == "" {
+		publicIPv4 = "Unknown"
 	}
 
-	c.JSON(http.StatusOK, NetworkInfo{Interfaces: interfaces, Stats: stats, PublicIP: publicIP})
+	c.JSON(http.StatusOK, NetworkInfo{
+		Interfaces:        interfaces,
+		Stats:             stats,
+		PublicIP:          publicIPv4,
+		PublicIPv6:        ipState.IPv6,
+		PublicIPUpdatedAt: ipState.UpdatedAt,
+	})
 }
 
 func getProcesses(c *gin.Context) {
@@ -631,24 +767,62 @@ func getProcesses(c *gin.Context) {
 }
 
 func killProcess(c *gin.Context) {
-	var req struct {
-		PID int32 `json:"pid"`
-	}
+	var req KillProcessRequest
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
 		return
 	}
+
+	// Graceful mode: TERM (or INT) first, KILL after the grace period
+	if req.GraceSeconds > 0 && req.Signal == "" {
+		req.Signal = "TERM"
+	}
+	if req.GraceSeconds < 0 || req.GraceSeconds > maxGraceSeconds {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("grace_seconds must be between 0 and %d", maxGraceSeconds)})
+		return
+	}
+	sig, sigName, err := parseSignal(req.Signal)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Escalating after HUP, STOP or a user signal would kill a process that
+	// was only asked to reload or pause
+	if req.GraceSeconds > 0 && sig != syscall.SIGTERM && sig != syscall.SIGINT {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "grace_seconds can only be combined with TERM or INT"})
+		return
+	}
+
 	proc, err := process.NewProcess(req.PID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Process not found"})
 		return
 	}
-	err = proc.Kill()
+
+	targets := []*process.Process{proc}
+	switch {
+	case req.Group:
+		targets, err = collectProcessGroup(req.PID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve process group: " + err.Error()})
+			return
+		}
+	case req.Tree:
+		targets = collectProcessTree(proc)
+	}
+
+	results, err := signalProcesses(targets, sig, sigName, time.Duration(req.GraceSeconds)*time.Second)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to kill process: " + err.Error()})
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "Killed"})
+	for _, res := range results {
+		if res.PID == req.PID && res.Error != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to signal process: " + res.Error, "results": results})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Signalled", "signal": sigName, "results": results})
 }
 
 const nginxPath = "/etc/nginx/sites-available/"
@@ -661,7 +835,7 @@ func listNginxFiles(c *gin.Context) {
 	}
 	var names []string
 	for _, f := range files {
-		if !f.IsDir() {
+		if !f.IsDir() && validNginxFileName(f.Name()) {
 			names = append(names, f.Name())
 		}
 	}
@@ -679,7 +853,9 @@ func getNginxFile(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"content": string(content)})
+	etag := contentHash(content)
+	c.Header("ETag", `"`+etag+`"`)
+	c.JSON(http.StatusOK, gin.H{"content": string(content), "etag": etag})
 }
 
 func saveNginxFile(c *gin.Context) {
@@ -693,55 +869,84 @@ func saveNginxFile(c *gin.Context) {
 		return
 	}
 
-	filePath := filepath.Join(nginxPath, req.Name)
-	currentContent, err := ioutil.ReadFile(filePath)
-	fileExisted := err == nil
-	
-	err = ioutil.WriteFile(filePath, []byte(req.Content), 0644)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write file"})
+	// Saves must name the version they are based on so concurrent edits are
+	// not silently overwritten; If-None-Match: * creates a new file instead.
+	etag := req.ETag
+	if etag == "" {
+		etag = strings.Trim(c.GetHeader("If-Match"), `"`)
+	}
+	createOnly := etag == "" && c.GetHeader("If-None-Match") == "*"
+	if etag == "" && !createOnly {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header (or etag) is required, use If-None-Match: * to create a file"})
 		return
 	}
 
-	cmd := exec.Command("nginx", "-t")
-	output, err := cmd.CombinedOutput()
+	previous, output, err := applyNginxFile(req.Name, []byte(req.Content), etag, createOnly)
 	if err != nil {
-		if fileExisted {
-			ioutil.WriteFile(filePath, currentContent, 0644)
-		} else {
-			os.Remove(filePath)
+		switch {
+		case errors.Is(err, errNginxReload):
+			// The file is in place, only the reload failed: keep the history consistent
+			recordNginxRevision(req.Name, previous, []byte(req.Content), requestUser(c), req.Message, "save")
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": "Saved but nginx reload failed", "details": err.Error()})
+		case errors.Is(err, errNginxConflict):
+			c.JSON(http.StatusConflict, gin.H{"status": "error", "message": "File was modified since it was loaded", "etag": output})
+		case errors.Is(err, errNginxValidation):
+			c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Nginx validation failed", "details": output})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply file: " + err.Error()})
 		}
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Nginx validation failed", "details": string(output)})
 		return
 	}
 
-	exec.Command("systemctl", "reload", "nginx").Run()
-	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Saved & Reloaded"})
+	rev, err := recordNginxRevision(req.Name, previous, []byte(req.Content), requestUser(c), req.Message, "save")
+	if err != nil {
+		fmt.Printf("Nginx: failed to record revision for %s: %v\n", req.Name, err)
+		c.JSON(http.StatusOK, gin.H{"status": "warning", "message": "Saved & Reloaded, but history could not be saved: " + err.Error(), "etag": contentHash([]byte(req.Content))})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Saved & Reloaded", "revision": rev, "etag": contentHash([]byte(req.Content))})
 }
 
-const nginxTemplate = `
-server {
-    listen 80;
-    server_name {{.Domain}};
+// applyNginxFile replaces a file in sites-available through a staged
+// validation and an atomic rename, then reloads nginx. When expectedETag is
+// set it must match the hash of the current content, and createOnly requires
+// the file not to exist; otherwise errNginxConflict is returned with the
+// current hash in place of the output. The file keeps its permissions. It
+// returns the previous content (nil if the file did not exist) and the
+// validation output on failure.
+func applyNginxFile(name string, content []byte, expectedETag string, createOnly bool) ([]byte, string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	filePath := filepath.Join(nginxPath, name)
+	currentContent, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		currentContent = nil
+	}
+	conflict := createOnly && currentContent != nil
+	if expectedETag != "" && (currentContent == nil || contentHash(currentContent) != expectedETag) {
+		conflict = true
+	}
+	if conflict {
+		currentETag := ""
+		if currentContent != nil {
+			currentETag = contentHash(currentContent)
+		}
+		return currentContent, currentETag, errNginxConflict
+	}
 
-    {{if eq .Type "proxy"}}
-    location / {
-        proxy_pass http://127.0.0.1:{{.Target}};
-        proxy_http_version 1.1;
-        proxy_set_header Upgrade $http_upgrade;
-        proxy_set_header Connection 'upgrade';
-        proxy_set_header Host $host;
-        proxy_cache_bypass $http_upgrade;
-    }
-    {{else}}
-    root {{.Target}};
-    index index.html index.htm;
-    location / {
-        try_files $uri $uri/ =404;
-    }
-    {{end}}
+	change := nginxChange{Path: filePath, Content: content}
+	if info, err := os.Stat(filePath); err == nil {
+		change.Mode = info.Mode().Perm()
+	}
+	output, err := applyNginxChangesLocked([]nginxChange{change})
+	return currentContent, output, err
 }
-`
+
+var domainRegex = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
 
 func createSite(c *gin.Context) {
 	var req CreateSiteRequest
@@ -751,96 +956,90 @@ func createSite(c *gin.Context) {
 	}
 
 	// Validation
-	domainRegex := regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
 	if !domainRegex.MatchString(req.Domain) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain format"})
 		return
 	}
 	
-	if req.Type != "proxy" && req.Type != "static" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+	if req.SSLMethod != "" && req.SSLMethod != "certbot" && req.SSLMethod != "dns" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ssl_method must be certbot or dns"})
 		return
 	}
 
-	if strings.Contains(req.Target, "..") || strings.Contains(req.Target, ";") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target"})
-		return
+	if req.Hardening != nil {
+		if err := req.Hardening.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	// Generate Config
-	tmpl, err := template.New("nginx").Parse(nginxTemplate)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Template error"})
-		return
+	if req.DNS != nil {
+		if err := req.DNS.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	var configBuffer bytes.Buffer
-	if err := tmpl.Execute(&configBuffer, req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Template execution error"})
+	// Generate Config
+	config, err := renderSiteConfig(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Paths
-	availablePath := filepath.Join("/etc/nginx/sites-available", req.Domain)
-	enabledPath := filepath.Join("/etc/nginx/sites-enabled", req.Domain)
-
-	// Check if exists
-	if _, err := os.Stat(availablePath); err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Site configuration already exists"})
-		return
-	}
+	// Provision as a job: fully applied or fully rolled back
+	job := newCreateSiteJob(req, config, requestUser(c))
+	job.start()
 
-	// Write File
-	if err := ioutil.WriteFile(availablePath, configBuffer.Bytes(), 0644); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write config file"})
+	switch {
+	case c.Query("stream") == "true":
+		streamProvisionJob(c, job)
 		return
-	}
-
-	// Symlink
-	if err := os.Symlink(availablePath, enabledPath); err != nil {
-		os.Remove(availablePath) // Cleanup
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable site (symlink)"})
+	case c.Query("async") == "true":
+		id := job.snapshot().ID
+		c.JSON(http.StatusAccepted, gin.H{"status": "accepted", "job_id": id, "events": "/api/nginx/jobs/" + id + "/events"})
 		return
 	}
 
-	// Validate (nginx -t)
-	cmd := exec.Command("nginx", "-t")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// Rollback
-		os.Remove(enabledPath)
-		os.Remove(availablePath)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Nginx validation failed", "details": string(output)})
+	state := job.wait()
+	if state.Status != "succeeded" {
+		code := http.StatusInternalServerError
+		var details string
+		for _, step := range state.Steps {
+			if step.Name == state.FailedStep {
+				details = step.Details
+			}
+		}
+		switch state.FailedStep {
+		case "preflight":
+			code = http.StatusConflict
+		case "validate":
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"status": "error",
+			"error": "Site provisioning failed at " + state.FailedStep + ": " + state.Error,
+			"details": details,
+			"job": state,
+		})
 		return
 	}
 
-	// Reload
-	exec.Command("systemctl", "reload", "nginx").Run()
-
-	// SSL (Certbot)
 	var certbotOutput string
-	if req.SSL {
-		certCmd := exec.Command("certbot", "--nginx", "-d", req.Domain, "--non-interactive", "--agree-tos", "--redirect", "-m", "admin@pixelcraft-studio.store")
-		out, err := certCmd.CombinedOutput()
-		certbotOutput = string(out)
-		
-		if err != nil {
-			c.JSON(http.StatusOK, gin.H{
-				"status": "warning", 
-				"message": "Site created but SSL failed", 
-				"details": certbotOutput,
-			})
-			return
+	for _, step := range state.Steps {
+		if step.Name == "certificate" {
+			certbotOutput = step.Details
 		}
 	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success", 
 		"message": "Site created successfully", 
 		"ssl_output": certbotOutput,
+		"job": state,
 	})
 }
 
-// --- Cloudflare Handler ---
+// --- DNS Record Handlers ---
 
 func addDNSRecord(c *gin.Context) {
 	var req CloudflareRecordRequest
@@ -848,77 +1047,55 @@ func addDNSRecord(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
-
-	if CloudflareAPIToken == "" || CloudflareZoneID == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cloudflare credentials not configured"})
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Default TTL if not set or 0
-	if req.TTL == 0 {
-		req.TTL = 1 // Auto
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
 	}
-
-	payload, _ := json.Marshal(req)
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", CloudflareZoneID)
-
-	client := &http.Client{}
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+	zoneID, ok := requestProviderZone(c, provider, req.Name)
+	if !ok {
 		return
 	}
 
-	httpReq.Header.Set("Authorization", "Bearer "+CloudflareAPIToken)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(httpReq)
+	desired, err := req.record()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to contact Cloudflare: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
-	
-	// Passthrough the Cloudflare response for now, or parse it to check success
-	var cfResp map[string]interface{}
-	json.Unmarshal(body, &cfResp)
-
-	success, _ := cfResp["success"].(bool)
-	if !success {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": "error",
-			"message": "Cloudflare API Error",
-			"details": cfResp,
-		})
+	record, err := provider.CreateRecord(c.Request.Context(), zoneID, desired)
+	if err != nil {
+		dnsProviderError(c, "Failed to add record", err)
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "DNS Record Added", "data": cfResp})
+	change := logDNSChange(provider.Name(), zoneID, "create", "api", nil, &record, requestUser(c))
+	propagation := fmt.Sprintf("/api/dns/propagation?provider=%s&zone=%s&record_id=%s", provider.Name(), url.QueryEscape(zoneID), url.QueryEscape(record.ID))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "DNS Record Added", "data": record, "propagation": propagation, "change_id": change.ID})
 }
 
 func listDNSRecords(c *gin.Context) {
-	if CloudflareAPIToken == "" || CloudflareZoneID == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cloudflare credentials not configured"})
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zoneID, ok := requestProviderZone(c, provider, c.Query("name"))
+	if !ok {
 		return
 	}
 
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?per_page=100", CloudflareZoneID)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+CloudflareAPIToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	records, err := provider.ListRecords(c.Request.Context(), zoneID, dnsprovider.Filter{
+		Type: c.Query("type"),
+		Name: c.Query("name"),
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch records"})
+		dnsProviderError(c, "Failed to fetch records", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+	// Same shape as the Cloudflare API, which the dashboard consumes
+	c.JSON(http.StatusOK, gin.H{"success": true, "zone_id": zoneID, "result": records})
 }
 
 func updateDNSRecord(c *gin.Context) {
@@ -928,45 +1105,58 @@ func updateDNSRecord(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
-
-	// TTL fix
-	if req.TTL == 0 {
-		req.TTL = 1
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	payload, _ := json.Marshal(req)
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", CloudflareZoneID, id)
-
-	httpReq, _ := http.NewRequest("PUT", url, bytes.NewBuffer(payload))
-	httpReq.Header.Set("Authorization", "Bearer "+CloudflareAPIToken)
-	httpReq.Header.Set("Content-Type", "application/json")
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zoneID, ok := requestProviderZone(c, provider, req.Name)
+	if !ok {
+		return
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	desired, err := req.record()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, ok := snapshotDNSRecord(c, provider, zoneID, id)
+	if !ok {
+		return
+	}
+	record, err := provider.UpdateRecord(c.Request.Context(), zoneID, id, desired)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record"})
+		dnsProviderError(c, "Failed to update record", err)
 		return
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+	change := logDNSChange(provider.Name(), zoneID, "update", "api", &before, &record, requestUser(c))
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": record, "change_id": change.ID})
 }
 
 func deleteDNSRecord(c *gin.Context) {
 	id := c.Param("id")
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", CloudflareZoneID, id)
-
-	httpReq, _ := http.NewRequest("DELETE", url, nil)
-	httpReq.Header.Set("Authorization", "Bearer "+CloudflareAPIToken)
-	httpReq.Header.Set("Content-Type", "application/json")
+	provider, ok := requestDNSProvider(c)
+	if !ok {
+		return
+	}
+	zoneID, ok := requestProviderZone(c, provider, c.Query("name"))
+	if !ok {
+		return
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record"})
+	// Kept so the record can be recreated from the history
+	before, ok := snapshotDNSRecord(c, provider, zoneID, id)
+	if !ok {
+		return
+	}
+	if err := provider.DeleteRecord(c.Request.Context(), zoneID, id); err != nil {
+		dnsProviderError(c, "Failed to delete record", err)
 		return
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+	change := logDNSChange(provider.Name(), zoneID, "delete", "api", &before, nil, requestUser(c))
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": gin.H{"id": id}, "change_id": change.ID})
 }
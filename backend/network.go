@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// --- Network Interfaces ---
+
+type InterfaceCounters struct {
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	ErrIn       uint64 `json:"err_in"`
+	ErrOut      uint64 `json:"err_out"`
+	DropIn      uint64 `json:"drop_in"`
+	DropOut     uint64 `json:"drop_out"`
+}
+
+type InterfaceRates struct {
+	WindowSeconds float64 `json:"window_seconds"`
+	BytesSentPS   float64 `json:"bytes_sent_per_sec"`
+	BytesRecvPS   float64 `json:"bytes_recv_per_sec"`
+	PacketsSentPS float64 `json:"packets_sent_per_sec"`
+	PacketsRecvPS float64 `json:"packets_recv_per_sec"`
+	ErrorsPS      float64 `json:"errors_per_sec"`
+	DropsPS       float64 `json:"drops_per_sec"`
+}
+
+const maxSampleWindow = 10 * time.Second
+
+// Prefixes of bridge/virtual interfaces created by docker, libvirt and friends.
+var virtualInterfacePrefixes = []string{"docker", "br-", "veth", "virbr", "cni", "flannel", "cali", "vnet"}
+
+type interfaceFilter struct {
+	ExcludeLoopback bool
+	ExcludeVirtual  bool
+}
+
+func isLoopbackInterface(iface net.InterfaceStat) bool {
+	for _, flag := range iface.Flags {
+		if flag == "loopback" {
+			return true
+		}
+	}
+	return iface.Name == "lo"
+}
+
+func isVirtualInterface(name string) bool {
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f interfaceFilter) skip(iface net.InterfaceStat) bool {
+	if f.ExcludeLoopback && isLoopbackInterface(iface) {
+		return true
+	}
+	return f.ExcludeVirtual && isVirtualInterface(iface.Name)
+}
+
+// interfaceLinkState reads the kernel operstate ("up", "down", "unknown", ...).
+func interfaceLinkState(name string) string {
+	data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "operstate"))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func countersFromStat(io net.IOCountersStat) *InterfaceCounters {
+	return &InterfaceCounters{
+		BytesSent:   io.BytesSent,
+		BytesRecv:   io.BytesRecv,
+		PacketsSent: io.PacketsSent,
+		PacketsRecv: io.PacketsRecv,
+		ErrIn:       io.Errin,
+		ErrOut:      io.Errout,
+		DropIn:      io.Dropin,
+		DropOut:     io.Dropout,
+	}
+}
+
+// delta tolerates counter resets (interface re-created) by reporting zero.
+func delta(after, before uint64) float64 {
+	if after < before {
+		return 0
+	}
+	return float64(after - before)
+}
+
+func computeRates(before, after *InterfaceCounters, window time.Duration) *InterfaceRates {
+	secs := window.Seconds()
+	return &InterfaceRates{
+		WindowSeconds: secs,
+		BytesSentPS:   delta(after.BytesSent, before.BytesSent) / secs,
+		BytesRecvPS:   delta(after.BytesRecv, before.BytesRecv) / secs,
+		PacketsSentPS: delta(after.PacketsSent, before.PacketsSent) / secs,
+		PacketsRecvPS: delta(after.PacketsRecv, before.PacketsRecv) / secs,
+		ErrorsPS:      (delta(after.ErrIn, before.ErrIn) + delta(after.ErrOut, before.ErrOut)) / secs,
+		DropsPS:       (delta(after.DropIn, before.DropIn) + delta(after.DropOut, before.DropOut)) / secs,
+	}
+}
+
+func perInterfaceCounters() map[string]*InterfaceCounters {
+	counters := make(map[string]*InterfaceCounters)
+	io, err := net.IOCounters(true)
+	if err != nil {
+		return counters
+	}
+	for _, stat := range io {
+		counters[stat.Name] = countersFromStat(stat)
+	}
+	return counters
+}
+
+// collectInterfaces lists interfaces with their counters and, when window > 0,
+// rates measured by sampling the counters twice.
+func collectInterfaces(filter interfaceFilter, window time.Duration) []InterfaceInfo {
+	ifaces, _ := net.Interfaces()
+
+	before := perInterfaceCounters()
+	after := before
+	if window > 0 {
+		time.Sleep(window)
+		after = perInterfaceCounters()
+	}
+
+	var interfaces []InterfaceInfo
+	for _, i := range ifaces {
+		if filter.skip(i) {
+			continue
+		}
+		var ipv4s, ipv6s []string
+		for _, addr := range i.Addrs {
+			ip := addr.Addr
+			if strings.Contains(ip, ":") {
+				ipv6s = append(ipv6s, ip)
+			} else {
+				ipv4s = append(ipv4s, ip)
+			}
+		}
+		info := InterfaceInfo{
+			Name:      i.Name,
+			IPv4:      ipv4s,
+			IPv6:      ipv6s,
+			MTU:       i.MTU,
+			MAC:       i.HardwareAddr,
+			Flags:     i.Flags,
+			LinkState: interfaceLinkState(i.Name),
+			Counters:  after[i.Name],
+		}
+		if window > 0 && before[i.Name] != nil && after[i.Name] != nil {
+			info.Rates = computeRates(before[i.Name], after[i.Name], window)
+		}
+		interfaces = append(interfaces, info)
+	}
+	return interfaces
+}
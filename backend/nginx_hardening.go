@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"system-manager/nginxconf"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+)
+
+// --- Site Hardening ---
+//
+// Hardening settings are rendered into a snippet that is included in every
+// server block of the site. limit_req_zone must live at http level, so rate
+// limiting gets its own file in conf.d. The settings themselves are kept as
+// JSON in the state directory so they can be edited later.
+//
+// Headers are set at server level; nginx drops them in any location that has
+// add_header of its own, so such locations include the snippet again.
+//
+// Password files are only readable by root and the nginx worker group.
+
+const (
+	hardeningSnippetDir = "/etc/nginx/snippets/system-manager"
+	htpasswdDir         = "/etc/nginx/htpasswd"
+)
+
+type HSTSSettings struct {
+	MaxAge            int  `json:"max_age"` // Seconds, default one year
+	IncludeSubdomains bool `json:"include_subdomains"`
+	Preload           bool `json:"preload"`
+}
+
+type RateLimitSettings struct {
+	Rate     string `json:"rate"` // e.g. "10r/s" or "300r/m"
+	Burst    int    `json:"burst"`
+	NoDelay  bool   `json:"nodelay"`
+	ZoneSize string `json:"zone_size"` // Shared memory, default "10m"
+}
+
+type BasicAuthUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type BasicAuthSettings struct {
+	Realm string `json:"realm"`
+	// Users are added to (or updated in) the htpasswd file; existing users are kept.
+	Users []BasicAuthUser `json:"users,omitempty"`
+}
+
+type SiteHardening struct {
+	HSTS              *HSTSSettings      `json:"hsts,omitempty"`
+	CSP               string             `json:"csp,omitempty"`
+	XFrameOptions     string             `json:"x_frame_options,omitempty"` // DENY or SAMEORIGIN
+	RateLimit         *RateLimitSettings `json:"rate_limit,omitempty"`
+	BasicAuth         *BasicAuthSettings `json:"basic_auth,omitempty"`
+	Gzip              bool               `json:"gzip"`
+	Brotli            bool               `json:"brotli"` // Requires ngx_brotli
+	ClientMaxBodySize string             `json:"client_max_body_size,omitempty"`
+	Allow             []string           `json:"allow,omitempty"` // IPs/CIDRs; anything else is denied
+	Deny              []string           `json:"deny,omitempty"`
+}
+
+var (
+	nginxRateRegex  = regexp.MustCompile(`^[0-9]+r/[sm]$`)
+	nginxSizeRegex  = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+	htpasswdUserRe  = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+	unsafeHeaderVal = regexp.MustCompile(`["\\$\r\n]`)
+)
+
+func hardeningSnippetPath(name string) string {
+	return filepath.Join(hardeningSnippetDir, name+".conf")
+}
+
+func rateLimitConfPath(name string) string {
+	return filepath.Join(nginxConfDPath, "system-manager-"+name+"-ratelimit.conf")
+}
+
+func htpasswdPath(name string) string {
+	return filepath.Join(htpasswdDir, name)
+}
+
+func hardeningSettingsPath(name string) string {
+	return filepath.Join(stateDir, "nginx-hardening", name+".json")
+}
+
+func rateLimitZoneName(name string) string {
+	return "sm_" + strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+func validIPOrCIDR(value string) bool {
+	if stdnet.ParseIP(value) != nil {
+		return true
+	}
+	_, _, err := stdnet.ParseCIDR(value)
+	return err == nil
+}
+
+// Validate checks the settings and fills in defaults.
+func (h *SiteHardening) Validate() error {
+	if h.HSTS != nil {
+		if h.HSTS.MaxAge == 0 {
+			h.HSTS.MaxAge = 31536000
+		}
+		if h.HSTS.MaxAge < 0 {
+			return errors.New("hsts.max_age must not be negative")
+		}
+		if h.HSTS.Preload && (!h.HSTS.IncludeSubdomains || h.HSTS.MaxAge < 31536000) {
+			return errors.New("hsts.preload requires include_subdomains and a max_age of at least one year")
+		}
+	}
+	if h.CSP != "" && (unsafeHeaderVal.MatchString(h.CSP) || len(h.CSP) > 4096) {
+		return errors.New("csp contains invalid characters")
+	}
+	switch h.XFrameOptions {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return errors.New("x_frame_options must be DENY or SAMEORIGIN")
+	}
+	if h.RateLimit != nil {
+		if !nginxRateRegex.MatchString(h.RateLimit.Rate) {
+			return errors.New("rate_limit.rate must look like 10r/s or 300r/m")
+		}
+		if h.RateLimit.Burst < 0 {
+			return errors.New("rate_limit.burst must not be negative")
+		}
+		if h.RateLimit.ZoneSize == "" {
+			h.RateLimit.ZoneSize = "10m"
+		}
+		if !nginxSizeRegex.MatchString(h.RateLimit.ZoneSize) {
+			return errors.New("rate_limit.zone_size must look like 10m")
+		}
+	}
+	if h.BasicAuth != nil {
+		if h.BasicAuth.Realm == "" {
+			h.BasicAuth.Realm = "Restricted"
+		}
+		if unsafeHeaderVal.MatchString(h.BasicAuth.Realm) {
+			return errors.New("basic_auth.realm contains invalid characters")
+		}
+		for _, u := range h.BasicAuth.Users {
+			if err := validateHtpasswdUser(u.Username, u.Password); err != nil {
+				return err
+			}
+		}
+	}
+	if h.ClientMaxBodySize != "" && !nginxSizeRegex.MatchString(h.ClientMaxBodySize) {
+		return errors.New("client_max_body_size must look like 50m")
+	}
+	for _, ip := range h.Allow {
+		if !validIPOrCIDR(ip) {
+			return fmt.Errorf("invalid allow entry %q", ip)
+		}
+	}
+	for _, ip := range h.Deny {
+		if ip != "all" && !validIPOrCIDR(ip) {
+			return fmt.Errorf("invalid deny entry %q", ip)
+		}
+	}
+	return nil
+}
+
+const compressTypes = "text/plain text/css text/xml application/json application/javascript application/xml application/rss+xml image/svg+xml"
+
+var hardeningSnippetTemplate = template.Must(template.New("hardening").Parse(`# Managed by system-manager, edit with PUT /api/nginx/site/{{.Name}}/hardening
+{{- with .H.HSTS}}
+add_header Strict-Transport-Security "max-age={{.MaxAge}}{{if .IncludeSubdomains}}; includeSubDomains{{end}}{{if .Preload}}; preload{{end}}" always;
+{{- end}}
+{{- if .H.CSP}}
+add_header Content-Security-Policy "{{.H.CSP}}" always;
+{{- end}}
+{{- if .H.XFrameOptions}}
+add_header X-Frame-Options {{.H.XFrameOptions}} always;
+{{- end}}
+{{- with .H.RateLimit}}
+limit_req zone={{$.Zone}}{{if .Burst}} burst={{.Burst}}{{end}}{{if .NoDelay}} nodelay{{end}};
+limit_req_status 429;
+{{- end}}
+{{- with .H.BasicAuth}}
+auth_basic "{{.Realm}}";
+auth_basic_user_file {{$.Htpasswd}};
+{{- end}}
+{{- if .H.Gzip}}
+gzip on;
+gzip_vary on;
+gzip_proxied any;
+gzip_comp_level 5;
+gzip_types ` + compressTypes + `;
+{{- end}}
+{{- if .H.Brotli}}
+brotli on;
+brotli_comp_level 5;
+brotli_types ` + compressTypes + `;
+{{- end}}
+{{- if .H.ClientMaxBodySize}}
+client_max_body_size {{.H.ClientMaxBodySize}};
+{{- end}}
+{{- range .H.Deny}}
+deny {{.}};
+{{- end}}
+{{- range .H.Allow}}
+allow {{.}};
+{{- end}}
+{{- if .H.Allow}}
+deny all;
+{{- end}}
+`))
+
+// hardeningChanges renders the snippet, rate limit zone and htpasswd file of a
+// site. The settings must have been validated.
+func hardeningChanges(name string, h *SiteHardening) ([]nginxChange, error) {
+	var snippet bytes.Buffer
+	data := struct {
+		Name, Zone, Htpasswd string
+		H                    *SiteHardening
+	}{name, rateLimitZoneName(name), htpasswdPath(name), h}
+	if err := hardeningSnippetTemplate.Execute(&snippet, data); err != nil {
+		return nil, err
+	}
+	changes := []nginxChange{{Path: hardeningSnippetPath(name), Content: snippet.Bytes()}}
+
+	if h.RateLimit != nil {
+		zone := fmt.Sprintf("# Managed by system-manager for %s\nlimit_req_zone $binary_remote_addr zone=%s:%s rate=%s;\n",
+			name, rateLimitZoneName(name), h.RateLimit.ZoneSize, h.RateLimit.Rate)
+		changes = append(changes, nginxChange{Path: rateLimitConfPath(name), Content: []byte(zone)})
+	} else if _, err := os.Stat(rateLimitConfPath(name)); err == nil {
+		changes = append(changes, nginxChange{Path: rateLimitConfPath(name), Remove: true})
+	}
+
+	if h.BasicAuth != nil {
+		entries, err := readHtpasswd(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range h.BasicAuth.Users {
+			if entries, err = setHtpasswdEntry(entries, u.Username, u.Password); err != nil {
+				return nil, err
+			}
+		}
+		gid, err := nginxWorkerGID()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, nginxChange{Path: htpasswdPath(name), Content: formatHtpasswd(entries), Mode: 0640, GID: gid})
+	}
+	return changes, nil
+}
+
+// removeHardeningChanges returns the changes that delete every file generated
+// for a site.
+func removeHardeningChanges(name string) []nginxChange {
+	var changes []nginxChange
+	for _, path := range []string{hardeningSnippetPath(name), rateLimitConfPath(name), htpasswdPath(name)} {
+		if _, err := os.Lstat(path); err == nil {
+			changes = append(changes, nginxChange{Path: path, Remove: true})
+		}
+	}
+	return changes
+}
+
+// withHardeningInclude adds the snippet include after the server_name of each
+// server block, and at the top of each location with add_header of its own,
+// wherever the config does not include it yet.
+func withHardeningInclude(content []byte, name string) ([]byte, bool, error) {
+	include := hardeningSnippetPath(name)
+	directives, err := nginxconf.Parse(string(content))
+	if err != nil {
+		return nil, false, err
+	}
+	includes := func(block []*nginxconf.Directive) bool {
+		for _, d := range nginxconf.Find(block, "include") {
+			if len(d.Args) == 1 && d.Args[0] == include {
+				return true
+			}
+		}
+		return false
+	}
+
+	lines := strings.Split(string(content), "\n")
+	after := map[int]string{}  // Line index to the text inserted after it
+	before := map[int]string{} // Line index to the text inserted before it
+	indentOf := func(line int) string {
+		text := lines[line-1]
+		return text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+	}
+	for _, server := range nginxconf.FindRecursive(directives, "server") {
+		names := nginxconf.Find(server.Block, "server_name")
+		if len(names) == 0 {
+			continue
+		}
+		if !includes(server.Block) {
+			if names[0].Line == server.Line {
+				return nil, false, fmt.Errorf("server block on line %d must have its directives on separate lines", server.Line)
+			}
+			after[names[0].Line-1] += "\n" + indentOf(names[0].Line) + "include " + include + ";"
+		}
+		for _, location := range nginxconf.FindRecursive(server.Block, "location") {
+			if len(nginxconf.Find(location.Block, "add_header")) == 0 || includes(location.Block) {
+				continue
+			}
+			first := location.Block[0]
+			if first.Line == location.Line {
+				return nil, false, fmt.Errorf("location on line %d must have its directives on separate lines", location.Line)
+			}
+			before[first.Line-1] += indentOf(first.Line) + "include " + include + ";\n"
+		}
+	}
+	if len(after) == 0 && len(before) == 0 {
+		return content, false, nil
+	}
+
+	var out strings.Builder
+	for i, line := range lines {
+		out.WriteString(before[i])
+		out.WriteString(line)
+		out.WriteString(after[i])
+		if i < len(lines)-1 {
+			out.WriteString("\n")
+		}
+	}
+	return []byte(out.String()), true, nil
+}
+
+// saveHardeningSettings stores the settings without basic auth passwords.
+func saveHardeningSettings(name string, h *SiteHardening) error {
+	stored := *h
+	if h.BasicAuth != nil {
+		stored.BasicAuth = &BasicAuthSettings{Realm: h.BasicAuth.Realm}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := hardeningSettingsPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func loadHardeningSettings(name string) (*SiteHardening, error) {
+	data, err := os.ReadFile(hardeningSettingsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var h SiteHardening
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// applySiteHardening replaces the hardening of an existing site, adding the
+// snippet include to its config if needed.
+func applySiteHardening(name string, h *SiteHardening, author string) (string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	sitePath := filepath.Join(nginxPath, name)
+	current, err := os.ReadFile(sitePath)
+	if err != nil {
+		return "", fmt.Errorf("site %s does not exist", name)
+	}
+	changes, err := hardeningChanges(name, h)
+	if err != nil {
+		return "", err
+	}
+	updated, modified, err := withHardeningInclude(current, name)
+	if err != nil {
+		return "", fmt.Errorf("cannot add the hardening include to %s: %w", name, err)
+	}
+	if modified {
+		changes = append(changes, nginxChange{Path: sitePath, Content: updated})
+	}
+
+	output, err := applyNginxChangesLocked(changes)
+	if errors.Is(err, errNginxValidation) || (err != nil && !errors.Is(err, errNginxReload)) {
+		return output, err
+	}
+	if modified {
+		if _, histErr := recordNginxRevision(name, current, updated, author, "Hardening include added", "hardening"); histErr != nil {
+			fmt.Printf("Nginx: failed to record revision for %s: %v\n", name, histErr)
+		}
+	}
+	if saveErr := saveHardeningSettings(name, h); saveErr != nil {
+		fmt.Printf("Nginx: failed to save hardening settings for %s: %v\n", name, saveErr)
+	}
+	return output, err
+}
+
+// --- htpasswd ---
+
+type htpasswdEntry struct {
+	Username string
+	Hash     string
+}
+
+func validateHtpasswdUser(username, password string) error {
+	if !htpasswdUserRe.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	if password == "" || len(password) > 256 {
+		return errors.New("password must be between 1 and 256 characters")
+	}
+	return nil
+}
+
+func readHtpasswd(name string) ([]htpasswdEntry, error) {
+	f, err := os.Open(htpasswdPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []htpasswdEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			entries = append(entries, htpasswdEntry{Username: parts[0], Hash: parts[1]})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// setHtpasswdEntry adds or replaces a user, hashing the password with SHA-512
+// crypt which nginx verifies through the system crypt().
+func setHtpasswdEntry(entries []htpasswdEntry, username, password string) ([]htpasswdEntry, error) {
+	hash, err := sha512_crypt.New().Generate([]byte(password), nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Username == username {
+			entries[i].Hash = hash
+			return entries, nil
+		}
+	}
+	return append(entries, htpasswdEntry{Username: username, Hash: hash}), nil
+}
+
+func formatHtpasswd(entries []htpasswdEntry) []byte {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%s:%s\n", e.Username, e.Hash)
+	}
+	return buf.Bytes()
+}
+
+// updateHtpasswd rewrites the htpasswd file of a site. nginx reads it on each
+// request, so no validation or reload is needed.
+func updateHtpasswd(name string, update func([]htpasswdEntry) ([]htpasswdEntry, error)) error {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readHtpasswd(name)
+	if err != nil {
+		return err
+	}
+	if entries, err = update(entries); err != nil {
+		return err
+	}
+	gid, err := nginxWorkerGID()
+	if err != nil {
+		return err
+	}
+	return commitNginxChanges([]nginxChange{{Path: htpasswdPath(name), Content: formatHtpasswd(entries), Mode: 0640, GID: gid}})
+}
+
+// nginxWorkerGID returns the group nginx workers run as, from the user
+// directive of nginx.conf (www-data when it is not set).
+func nginxWorkerGID() (int, error) {
+	group := "www-data"
+	if data, err := os.ReadFile(filepath.Join(nginxRootPath, "nginx.conf")); err == nil {
+		if directives, err := nginxconf.Parse(string(data)); err == nil {
+			if args := nginxconf.First(directives, "user"); len(args) == 2 {
+				group = args[1]
+			} else if len(args) == 1 {
+				group = args[0] // The group defaults to the user's name
+			}
+		}
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("nginx worker group: %w", err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// --- Site Hardening Handlers ---
+
+func getSiteHardening(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	h, err := loadHardeningSettings(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusOK, gin.H{"name": name, "managed": false, "hardening": SiteHardening{}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "managed": true, "hardening": h})
+}
+
+func updateSiteHardening(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	var req SiteHardening
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	output, err := applySiteHardening(name, &req, requestUser(c))
+	if errors.Is(err, errNginxValidation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nginx validation failed", "details": output})
+		return
+	} else if errors.Is(err, errNginxReload) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Hardening applied but nginx reload failed", "details": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Hardening applied & Reloaded"})
+}
+
+func listHtpasswdUsers(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	entries, err := readHtpasswd(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	users := []string{}
+	for _, e := range entries {
+		users = append(users, e.Username)
+	}
+	sort.Strings(users)
+	c.JSON(http.StatusOK, gin.H{"name": name, "users": users})
+}
+
+func setHtpasswdUser(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	var req BasicAuthUser
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := validateHtpasswdUser(req.Username, req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := updateHtpasswd(name, func(entries []htpasswdEntry) ([]htpasswdEntry, error) {
+		return setHtpasswdEntry(entries, req.Username, req.Password)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "User saved"})
+}
+
+func deleteHtpasswdUser(c *gin.Context) {
+	name := c.Param("name")
+	username := c.Param("user")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+
+	found := false
+	err := updateHtpasswd(name, func(entries []htpasswdEntry) ([]htpasswdEntry, error) {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.Username == username {
+				found = true
+				continue
+			}
+			kept = append(kept, e)
+		}
+		return kept, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "User deleted"})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithHardeningInclude(t *testing.T) {
+	include := "include " + hardeningSnippetPath("example.com") + ";"
+	site := `server {
+    listen 80;
+    server_name example.com;
+    root /srv/app;
+
+    location = /index.html {
+        add_header Cache-Control "no-cache";
+    }
+
+    location /assets/ {
+        expires 1y;
+        location ~ \.js$ {
+            add_header Cache-Control "public, immutable";
+        }
+    }
+
+    location / {
+        try_files $uri /index.html;
+    }
+}
+`
+	want := `server {
+    listen 80;
+    server_name example.com;
+    ` + include + `
+    root /srv/app;
+
+    location = /index.html {
+        ` + include + `
+        add_header Cache-Control "no-cache";
+    }
+
+    location /assets/ {
+        expires 1y;
+        location ~ \.js$ {
+            ` + include + `
+            add_header Cache-Control "public, immutable";
+        }
+    }
+
+    location / {
+        try_files $uri /index.html;
+    }
+}
+`
+	got, modified, err := withHardeningInclude([]byte(site), "example.com")
+	if err != nil {
+		t.Fatalf("withHardeningInclude: %v", err)
+	}
+	if !modified || string(got) != want {
+		t.Fatalf("withHardeningInclude (modified %v)\n got:\n%s\nwant:\n%s", modified, got, want)
+	}
+
+	// Applying it again changes nothing
+	if again, modified, err := withHardeningInclude(got, "example.com"); err != nil || modified || string(again) != want {
+		t.Errorf("second run modified %v, err %v:\n%s", modified, err, again)
+	}
+
+	// A config with the server level include only gets the locations fixed
+	partial := strings.Replace(want, "        "+include+"\n        add_header", "        add_header", 1)
+	if got, modified, err := withHardeningInclude([]byte(partial), "example.com"); err != nil || !modified || string(got) != want {
+		t.Errorf("partial config: modified %v, err %v:\n%s", modified, err, got)
+	}
+
+	if _, _, err := withHardeningInclude([]byte("server {\n    server_name a;\n    location / { add_header X a; }\n}\n"), "example.com"); err == nil {
+		t.Error("one-line location with add_header was accepted")
+	}
+}
+
+func TestWithHardeningIncludeSPA(t *testing.T) {
+	// A site created without hardening has no include anywhere
+	req := CreateSiteRequest{Domain: "example.com", Type: "spa", Params: []byte(`{"root":"/srv/app"}`)}
+	config, err := renderSiteConfig(&req)
+	if err != nil {
+		t.Fatalf("renderSiteConfig: %v", err)
+	}
+	got, _, err := withHardeningInclude(config, "example.com")
+	if err != nil {
+		t.Fatalf("withHardeningInclude: %v", err)
+	}
+	include := "include " + hardeningSnippetPath("example.com") + ";"
+	if n := strings.Count(string(got), include); n != 3 {
+		t.Errorf("snippet included %d times, want 3:\n%s", n, got)
+	}
+}
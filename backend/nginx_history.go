@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Nginx Revision History ---
+//
+// Every saved version of a file in sites-available is stored as a JSON document
+// under <stateDir>/nginx-history/<file>/<id>.json.
+
+type NginxRevision struct {
+	ID        int       `json:"id"`
+	File      string    `json:"file"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	Action    string    `json:"action"` // "snapshot", "save", "restore", "create", "delete"
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+	Size      int       `json:"size"`
+	Content   string    `json:"content,omitempty"`
+}
+
+var nginxHistoryMu sync.Mutex
+
+func nginxHistoryDir(file string) string {
+	return filepath.Join(stateDir, "nginx-history", file)
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func validNginxFileName(name string) bool {
+	return name != "" && !strings.Contains(name, "..") && !strings.Contains(name, "/") && !strings.HasPrefix(name, ".")
+}
+
+// readNginxRevisions loads every revision of a file ordered by ID. The caller
+// must hold nginxHistoryMu.
+func readNginxRevisions(file string, withContent bool) ([]NginxRevision, error) {
+	entries, err := os.ReadDir(nginxHistoryDir(file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revisions []NginxRevision
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(nginxHistoryDir(file), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var rev NginxRevision
+		if err := json.Unmarshal(data, &rev); err != nil {
+			return nil, fmt.Errorf("corrupt revision %s: %w", entry.Name(), err)
+		}
+		if !withContent {
+			rev.Content = ""
+		}
+		revisions = append(revisions, rev)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].ID < revisions[j].ID })
+	return revisions, nil
+}
+
+func writeNginxRevision(rev NginxRevision) error {
+	dir := nginxHistoryDir(rev.File)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("%06d.json", rev.ID)), data, 0600)
+}
+
+// recordNginxRevision appends a revision for file. When the content before this
+// change is not the latest stored revision (first tracked change, or an edit made
+// outside the panel such as certbot), it is stored first as a snapshot so every
+// state the panel replaced can be restored.
+func recordNginxRevision(file string, previous []byte, content []byte, author, message, action string) (NginxRevision, error) {
+	nginxHistoryMu.Lock()
+	defer nginxHistoryMu.Unlock()
+
+	revisions, err := readNginxRevisions(file, false)
+	if err != nil {
+		return NginxRevision{}, err
+	}
+	nextID := 1
+	if len(revisions) > 0 {
+		nextID = revisions[len(revisions)-1].ID + 1
+	}
+	if previous != nil && (len(revisions) == 0 || revisions[len(revisions)-1].Hash != contentHash(previous)) {
+		snapshot := NginxRevision{
+			ID:        nextID,
+			File:      file,
+			Author:    "system",
+			Message:   "Snapshot of untracked content",
+			Action:    "snapshot",
+			Timestamp: time.Now(),
+			Hash:      contentHash(previous),
+			Size:      len(previous),
+			Content:   string(previous),
+		}
+		if err := writeNginxRevision(snapshot); err != nil {
+			return NginxRevision{}, err
+		}
+		nextID++
+	}
+
+	rev := NginxRevision{
+		ID:        nextID,
+		File:      file,
+		Author:    author,
+		Message:   message,
+		Action:    action,
+		Timestamp: time.Now(),
+		Hash:      contentHash(content),
+		Size:      len(content),
+		Content:   string(content),
+	}
+	if err := writeNginxRevision(rev); err != nil {
+		return NginxRevision{}, err
+	}
+	rev.Content = ""
+	return rev, nil
+}
+
+func loadNginxRevision(file string, id int) (NginxRevision, error) {
+	nginxHistoryMu.Lock()
+	defer nginxHistoryMu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(nginxHistoryDir(file), fmt.Sprintf("%06d.json", id)))
+	if err != nil {
+		return NginxRevision{}, err
+	}
+	var rev NginxRevision
+	err = json.Unmarshal(data, &rev)
+	return rev, err
+}
+
+// revisionContent resolves a revision reference: a numeric ID or "current"
+// for the live file.
+func revisionContent(file, ref string) (string, string, error) {
+	if ref == "current" {
+		content, err := os.ReadFile(filepath.Join(nginxPath, file))
+		if err != nil {
+			return "", "", err
+		}
+		return string(content), file + " (current)", nil
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid revision %q", ref)
+	}
+	rev, err := loadNginxRevision(file, id)
+	if err != nil {
+		return "", "", err
+	}
+	return rev.Content, fmt.Sprintf("%s (revision %d)", file, rev.ID), nil
+}
+
+// --- Nginx Revision Handlers ---
+
+func listNginxRevisions(c *gin.Context) {
+	name := c.Query("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	nginxHistoryMu.Lock()
+	revisions, err := readNginxRevisions(name, false)
+	nginxHistoryMu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read history: " + err.Error()})
+		return
+	}
+	if revisions == nil {
+		revisions = []NginxRevision{}
+	}
+	// Newest first
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].ID > revisions[j].ID })
+	c.JSON(http.StatusOK, revisions)
+}
+
+func getNginxRevision(c *gin.Context) {
+	name := c.Query("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision id"})
+		return
+	}
+	rev, err := loadNginxRevision(name, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
+
+func diffNginxRevisions(c *gin.Context) {
+	name := c.Query("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	from, to := c.Query("from"), c.DefaultQuery("to", "current")
+	if from == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from revision required"})
+		return
+	}
+	fromContent, fromLabel, err := revisionContent(name, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found: " + from})
+		return
+	}
+	toContent, toLabel, err := revisionContent(name, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found: " + to})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":      from,
+		"to":        to,
+		"identical": fromContent == toContent,
+		"diff":      unifiedDiff(fromLabel, toLabel, fromContent, toContent),
+	})
+}
+
+func restoreNginxRevision(c *gin.Context) {
+	var req struct {
+		Name    string `json:"name"`
+		ID      int    `json:"id"`
+		Message string `json:"message"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !validNginxFileName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	rev, err := loadNginxRevision(req.Name, req.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	message := req.Message
+	if message == "" {
+		message = fmt.Sprintf("Restore revision %d", rev.ID)
+	}
+	previous, output, err := applyNginxFile(req.Name, []byte(rev.Content), "", false)
+	if errors.Is(err, errNginxValidation) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Nginx validation failed", "details": output})
+		return
+	} else if errors.Is(err, errNginxReload) {
+		recordNginxRevision(req.Name, previous, []byte(rev.Content), requestUser(c), message, "restore")
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": "Restored but nginx reload failed", "details": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore file: " + err.Error()})
+		return
+	}
+	saved, err := recordNginxRevision(req.Name, previous, []byte(rev.Content), requestUser(c), message, "restore")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "warning", "message": "Restored & Reloaded, but history could not be saved: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Restored & Reloaded", "revision": saved})
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// --- Nginx Logs ---
+
+const (
+	nginxLogDir        = "/var/log/nginx"
+	maxLogTailLines    = 1000
+	maxLogStatsWindow  = 7 * 24 * time.Hour
+	logTailInterval    = 500 * time.Millisecond
+	logReadChunk       = 64 << 10
+	nginxAccessTimeFmt = "02/Jan/2006:15:04:05 -0700"
+	nginxErrorTimeFmt  = "2006/01/02 15:04:05"
+)
+
+// combinedLogRegex matches nginx's default "combined" log format.
+var combinedLogRegex = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"`)
+
+var errorLogRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] (\d+)#\d+: (?:\*\d+ )?(.*)$`)
+
+type AccessLogEntry struct {
+	RemoteAddr string    `json:"remote_addr"`
+	RemoteUser string    `json:"remote_user,omitempty"`
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+type ErrorLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	PID     int       `json:"pid"`
+	Message string    `json:"message"`
+}
+
+// LogLine is one line sent to clients; Access or Error is set when the line
+// could be parsed.
+type LogLine struct {
+	Raw    string          `json:"raw"`
+	Access *AccessLogEntry `json:"access,omitempty"`
+	Error  *ErrorLogEntry  `json:"error,omitempty"`
+}
+
+type SiteLogs struct {
+	Site       string   `json:"site"`
+	AccessLogs []string `json:"access_logs"`
+	ErrorLogs  []string `json:"error_logs"`
+}
+
+type AccessLogStats struct {
+	Path          string         `json:"path"`
+	Window        string         `json:"window"`
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	TotalRequests int            `json:"total_requests"`
+	TotalBytes    int64          `json:"total_bytes"`
+	StatusCodes   map[string]int `json:"status_codes"`
+	StatusClasses map[string]int `json:"status_classes"`
+	TopPaths      []CountEntry   `json:"top_paths"`
+	TopIPs        []CountEntry   `json:"top_ips"`
+	Unparsed      int            `json:"unparsed"`
+}
+
+func parseAccessLogLine(line string) (*AccessLogEntry, bool) {
+	m := combinedLogRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	t, err := time.Parse(nginxAccessTimeFmt, m[3])
+	if err != nil {
+		return nil, false
+	}
+	entry := &AccessLogEntry{RemoteAddr: m[1], Time: t, Referer: m[7], UserAgent: m[8]}
+	if m[2] != "-" {
+		entry.RemoteUser = m[2]
+	}
+	if entry.Referer == "-" {
+		entry.Referer = ""
+	}
+	if entry.UserAgent == "-" {
+		entry.UserAgent = ""
+	}
+	// Malformed requests are logged verbatim, so the request line may not split in three
+	parts := strings.SplitN(m[4], " ", 3)
+	switch len(parts) {
+	case 3:
+		entry.Method, entry.Path, entry.Protocol = parts[0], parts[1], parts[2]
+	case 2:
+		entry.Method, entry.Path = parts[0], parts[1]
+	default:
+		entry.Path = m[4]
+	}
+	entry.Status, _ = strconv.Atoi(m[5])
+	if m[6] != "-" {
+		entry.Bytes, _ = strconv.ParseInt(m[6], 10, 64)
+	}
+	return entry, true
+}
+
+func parseErrorLogLine(line string) (*ErrorLogEntry, bool) {
+	m := errorLogRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	t, err := time.ParseInLocation(nginxErrorTimeFmt, m[1], time.Local)
+	if err != nil {
+		return nil, false
+	}
+	pid, _ := strconv.Atoi(m[3])
+	return &ErrorLogEntry{Time: t, Level: m[2], PID: pid, Message: m[4]}, true
+}
+
+func parseLogLine(line, kind string) LogLine {
+	l := LogLine{Raw: line}
+	if kind == "error" {
+		l.Error, _ = parseErrorLogLine(line)
+	} else {
+		l.Access, _ = parseAccessLogLine(line)
+	}
+	return l
+}
+
+// logFilePaths keeps the file targets of access_log/error_log directives,
+// skipping "off" and syslog destinations.
+func logFilePaths(values []string) []string {
+	var paths []string
+	for _, v := range values {
+		if v == "off" || strings.HasPrefix(v, "syslog:") || strings.Contains(v, "$") || !filepath.IsAbs(v) {
+			continue
+		}
+		paths = append(paths, filepath.Clean(v))
+	}
+	return paths
+}
+
+// siteLogPaths returns the logs a site writes to. Sites without their own
+// log directives use the global ones.
+func siteLogPaths(name string) (SiteLogs, error) {
+	logs := SiteLogs{Site: name, AccessLogs: []string{}, ErrorLogs: []string{}}
+	cfg, err := parseNginxFile(filepath.Join(nginxPath, name))
+	if err != nil {
+		return logs, err
+	}
+	access := logFilePaths(cfg.AccessLogs)
+	errorLogs := logFilePaths(cfg.ErrorLogs)
+	for _, s := range cfg.Servers {
+		access = append(access, logFilePaths(s.AccessLogs)...)
+		errorLogs = append(errorLogs, logFilePaths(s.ErrorLogs)...)
+	}
+	if len(access) == 0 {
+		access = []string{filepath.Join(nginxLogDir, "access.log")}
+	}
+	if len(errorLogs) == 0 {
+		errorLogs = []string{filepath.Join(nginxLogDir, "error.log")}
+	}
+	logs.AccessLogs = uniqueStrings(access)
+	logs.ErrorLogs = uniqueStrings(errorLogs)
+	return logs, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// readLinesBackward calls fn for each complete line of f from the end towards
+// the start of the file, stopping when fn returns false.
+func readLinesBackward(f *os.File, size int64, fn func(line string) bool) error {
+	var carry []byte
+	for offset := size; offset > 0; {
+		n := int64(logReadChunk)
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		chunk := make([]byte, n, n+int64(len(carry)))
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		chunk = append(chunk, carry...)
+
+		for {
+			i := bytes.LastIndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			line := string(bytes.TrimRight(chunk[i+1:], "\r"))
+			chunk = chunk[:i]
+			if line != "" && !fn(line) {
+				return nil
+			}
+		}
+		carry = chunk
+	}
+	if len(carry) > 0 {
+		fn(string(carry))
+	}
+	return nil
+}
+
+// lastLogLines returns up to n lines from the end of the file, oldest first,
+// and the offset just after them.
+func lastLogLines(f *os.File, n int) ([]string, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	// Only complete lines are returned; a partial last line is left to the tailer
+	data := make([]byte, 1)
+	if size > 0 {
+		if _, err := f.ReadAt(data, size-1); err == nil && data[0] != '\n' {
+			buf := make([]byte, min(size, logReadChunk))
+			f.ReadAt(buf, size-int64(len(buf)))
+			if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+				size -= int64(len(buf) - i - 1)
+			} else {
+				size -= int64(len(buf))
+			}
+		}
+	}
+
+	var lines []string
+	if n > 0 {
+		err = readLinesBackward(f, size, func(line string) bool {
+			lines = append(lines, line)
+			return len(lines) < n
+		})
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, size, err
+}
+
+// computeAccessStats aggregates the entries of an access log newer than
+// since. Logs are append-only, so reading stops at the first older entry.
+func computeAccessStats(path string, since time.Time, top int) (*AccessLogStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &AccessLogStats{Path: path, From: since, To: time.Now(), StatusCodes: map[string]int{}, StatusClasses: map[string]int{}}
+	paths := make(map[string]int)
+	ips := make(map[string]int)
+	err = readLinesBackward(f, info.Size(), func(line string) bool {
+		entry, ok := parseAccessLogLine(line)
+		if !ok {
+			stats.Unparsed++
+			return true
+		}
+		if entry.Time.Before(since) {
+			return false
+		}
+		stats.TotalRequests++
+		stats.TotalBytes += entry.Bytes
+		stats.StatusCodes[strconv.Itoa(entry.Status)]++
+		stats.StatusClasses[fmt.Sprintf("%dxx", entry.Status/100)]++
+		p := entry.Path
+		if i := strings.IndexByte(p, '?'); i >= 0 {
+			p = p[:i]
+		}
+		paths[p]++
+		ips[entry.RemoteAddr]++
+		return true
+	})
+	stats.TopPaths = sortedCounts(paths, top)
+	stats.TopIPs = sortedCounts(ips, top)
+	return stats, err
+}
+
+// tailLogFile streams new lines to the connection until the client goes away,
+// reopening the file when it is rotated or truncated.
+func tailLogFile(conn *websocket.Conn, path, kind string, initial int) {
+	f, err := os.Open(path)
+	if err != nil {
+		conn.WriteJSON(gin.H{"error": err.Error()})
+		return
+	}
+	defer func() { f.Close() }()
+
+	lines, offset, err := lastLogLines(f, initial)
+	if err != nil {
+		conn.WriteJSON(gin.H{"error": err.Error()})
+		return
+	}
+	for _, line := range lines {
+		if conn.WriteJSON(parseLogLine(line, kind)) != nil {
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(logTailInterval)
+	defer ticker.Stop()
+	var partial []byte
+	buf := make([]byte, logReadChunk)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := f.ReadAt(buf, offset)
+			if n > 0 {
+				offset += int64(n)
+				partial = append(partial, buf[:n]...)
+				for {
+					i := bytes.IndexByte(partial, '\n')
+					if i < 0 {
+						break
+					}
+					line := string(bytes.TrimRight(partial[:i], "\r"))
+					partial = partial[i+1:]
+					if line == "" {
+						continue
+					}
+					if conn.WriteJSON(parseLogLine(line, kind)) != nil {
+						return
+					}
+				}
+			}
+			if err != nil || n < len(buf) {
+				break
+			}
+		}
+
+		// Rotated (new file at path) or truncated (copytruncate). The old file
+		// was drained above, so start the new one from the beginning.
+		current, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if opened, err := f.Stat(); err != nil || !os.SameFile(current, opened) {
+			if nf, err := os.Open(path); err == nil {
+				f.Close()
+				f = nf
+				offset, partial = 0, nil
+			}
+		} else if current.Size() < offset {
+			offset, partial = 0, nil
+		}
+	}
+}
+
+// resolveSiteLog picks the log to read from the type/path query parameters,
+// only allowing files the site actually logs to.
+func resolveSiteLog(c *gin.Context) (string, string, bool) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return "", "", false
+	}
+	logs, err := siteLogPaths(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found or unreadable: " + err.Error()})
+		return "", "", false
+	}
+
+	kind := c.DefaultQuery("type", "access")
+	var candidates []string
+	switch kind {
+	case "access":
+		candidates = logs.AccessLogs
+	case "error":
+		candidates = logs.ErrorLogs
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be access or error"})
+		return "", "", false
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		return candidates[0], kind, true
+	}
+	for _, candidate := range candidates {
+		if candidate == filepath.Clean(path) {
+			return candidate, kind, true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "path is not a log of this site"})
+	return "", "", false
+}
+
+func parseLinesQuery(c *gin.Context, def int) (int, bool) {
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", strconv.Itoa(def)))
+	if err != nil || lines < 0 || lines > maxLogTailLines {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("lines must be between 0 and %d", maxLogTailLines)})
+		return 0, false
+	}
+	return lines, true
+}
+
+// --- Nginx Log Handlers ---
+
+func getSiteLogs(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+	logs, err := siteLogPaths(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found or unreadable: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
+
+func getSiteLogEntries(c *gin.Context) {
+	path, kind, ok := resolveSiteLog(c)
+	if !ok {
+		return
+	}
+	n, ok := parseLinesQuery(c, 200)
+	if !ok {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	lines, _, err := lastLogLines(f, n)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries := make([]LogLine, 0, len(lines))
+	for _, line := range lines {
+		entries = append(entries, parseLogLine(line, kind))
+	}
+	c.JSON(http.StatusOK, gin.H{"path": path, "type": kind, "entries": entries})
+}
+
+func tailSiteLog(c *gin.Context) {
+	path, kind, ok := resolveSiteLog(c)
+	if !ok {
+		return
+	}
+	n, ok := parseLinesQuery(c, 50)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tailLogFile(conn, path, kind, n)
+}
+
+func getSiteLogStats(c *gin.Context) {
+	path, kind, ok := resolveSiteLog(c)
+	if !ok {
+		return
+	}
+	if kind != "access" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stats are only available for access logs"})
+		return
+	}
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+	if err != nil || window <= 0 || window > maxLogStatsWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a duration between 1s and 168h"})
+		return
+	}
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil || top < 1 || top > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "top must be between 1 and 100"})
+		return
+	}
+
+	stats, err := computeAccessStats(path, time.Now().Add(-window), top)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats.Window = window.String()
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	stdnet "net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"system-manager/nginxconf"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// --- Nginx Structured Site Model ---
+
+const nginxConfDPath = "/etc/nginx/conf.d/"
+
+type NginxSite struct {
+	File       string               `json:"file"`
+	Enabled    bool                 `json:"enabled"`
+	ParseError string               `json:"parse_error,omitempty"`
+	Servers    []nginxconf.Server   `json:"servers"`
+	Upstreams  []nginxconf.Upstream `json:"upstreams"`
+	Backends   []NginxBackend       `json:"backends"`
+}
+
+// NginxBackend is one address a location forwards requests to, cross-checked
+// against the sockets currently listening on this host.
+type NginxBackend struct {
+	ServerName string `json:"server_name"`
+	Location   string `json:"location"`
+	Directive  string `json:"directive"` // proxy_pass or fastcgi_pass
+	Target     string `json:"target"`    // Raw directive value
+	Upstream   string `json:"upstream,omitempty"`
+	Address    string `json:"address"`
+	Status     string `json:"status"` // "up", "down", "remote", "unknown"
+	PID        int32  `json:"pid,omitempty"`
+	Process    string `json:"process,omitempty"`
+}
+
+type listeningSocket struct {
+	IP   string
+	PID  int32
+	Name string
+}
+
+// tcpListeners maps each listening TCP port to the sockets bound to it.
+func tcpListeners() map[int][]listeningSocket {
+	listeners := make(map[int][]listeningSocket)
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return listeners
+	}
+	names := processNameCache{}
+	for _, conn := range conns {
+		if conn.Status != "LISTEN" {
+			continue
+		}
+		port := int(conn.Laddr.Port)
+		listeners[port] = append(listeners[port], listeningSocket{IP: conn.Laddr.IP, PID: conn.Pid, Name: names.lookup(conn.Pid)})
+	}
+	return listeners
+}
+
+func localAddresses() map[string]bool {
+	local := map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true, "0.0.0.0": true, "::": true}
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		for _, addr := range iface.Addrs {
+			local[strings.SplitN(addr.Addr, "/", 2)[0]] = true
+		}
+	}
+	return local
+}
+
+func isWildcardIP(ip string) bool {
+	return ip == "0.0.0.0" || ip == "::" || ip == ""
+}
+
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "::1" || strings.HasPrefix(host, "127.")
+}
+
+// checkBackend fills in Status/PID/Process for a resolved target.
+func checkBackend(b *NginxBackend, target nginxconf.Target, listeners map[int][]listeningSocket, local map[string]bool) {
+	if target.Socket != "" {
+		b.Address = "unix:" + target.Socket
+		if info, err := os.Stat(target.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			b.Status = "up"
+		} else {
+			b.Status = "down"
+		}
+		return
+	}
+
+	b.Address = target.Host
+	if target.Port != 0 {
+		b.Address = stdnet.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	}
+	if !local[target.Host] {
+		b.Status = "remote"
+		return
+	}
+	b.Status = "down"
+	for _, l := range listeners[target.Port] {
+		if isWildcardIP(l.IP) || l.IP == target.Host || (isLoopbackHost(target.Host) && isLoopbackHost(l.IP)) {
+			b.Status = "up"
+			b.PID = l.PID
+			b.Process = l.Name
+			return
+		}
+	}
+}
+
+func parseNginxFile(path string) (nginxconf.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nginxconf.Config{}, err
+	}
+	directives, err := nginxconf.Parse(string(data))
+	if err != nil {
+		return nginxconf.Config{}, err
+	}
+	return nginxconf.Extract(directives), nil
+}
+
+// loadNginxSites parses every file in sites-available. Upstreams are resolved
+// across all sites and conf.d since nginx shares them at http level.
+func loadNginxSites() ([]NginxSite, error) {
+	states, err := listNginxSiteStates()
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := make(map[string]nginxconf.Upstream)
+	if confFiles, err := filepath.Glob(filepath.Join(nginxConfDPath, "*.conf")); err == nil {
+		for _, f := range confFiles {
+			if cfg, err := parseNginxFile(f); err == nil {
+				for _, u := range cfg.Upstreams {
+					upstreams[u.Name] = u
+				}
+			}
+		}
+	}
+
+	var sites []NginxSite
+	for _, state := range states {
+		if !state.Available {
+			continue
+		}
+		site := NginxSite{File: state.Name, Enabled: state.Enabled, Servers: []nginxconf.Server{}, Upstreams: []nginxconf.Upstream{}, Backends: []NginxBackend{}}
+		cfg, err := parseNginxFile(filepath.Join(nginxPath, state.Name))
+		if err != nil {
+			site.ParseError = err.Error()
+		} else {
+			site.Servers = cfg.Servers
+			site.Upstreams = cfg.Upstreams
+			for _, u := range cfg.Upstreams {
+				upstreams[u.Name] = u
+			}
+		}
+		sites = append(sites, site)
+	}
+
+	listeners := tcpListeners()
+	local := localAddresses()
+	for i := range sites {
+		sites[i].Backends = resolveBackends(sites[i].Servers, upstreams, listeners, local)
+	}
+	return sites, nil
+}
+
+func resolveBackends(servers []nginxconf.Server, upstreams map[string]nginxconf.Upstream, listeners map[int][]listeningSocket, local map[string]bool) []NginxBackend {
+	backends := []NginxBackend{}
+	for _, server := range servers {
+		serverName := "_"
+		if len(server.ServerNames) > 0 {
+			serverName = server.ServerNames[0]
+		}
+		for _, loc := range server.Locations {
+			for _, pass := range [][2]string{{"proxy_pass", loc.ProxyPass}, {"fastcgi_pass", loc.FastCGIPass}} {
+				directive, value := pass[0], pass[1]
+				if value == "" {
+					continue
+				}
+				base := NginxBackend{ServerName: serverName, Location: loc.Path, Directive: directive, Target: value}
+				target, ok := nginxconf.ParseTarget(value)
+				if !ok {
+					base.Status = "unknown"
+					backends = append(backends, base)
+					continue
+				}
+				if upstream, found := upstreams[target.Host]; found && target.Socket == "" {
+					for _, member := range upstream.Servers {
+						b := base
+						b.Upstream = upstream.Name
+						checkBackend(&b, nginxconf.ParseAddress(member.Address, 80), listeners, local)
+						backends = append(backends, b)
+					}
+					continue
+				}
+				checkBackend(&base, target, listeners, local)
+				backends = append(backends, base)
+			}
+		}
+	}
+	sort.SliceStable(backends, func(i, j int) bool {
+		if backends[i].ServerName != backends[j].ServerName {
+			return backends[i].ServerName < backends[j].ServerName
+		}
+		return backends[i].Location < backends[j].Location
+	})
+	return backends
+}
+
+// --- Nginx Site Model Handler ---
+
+func getNginxSites(c *gin.Context) {
+	sites, err := loadNginxSites()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sites == nil {
+		sites = []NginxSite{}
+	}
+	c.JSON(http.StatusOK, sites)
+}
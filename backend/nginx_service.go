@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// --- Nginx Service Control ---
+
+const (
+	nginxServiceName      = "nginx"
+	nginxModulesEnabled   = "/etc/nginx/modules-enabled"
+	defaultStubStatusURL  = "http://127.0.0.1/nginx_status"
+	stubStatusTimeout     = 2 * time.Second
+	nginxJournalLines     = 20
+	nginxRestartSettleDur = 2 * time.Second
+)
+
+type NginxStubStatus struct {
+	Active   int64 `json:"active"`
+	Accepts  int64 `json:"accepts"`
+	Handled  int64 `json:"handled"`
+	Requests int64 `json:"requests"`
+	Reading  int64 `json:"reading"`
+	Writing  int64 `json:"writing"`
+	Waiting  int64 `json:"waiting"`
+}
+
+type NginxWorker struct {
+	PID  int32  `json:"pid"`
+	Role string `json:"role"` // "worker process", "cache manager process", ...
+}
+
+type NginxStatus struct {
+	ActiveState     string           `json:"active_state"`
+	SubState        string           `json:"sub_state"`
+	Since           string           `json:"since,omitempty"`
+	MasterPID       int32            `json:"master_pid"`
+	Version         string           `json:"version"`
+	ConfigureArgs   []string         `json:"configure_args,omitempty"`
+	Modules         []string         `json:"modules"`
+	DynamicModules  []string         `json:"dynamic_modules"`
+	WorkerCount     int              `json:"worker_count"`
+	Workers         []NginxWorker    `json:"workers"`
+	StubStatus      *NginxStubStatus `json:"stub_status,omitempty"`
+	StubStatusError string           `json:"stub_status_error,omitempty"`
+	ConfigOK        bool             `json:"config_ok"`
+	ConfigTest      string           `json:"config_test"`
+}
+
+var (
+	nginxVersionRegex = regexp.MustCompile(`nginx version: nginx/(\S+)`)
+	loadModuleRegex   = regexp.MustCompile(`load_module\s+"?([^";\s]+)"?\s*;`)
+	stubNumbersRegex  = regexp.MustCompile(`\d+`)
+)
+
+func nginxTest() (string, error) {
+	output, err := exec.Command("nginx", "-t").CombinedOutput()
+	if err != nil && len(output) == 0 {
+		output = []byte(err.Error())
+	}
+	return string(output), err
+}
+
+func nginxReload() error {
+	output, err := exec.Command("systemctl", "reload", nginxServiceName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %v: %s", errNginxReload, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// nginxJournal returns the last service log lines, which usually explain why
+// a reload or restart failed.
+func nginxJournal() string {
+	out, err := exec.Command("journalctl", "-u", nginxServiceName, "-n", strconv.Itoa(nginxJournalLines), "--no-pager").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// systemdProperties reads unit properties with `systemctl show`.
+func systemdProperties(unit string, props ...string) map[string]string {
+	values := make(map[string]string)
+	out, err := exec.Command("systemctl", "show", unit, "--property="+strings.Join(props, ",")).Output()
+	if err != nil {
+		return values
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// parseNginxBuildInfo extracts the version and statically compiled modules
+// from `nginx -V`, which prints to stderr.
+func parseNginxBuildInfo(output string) (version string, args, modules []string) {
+	if m := nginxVersionRegex.FindStringSubmatch(output); m != nil {
+		version = m[1]
+	}
+	modules = []string{}
+	for _, line := range strings.Split(output, "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), "configure arguments:")
+		if !ok {
+			continue
+		}
+		args = strings.Fields(rest)
+		for _, arg := range args {
+			switch {
+			case strings.HasPrefix(arg, "--with-") && strings.HasSuffix(arg, "_module"):
+				modules = append(modules, strings.TrimPrefix(arg, "--with-"))
+			case strings.HasPrefix(arg, "--add-module="):
+				modules = append(modules, filepath.Base(strings.TrimPrefix(arg, "--add-module=")))
+			}
+		}
+	}
+	return version, args, modules
+}
+
+// nginxDynamicModules lists modules loaded through modules-enabled.
+func nginxDynamicModules() []string {
+	modules := []string{}
+	files, _ := filepath.Glob(filepath.Join(nginxModulesEnabled, "*.conf"))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		for _, m := range loadModuleRegex.FindAllStringSubmatch(string(data), -1) {
+			modules = append(modules, strings.TrimSuffix(filepath.Base(m[1]), ".so"))
+		}
+	}
+	return modules
+}
+
+func nginxWorkers(master int32) []NginxWorker {
+	workers := []NginxWorker{}
+	if master <= 0 {
+		return workers
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		return workers
+	}
+	for _, p := range procs {
+		ppid, err := p.Ppid()
+		if err != nil || ppid != master {
+			continue
+		}
+		cmdline, _ := p.Cmdline()
+		role := strings.TrimSpace(strings.TrimPrefix(cmdline, "nginx:"))
+		workers = append(workers, NginxWorker{PID: p.Pid, Role: role})
+	}
+	return workers
+}
+
+// parseStubStatus reads the stub_status page:
+//
+//	Active connections: 2
+//	server accepts handled requests
+//	 10 10 20
+//	Reading: 0 Writing: 1 Waiting: 1
+func parseStubStatus(body string) (*NginxStubStatus, error) {
+	nums := stubNumbersRegex.FindAllString(body, -1)
+	if len(nums) != 7 || !strings.HasPrefix(body, "Active connections:") {
+		return nil, fmt.Errorf("unexpected stub_status response")
+	}
+	values := make([]int64, len(nums))
+	for i, n := range nums {
+		values[i], _ = strconv.ParseInt(n, 10, 64)
+	}
+	return &NginxStubStatus{
+		Active: values[0], Accepts: values[1], Handled: values[2], Requests: values[3],
+		Reading: values[4], Writing: values[5], Waiting: values[6],
+	}, nil
+}
+
+// fetchStubStatus queries NGINX_STATUS_URL (default http://127.0.0.1/nginx_status).
+// Setting it to "off" skips the check.
+func fetchStubStatus() (*NginxStubStatus, error) {
+	url := os.Getenv("NGINX_STATUS_URL")
+	if url == "" {
+		url = defaultStubStatusURL
+	}
+	if url == "off" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stubStatusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stub_status returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return nil, err
+	}
+	return parseStubStatus(string(body))
+}
+
+func collectNginxStatus() NginxStatus {
+	props := systemdProperties(nginxServiceName, "ActiveState", "SubState", "MainPID", "ActiveEnterTimestamp")
+	status := NginxStatus{
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+		Since:       props["ActiveEnterTimestamp"],
+	}
+	if status.ActiveState == "" {
+		status.ActiveState = "unknown"
+	}
+	if pid, err := strconv.Atoi(props["MainPID"]); err == nil {
+		status.MasterPID = int32(pid)
+	}
+
+	out, _ := exec.Command("nginx", "-V").CombinedOutput()
+	status.Version, status.ConfigureArgs, status.Modules = parseNginxBuildInfo(string(out))
+	status.DynamicModules = nginxDynamicModules()
+
+	status.Workers = nginxWorkers(status.MasterPID)
+	for _, w := range status.Workers {
+		if w.Role == "worker process" {
+			status.WorkerCount++
+		}
+	}
+
+	if status.ActiveState == "active" {
+		stub, err := fetchStubStatus()
+		if err != nil {
+			status.StubStatusError = err.Error()
+		}
+		status.StubStatus = stub
+	}
+
+	testOutput, err := nginxTest()
+	status.ConfigOK = err == nil
+	status.ConfigTest = testOutput
+	return status
+}
+
+// --- Nginx Service Handlers ---
+
+func getNginxStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, collectNginxStatus())
+}
+
+func testNginxConfig(c *gin.Context) {
+	output, err := nginxTest()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"ok": false, "output": output})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "output": output})
+}
+
+// controlNginx runs `systemctl <action> nginx` after checking the config, so
+// a broken config never takes the server down.
+func controlNginx(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unlock, err := lockNginxConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock nginx config: " + err.Error()})
+			return
+		}
+		defer unlock()
+
+		if output, err := nginxTest(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nginx validation failed, " + action + " aborted", "details": output})
+			return
+		}
+
+		out, err := exec.Command("systemctl", action, nginxServiceName).CombinedOutput()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   fmt.Sprintf("nginx %s failed: %v", action, err),
+				"details": strings.TrimSpace(string(out)),
+				"journal": nginxJournal(),
+			})
+			return
+		}
+
+		if action == "restart" {
+			// systemctl returns before a crashing master exits
+			time.Sleep(nginxRestartSettleDur)
+		}
+		props := systemdProperties(nginxServiceName, "ActiveState")
+		if state := props["ActiveState"]; state != "" && state != "active" {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   fmt.Sprintf("nginx is %s after %s", state, action),
+				"journal": nginxJournal(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "nginx " + action + " completed"})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Nginx Site Management (sites-enabled) ---
+
+const nginxEnabledPath = "/etc/nginx/sites-enabled/"
+
+type NginxSiteState struct {
+	Name       string `json:"name"`
+	Available  bool   `json:"available"`   // Config exists in sites-available
+	Enabled    bool   `json:"enabled"`     // Present in sites-enabled
+	Managed    bool   `json:"managed"`     // sites-enabled entry is a symlink to sites-available
+	BrokenLink bool   `json:"broken_link"` // Symlink whose target is missing
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+func listNginxSiteStates() ([]NginxSiteState, error) {
+	states := make(map[string]*NginxSiteState)
+
+	available, err := os.ReadDir(nginxPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range available {
+		if f.IsDir() || !validNginxFileName(f.Name()) {
+			continue
+		}
+		states[f.Name()] = &NginxSiteState{Name: f.Name(), Available: true}
+	}
+
+	enabled, err := os.ReadDir(nginxEnabledPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range enabled {
+		if f.IsDir() || !validNginxFileName(f.Name()) {
+			continue
+		}
+		state, ok := states[f.Name()]
+		if !ok {
+			state = &NginxSiteState{Name: f.Name()}
+			states[f.Name()] = state
+		}
+		state.Enabled = true
+		linkPath := filepath.Join(nginxEnabledPath, f.Name())
+		if target, err := os.Readlink(linkPath); err == nil {
+			state.LinkTarget = target
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(nginxEnabledPath, target)
+			}
+			state.Managed = filepath.Clean(target) == filepath.Join(nginxPath, f.Name())
+			if _, err := os.Stat(linkPath); err != nil {
+				state.BrokenLink = true
+			}
+		}
+	}
+
+	result := make([]NginxSiteState, 0, len(states))
+	for _, state := range states {
+		result = append(result, *state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// setNginxSiteEnabled creates or removes the sites-enabled symlink. The change
+// is validated on a staged copy before it reaches the live tree.
+func setNginxSiteEnabled(name string, enable bool) (string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	availablePath := filepath.Join(nginxPath, name)
+	enabledPath := filepath.Join(nginxEnabledPath, name)
+
+	_, statErr := os.Lstat(enabledPath)
+	isEnabled := statErr == nil
+	if isEnabled == enable {
+		return "", nil
+	}
+
+	change := nginxChange{Path: enabledPath, Remove: true}
+	if enable {
+		if _, err := os.Stat(availablePath); err != nil {
+			return "", fmt.Errorf("site %s does not exist", name)
+		}
+		change = nginxChange{Path: enabledPath, Symlink: availablePath}
+	} else if _, err := os.Readlink(enabledPath); err != nil {
+		return "", fmt.Errorf("%s is not a symlink, refusing to remove it", enabledPath)
+	}
+	return applyNginxChangesLocked([]nginxChange{change})
+}
+
+// deleteNginxSite removes the symlink and config of a site, keeping the content
+// in the revision history so it can be restored later.
+func deleteNginxSite(name, author string) (string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	availablePath := filepath.Join(nginxPath, name)
+	enabledPath := filepath.Join(nginxEnabledPath, name)
+
+	content, err := os.ReadFile(availablePath)
+	if err != nil {
+		return "", fmt.Errorf("site %s does not exist", name)
+	}
+	changes := []nginxChange{{Path: availablePath, Remove: true}}
+	// A regular file in sites-enabled would keep serving the site after the
+	// config is gone, and its content is not ours to discard.
+	if info, err := os.Lstat(enabledPath); err == nil {
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return "", fmt.Errorf("%s is not a symlink, refusing to delete the site", enabledPath)
+		}
+		changes = append([]nginxChange{{Path: enabledPath, Remove: true}}, changes...)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	changes = append(changes, removeHardeningChanges(name)...)
+
+	output, err := applyNginxChangesLocked(changes)
+	if errors.Is(err, errNginxValidation) {
+		return output, err
+	}
+	os.Remove(hardeningSettingsPath(name))
+	// The files are gone even if the reload failed, so always keep the history
+	if _, histErr := recordNginxRevision(name, content, content, author, "Site deleted", "delete"); histErr != nil {
+		fmt.Printf("Nginx: failed to record deletion of %s: %v\n", name, histErr)
+	}
+	return output, err
+}
+
+// --- Nginx Site Handlers ---
+
+func getNginxSiteStates(c *gin.Context) {
+	states, err := listNginxSiteStates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, states)
+}
+
+func toggleNginxSite(enable bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+		if !validNginxFileName(req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+			return
+		}
+
+		output, err := setNginxSiteEnabled(req.Name, enable)
+		if err != nil {
+			if errors.Is(err, errNginxValidation) {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": err.Error(), "details": output})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		message := "Site disabled"
+		if enable {
+			message = "Site enabled"
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": message})
+	}
+}
+
+func deleteNginxSiteHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !validNginxFileName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+
+	output, err := deleteNginxSite(name, requestUser(c))
+	if err != nil {
+		if errors.Is(err, errNginxValidation) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": err.Error(), "details": output})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"status": "success", "message": "Site deleted"}
+	if c.Query("delete_cert") == "true" {
+		certName := c.DefaultQuery("cert_name", name)
+		if !domainRegex.MatchString(certName) {
+			c.JSON(http.StatusOK, gin.H{"status": "warning", "message": "Site deleted but certificate name is invalid"})
+			return
+		}
+		out, err := exec.Command("certbot", "delete", "--cert-name", certName, "--non-interactive").CombinedOutput()
+		response["cert_output"] = string(out)
+		if err != nil {
+			response["status"] = "warning"
+			response["message"] = "Site deleted but certificate removal failed"
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// --- Staged Nginx Changes ---
+//
+// Config changes are never written to the live tree before they are validated.
+// Instead the whole /etc/nginx tree is copied to a temporary directory (with
+// absolute /etc/nginx references rewritten to the copy), the change is applied
+// there and checked with `nginx -t -c <copy>/nginx.conf`. Only then is each file
+// written next to its destination and renamed into place.
+
+const (
+	nginxRootPath      = "/etc/nginx"
+	maxStagedFileBytes = 4 << 20
+)
+
+// nginxConfigMu serializes every change to the nginx config tree so that a
+// validation run never sees another request's half-applied change.
+var nginxConfigMu sync.Mutex
+
+var (
+	errNginxValidation = errors.New("nginx validation failed")
+	errNginxConflict   = errors.New("file was modified by someone else")
+	errNginxReload     = errors.New("nginx reload failed")
+)
+
+// nginxChange describes one modification of the live config tree. Exactly one
+// of Content, Symlink or Remove is used.
+type nginxChange struct {
+	Path    string // Absolute path under nginxRootPath
+	Content []byte
+	Mode    os.FileMode // Defaults to 0644
+	GID     int         // Group owner of Content, 0 keeps the default
+	Symlink string      // Create Path as a symlink to this target
+	Remove  bool
+}
+
+// lockNginxConfig takes the in-process mutex and an exclusive flock shared with
+// any other panel instance. The returned function releases both.
+func lockNginxConfig() (func(), error) {
+	nginxConfigMu.Lock()
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		nginxConfigMu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir, "nginx.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		nginxConfigMu.Unlock()
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		nginxConfigMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+		nginxConfigMu.Unlock()
+	}, nil
+}
+
+// applyNginxChanges validates and applies a set of changes while holding the
+// config lock.
+func applyNginxChanges(changes []nginxChange) (string, error) {
+	unlock, err := lockNginxConfig()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return applyNginxChangesLocked(changes)
+}
+
+// applyNginxChangesLocked stages, validates, commits and reloads. On validation
+// failure the live tree is untouched and the nginx -t output is returned with
+// errNginxValidation. The caller must hold the config lock.
+func applyNginxChangesLocked(changes []nginxChange) (string, error) {
+	for _, ch := range changes {
+		if !strings.HasPrefix(filepath.Clean(ch.Path), nginxRootPath+"/") {
+			return "", fmt.Errorf("refusing to modify %s outside %s", ch.Path, nginxRootPath)
+		}
+	}
+
+	if output, err := validateStagedNginx(changes); err != nil {
+		return output, err
+	}
+	if err := commitNginxChanges(changes); err != nil {
+		return "", err
+	}
+	return "", nginxReload()
+}
+
+func validateStagedNginx(changes []nginxChange) (string, error) {
+	stageDir, err := os.MkdirTemp("", "nginx-stage-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(stageDir)
+
+	if err := copyNginxTree(nginxRootPath, stageDir); err != nil {
+		return "", fmt.Errorf("failed to stage config: %w", err)
+	}
+	for _, ch := range changes {
+		staged := filepath.Join(stageDir, strings.TrimPrefix(filepath.Clean(ch.Path), nginxRootPath))
+		if err := applyNginxChange(staged, stagedNginxChange(ch, stageDir)); err != nil {
+			return "", fmt.Errorf("failed to stage %s: %w", ch.Path, err)
+		}
+	}
+
+	output, err := exec.Command("nginx", "-t", "-c", filepath.Join(stageDir, "nginx.conf")).CombinedOutput()
+	if err != nil {
+		if len(output) == 0 {
+			output = []byte(err.Error())
+		}
+		// Report paths as the user knows them
+		return strings.ReplaceAll(string(output), stageDir, nginxRootPath), errNginxValidation
+	}
+	return "", nil
+}
+
+// stagedNginxChange rewrites references to the live tree so the staged copy is self-contained.
+func stagedNginxChange(ch nginxChange, stageDir string) nginxChange {
+	if ch.Content != nil {
+		ch.Content = bytes.ReplaceAll(ch.Content, []byte(nginxRootPath+"/"), []byte(stageDir+"/"))
+	}
+	if strings.HasPrefix(ch.Symlink, nginxRootPath+"/") {
+		ch.Symlink = stageDir + strings.TrimPrefix(ch.Symlink, nginxRootPath)
+	}
+	return ch
+}
+
+func copyNginxTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(link, src+"/") {
+				link = dst + strings.TrimPrefix(link, src)
+			}
+			return os.Symlink(link, target)
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if info.Size() <= maxStagedFileBytes {
+				data = bytes.ReplaceAll(data, []byte(src+"/"), []byte(dst+"/"))
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// applyNginxChange performs a single change atomically: content and symlinks
+// are created under a temporary name in the same directory and renamed over
+// the destination.
+func applyNginxChange(path string, ch nginxChange) error {
+	if ch.Remove {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp-%d", filepath.Base(path), os.Getpid()))
+	os.Remove(tmpPath)
+
+	if ch.Symlink != "" {
+		if err := os.Symlink(ch.Symlink, tmpPath); err != nil {
+			return err
+		}
+	} else {
+		mode := ch.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(ch.Content); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		if ch.GID != 0 {
+			if err := os.Chown(tmpPath, -1, ch.GID); err != nil {
+				os.Remove(tmpPath)
+				return err
+			}
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// snapshotNginxPath captures the current state of path as a change that restores it.
+func snapshotNginxPath(path string) (nginxChange, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nginxChange{Path: path, Remove: true}, nil
+	}
+	if err != nil {
+		return nginxChange{}, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		return nginxChange{Path: path, Symlink: link}, err
+	}
+	data, err := os.ReadFile(path)
+	change := nginxChange{Path: path, Content: data, Mode: info.Mode().Perm()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		change.GID = int(st.Gid)
+	}
+	return change, err
+}
+
+// commitNginxChanges applies validated changes to the live tree, restoring the
+// already applied ones if a later change fails.
+func commitNginxChanges(changes []nginxChange) error {
+	var undo []nginxChange
+	for _, ch := range changes {
+		previous, err := snapshotNginxPath(ch.Path)
+		if err != nil {
+			return err
+		}
+		if err := applyNginxChange(ch.Path, ch); err != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				applyNginxChange(undo[i].Path, undo[i])
+			}
+			return fmt.Errorf("failed to write %s: %w", ch.Path, err)
+		}
+		undo = append(undo, previous)
+	}
+	return nil
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Site Templates ---
+//
+// createSite renders its config from a registry of templates. Each template
+// decodes CreateSiteRequest.Params into its own parameter struct, validates it
+// and renders a server block from it. New site types only need to call
+// registerSiteTemplate from an init function.
+
+type siteTemplateParams interface {
+	// Validate checks the parameters and fills in defaults. req is passed so
+	// legacy fields (Target) and the domain can be taken into account.
+	Validate(req *CreateSiteRequest) error
+}
+
+type SiteTemplateField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+type siteTemplate struct {
+	Name        string
+	Description string
+	Fields      []SiteTemplateField
+	NewParams   func() siteTemplateParams
+	Template    *template.Template
+}
+
+type siteTemplateData struct {
+	Domain  string
+	Include string // Hardening snippet, if any
+	Params  siteTemplateParams
+}
+
+var siteTemplates = map[string]*siteTemplate{}
+
+func registerSiteTemplate(t *siteTemplate) {
+	if _, exists := siteTemplates[t.Name]; exists {
+		panic("duplicate site template " + t.Name)
+	}
+	siteTemplates[t.Name] = t
+}
+
+// unsafeConfigChars would let a value escape its directive.
+var unsafeConfigChars = regexp.MustCompile(`[\s;{}"'$\\#]`)
+
+func validateConfigValue(field, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if unsafeConfigChars.MatchString(value) || strings.Contains(value, "..") {
+		return fmt.Errorf("%s contains invalid characters", field)
+	}
+	return nil
+}
+
+func validateRootPath(field, value string) error {
+	if err := validateConfigValue(field, value); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("%s must be an absolute path", field)
+	}
+	return nil
+}
+
+func validatePort(field string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535", field)
+	}
+	return nil
+}
+
+// renderSiteConfig builds the nginx config for a create-site request.
+func renderSiteConfig(req *CreateSiteRequest) ([]byte, error) {
+	tmpl, ok := siteTemplates[req.Type]
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q", req.Type)
+	}
+	params := tmpl.NewParams()
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		dec := json.NewDecoder(bytes.NewReader(req.Params))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(params); err != nil {
+			return nil, fmt.Errorf("invalid params for %s: %v", req.Type, err)
+		}
+	}
+	if err := params.Validate(req); err != nil {
+		return nil, err
+	}
+
+	data := siteTemplateData{Domain: req.Domain, Params: params}
+	if req.Hardening != nil {
+		data.Include = hardeningSnippetPath(req.Domain)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("template execution error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// --- proxy ---
+
+type proxySiteParams struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	WebSocket *bool  `json:"websocket"`
+
+	UseWebSocket bool `json:"-"` // WebSocket resolved by Validate
+}
+
+func (p *proxySiteParams) Validate(req *CreateSiteRequest) error {
+	if p.Port == 0 && req.Target != "" {
+		port, err := strconv.Atoi(req.Target)
+		if err != nil {
+			return errors.New("Invalid target")
+		}
+		p.Port = port
+	}
+	if p.Host == "" {
+		p.Host = "127.0.0.1"
+	}
+	p.UseWebSocket = p.WebSocket == nil || *p.WebSocket
+	if err := validateConfigValue("host", p.Host); err != nil {
+		return err
+	}
+	return validatePort("port", p.Port)
+}
+
+// --- static ---
+
+type staticSiteParams struct {
+	Root string `json:"root"`
+}
+
+func (p *staticSiteParams) Validate(req *CreateSiteRequest) error {
+	if p.Root == "" {
+		p.Root = req.Target
+	}
+	return validateRootPath("root", p.Root)
+}
+
+// --- php ---
+
+const phpSocketDir = "/run/php"
+
+var phpVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+type phpSiteParams struct {
+	Root       string `json:"root"`
+	Socket     string `json:"socket"`
+	PHPVersion string `json:"php_version"`
+	Index      string `json:"index"`
+}
+
+// phpFPMSockets lists the PHP-FPM sockets available on this host.
+func phpFPMSockets() []string {
+	sockets, _ := filepath.Glob(filepath.Join(phpSocketDir, "*.sock"))
+	sort.Strings(sockets)
+	return sockets
+}
+
+func (p *phpSiteParams) Validate(req *CreateSiteRequest) error {
+	if p.Root == "" {
+		p.Root = req.Target
+	}
+	if err := validateRootPath("root", p.Root); err != nil {
+		return err
+	}
+	if p.Index == "" {
+		p.Index = "index.php"
+	}
+	if err := validateConfigValue("index", p.Index); err != nil {
+		return err
+	}
+
+	switch {
+	case p.Socket != "":
+	case p.PHPVersion != "":
+		if !phpVersionRegex.MatchString(p.PHPVersion) {
+			return errors.New("php_version must look like 8.2")
+		}
+		p.Socket = filepath.Join(phpSocketDir, "php"+p.PHPVersion+"-fpm.sock")
+	default:
+		// Pick the newest installed version
+		sockets := phpFPMSockets()
+		if len(sockets) == 0 {
+			return errors.New("no PHP-FPM socket found, set socket or php_version")
+		}
+		p.Socket = sockets[len(sockets)-1]
+	}
+	if err := validateRootPath("socket", p.Socket); err != nil {
+		return err
+	}
+	return nil
+}
+
+// --- spa ---
+
+type spaSiteParams struct {
+	Root        string `json:"root"`
+	Index       string `json:"index"`
+	CacheAssets *bool  `json:"cache_assets"`
+
+	UseCacheAssets bool `json:"-"` // CacheAssets resolved by Validate
+}
+
+func (p *spaSiteParams) Validate(req *CreateSiteRequest) error {
+	if p.Root == "" {
+		p.Root = req.Target
+	}
+	if err := validateRootPath("root", p.Root); err != nil {
+		return err
+	}
+	if p.Index == "" {
+		p.Index = "index.html"
+	}
+	p.UseCacheAssets = p.CacheAssets == nil || *p.CacheAssets
+	return validateConfigValue("index", p.Index)
+}
+
+// --- redirect ---
+
+type redirectSiteParams struct {
+	To           string `json:"to"`            // Destination URL (scheme + host, optional path)
+	Code         int    `json:"code"`          // 301 (default), 302, 307 or 308
+	PreservePath *bool  `json:"preserve_path"` // Append $request_uri (default true)
+	Canonical    string `json:"canonical"`     // "www" or "non-www": redirect between the bare and www host
+	Scheme       string `json:"scheme"`        // Scheme used with canonical (default https)
+
+	UsePreservePath bool `json:"-"` // PreservePath resolved by Validate
+}
+
+func (p *redirectSiteParams) Validate(req *CreateSiteRequest) error {
+	if p.Code == 0 {
+		p.Code = http.StatusMovedPermanently
+	}
+	switch p.Code {
+	case 301, 302, 307, 308:
+	default:
+		return errors.New("code must be 301, 302, 307 or 308")
+	}
+	p.UsePreservePath = p.PreservePath == nil || *p.PreservePath
+	if p.Scheme == "" {
+		p.Scheme = "https"
+	}
+	if p.Scheme != "http" && p.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+
+	if p.To == "" {
+		p.To = req.Target
+	}
+	switch p.Canonical {
+	case "":
+	case "www":
+		if strings.HasPrefix(req.Domain, "www.") {
+			return errors.New("canonical www redirect must be created for the bare domain")
+		}
+		p.To = p.Scheme + "://www." + req.Domain
+	case "non-www":
+		if !strings.HasPrefix(req.Domain, "www.") {
+			return errors.New("canonical non-www redirect must be created for the www domain")
+		}
+		p.To = p.Scheme + "://" + strings.TrimPrefix(req.Domain, "www.")
+	default:
+		return errors.New("canonical must be www or non-www")
+	}
+
+	u, err := url.Parse(p.To)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("to must be an absolute http(s) URL")
+	}
+	if err := validateConfigValue("to", p.To); err != nil {
+		return err
+	}
+	if p.UsePreservePath {
+		p.To = strings.TrimSuffix(p.To, "/")
+	}
+	return nil
+}
+
+// --- upstream (load balanced) ---
+
+type upstreamBackend struct {
+	Address     string `json:"address"` // host:port or unix:/path
+	Weight      int    `json:"weight"`
+	MaxFails    *int   `json:"max_fails"`
+	FailTimeout string `json:"fail_timeout"` // e.g. "10s"
+	Backup      bool   `json:"backup"`
+}
+
+type upstreamSiteParams struct {
+	Backends  []upstreamBackend `json:"backends"`
+	Method    string            `json:"method"` // round_robin (default), least_conn, ip_hash, random
+	Keepalive int               `json:"keepalive"`
+	WebSocket *bool             `json:"websocket"`
+	Name      string            `json:"-"`
+
+	UseWebSocket bool `json:"-"` // WebSocket resolved by Validate
+}
+
+var nginxDurationRegex = regexp.MustCompile(`^[0-9]+(ms|s|m|h)?$`)
+
+func (p *upstreamSiteParams) Validate(req *CreateSiteRequest) error {
+	if len(p.Backends) == 0 {
+		return errors.New("at least one backend is required")
+	}
+	switch p.Method {
+	case "":
+		p.Method = "round_robin"
+	case "round_robin", "least_conn", "ip_hash", "random":
+	default:
+		return errors.New("method must be round_robin, least_conn, ip_hash or random")
+	}
+	if p.Method == "ip_hash" {
+		for _, b := range p.Backends {
+			if b.Backup {
+				return errors.New("backup servers cannot be used with ip_hash")
+			}
+		}
+	}
+	if p.Keepalive < 0 || p.Keepalive > 1024 {
+		return errors.New("keepalive must be between 0 and 1024")
+	}
+	p.UseWebSocket = p.WebSocket == nil || *p.WebSocket
+	for i, b := range p.Backends {
+		field := fmt.Sprintf("backends[%d]", i)
+		if err := validateConfigValue(field+".address", b.Address); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(b.Address, "unix:") && !strings.Contains(b.Address, ":") {
+			return fmt.Errorf("%s.address must include a port", field)
+		}
+		if b.Weight < 0 || b.Weight > 1000 {
+			return fmt.Errorf("%s.weight must be between 0 and 1000", field)
+		}
+		if b.MaxFails != nil && *b.MaxFails < 0 {
+			return fmt.Errorf("%s.max_fails must not be negative", field)
+		}
+		if b.FailTimeout != "" && !nginxDurationRegex.MatchString(b.FailTimeout) {
+			return fmt.Errorf("%s.fail_timeout must be a duration like 10s", field)
+		}
+	}
+	p.Name = strings.NewReplacer(".", "_", "-", "_").Replace(req.Domain) + "_backend"
+	return nil
+}
+
+// --- Templates ---
+
+const proxyLocationBlock = `        proxy_http_version 1.1;
+{{- if .Params.UseWebSocket}}
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection 'upgrade';
+        proxy_cache_bypass $http_upgrade;
+{{- end}}
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;`
+
+func init() {
+	registerSiteTemplate(&siteTemplate{
+		Name:        "proxy",
+		Description: "Reverse proxy to a local port",
+		Fields: []SiteTemplateField{
+			{Name: "port", Type: "int", Required: true, Description: "Backend port (or legacy target)"},
+			{Name: "host", Type: "string", Description: "Backend host, default 127.0.0.1"},
+			{Name: "websocket", Type: "bool", Description: "Forward Upgrade headers, default true"},
+		},
+		NewParams: func() siteTemplateParams { return &proxySiteParams{} },
+		Template: template.Must(template.New("proxy").Parse(`
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    location / {
+        proxy_pass http://{{.Params.Host}}:{{.Params.Port}};
+` + proxyLocationBlock + `
+    }
+}
+`)),
+	})
+
+	registerSiteTemplate(&siteTemplate{
+		Name:        "static",
+		Description: "Static files from a directory",
+		Fields: []SiteTemplateField{
+			{Name: "root", Type: "string", Required: true, Description: "Document root (or legacy target)"},
+		},
+		NewParams: func() siteTemplateParams { return &staticSiteParams{} },
+		Template: template.Must(template.New("static").Parse(`
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    root {{.Params.Root}};
+    index index.html index.htm;
+    location / {
+        try_files $uri $uri/ =404;
+    }
+}
+`)),
+	})
+
+	registerSiteTemplate(&siteTemplate{
+		Name:        "php",
+		Description: "PHP application served by PHP-FPM",
+		Fields: []SiteTemplateField{
+			{Name: "root", Type: "string", Required: true, Description: "Document root"},
+			{Name: "socket", Type: "string", Description: "PHP-FPM socket path"},
+			{Name: "php_version", Type: "string", Description: "Selects /run/php/php<version>-fpm.sock when socket is empty"},
+			{Name: "index", Type: "string", Description: "Front controller, default index.php"},
+		},
+		NewParams: func() siteTemplateParams { return &phpSiteParams{} },
+		Template: template.Must(template.New("php").Parse(`
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    root {{.Params.Root}};
+    index {{.Params.Index}} index.html;
+
+    location / {
+        try_files $uri $uri/ /{{.Params.Index}}?$query_string;
+    }
+
+    location ~ \.php$ {
+        include snippets/fastcgi-php.conf;
+        fastcgi_pass unix:{{.Params.Socket}};
+    }
+
+    location ~ /\.(?!well-known) {
+        deny all;
+    }
+}
+`)),
+	})
+
+	registerSiteTemplate(&siteTemplate{
+		Name:        "spa",
+		Description: "Single page application with fallback to index.html",
+		Fields: []SiteTemplateField{
+			{Name: "root", Type: "string", Required: true, Description: "Build output directory"},
+			{Name: "index", Type: "string", Description: "Fallback document, default index.html"},
+			{Name: "cache_assets", Type: "bool", Description: "Long cache for hashed assets, default true"},
+		},
+		NewParams: func() siteTemplateParams { return &spaSiteParams{} },
+		Template: template.Must(template.New("spa").Parse(`
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    root {{.Params.Root}};
+    index {{.Params.Index}};
+
+    location / {
+        try_files $uri $uri/ /{{.Params.Index}};
+    }
+
+    location = /{{.Params.Index}} {
+        add_header Cache-Control "no-cache";
+{{- if .Include}}
+        include {{.Include}};
+{{- end}}
+    }
+{{- if .Params.UseCacheAssets}}
+
+    location ~* \.(?:js|css|woff2?|ttf|svg|png|jpe?g|gif|ico|webp|avif)$ {
+        expires 1y;
+        add_header Cache-Control "public, immutable";
+{{- if .Include}}
+        include {{.Include}};
+{{- end}}
+        try_files $uri =404;
+    }
+{{- end}}
+}
+`)),
+	})
+
+	registerSiteTemplate(&siteTemplate{
+		Name:        "redirect",
+		Description: "Pure redirect or www canonicalization",
+		Fields: []SiteTemplateField{
+			{Name: "to", Type: "string", Description: "Destination URL (required unless canonical is set)"},
+			{Name: "code", Type: "int", Description: "301 (default), 302, 307 or 308"},
+			{Name: "preserve_path", Type: "bool", Description: "Keep the request path and query, default true"},
+			{Name: "canonical", Type: "string", Description: "www or non-www"},
+			{Name: "scheme", Type: "string", Description: "Scheme used with canonical, default https"},
+		},
+		NewParams: func() siteTemplateParams { return &redirectSiteParams{} },
+		Template: template.Must(template.New("redirect").Parse(`
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    location / {
+        return {{.Params.Code}} {{.Params.To}}{{if .Params.UsePreservePath}}$request_uri{{end}};
+    }
+}
+`)),
+	})
+
+	registerSiteTemplate(&siteTemplate{
+		Name:        "upstream",
+		Description: "Load balanced proxy to several backends",
+		Fields: []SiteTemplateField{
+			{Name: "backends", Type: "[]{address,weight,max_fails,fail_timeout,backup}", Required: true, Description: "Backend servers"},
+			{Name: "method", Type: "string", Description: "round_robin (default), least_conn, ip_hash or random"},
+			{Name: "keepalive", Type: "int", Description: "Idle keepalive connections per worker"},
+			{Name: "websocket", Type: "bool", Description: "Forward Upgrade headers, default true"},
+		},
+		NewParams: func() siteTemplateParams { return &upstreamSiteParams{} },
+		Template: template.Must(template.New("upstream").Parse(`
+upstream {{.Params.Name}} {
+{{- if ne .Params.Method "round_robin"}}
+    {{.Params.Method}};
+{{- end}}
+{{- range .Params.Backends}}
+    server {{.Address}}{{if .Weight}} weight={{.Weight}}{{end}}{{if .MaxFails}} max_fails={{.MaxFails}}{{end}}{{if .FailTimeout}} fail_timeout={{.FailTimeout}}{{end}}{{if .Backup}} backup{{end}};
+{{- end}}
+{{- if .Params.Keepalive}}
+    keepalive {{.Params.Keepalive}};
+{{- end}}
+}
+
+server {
+    listen 80;
+    server_name {{.Domain}};
+{{- if .Include}}
+    include {{.Include}};
+{{- end}}
+
+    location / {
+        proxy_pass http://{{.Params.Name}};
+        proxy_next_upstream error timeout http_502 http_503 http_504;
+` + proxyLocationBlock + `
+    }
+}
+`)),
+	})
+}
+
+// --- Site Template Handler ---
+
+func listSiteTemplates(c *gin.Context) {
+	type templateInfo struct {
+		Name        string              `json:"name"`
+		Description string              `json:"description"`
+		Fields      []SiteTemplateField `json:"fields"`
+	}
+	var templates []templateInfo
+	for _, t := range siteTemplates {
+		templates = append(templates, templateInfo{Name: t.Name, Description: t.Description, Fields: t.Fields})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+
+	sockets := phpFPMSockets()
+	if sockets == nil {
+		sockets = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates, "php_sockets": sockets})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSiteConfigBoolParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     CreateSiteRequest
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "proxy websocket default",
+			req:     CreateSiteRequest{Domain: "example.com", Type: "proxy", Params: []byte(`{"port":3000}`)},
+			present: []string{"proxy_set_header Upgrade $http_upgrade;"},
+		},
+		{
+			name:   "proxy websocket false",
+			req:    CreateSiteRequest{Domain: "example.com", Type: "proxy", Params: []byte(`{"port":3000,"websocket":false}`)},
+			absent: []string{"Upgrade", "proxy_cache_bypass"},
+		},
+		{
+			name:   "upstream websocket false",
+			req:    CreateSiteRequest{Domain: "example.com", Type: "upstream", Params: []byte(`{"backends":[{"address":"127.0.0.1:3000"}],"websocket":false}`)},
+			absent: []string{"Upgrade"},
+		},
+		{
+			name:    "spa cache assets default",
+			req:     CreateSiteRequest{Domain: "example.com", Type: "spa", Params: []byte(`{"root":"/srv/app"}`)},
+			present: []string{"expires 1y;"},
+		},
+		{
+			name:   "spa cache assets false",
+			req:    CreateSiteRequest{Domain: "example.com", Type: "spa", Params: []byte(`{"root":"/srv/app","cache_assets":false}`)},
+			absent: []string{"expires 1y;"},
+		},
+		{
+			name:    "redirect preserve path default",
+			req:     CreateSiteRequest{Domain: "example.com", Type: "redirect", Params: []byte(`{"to":"https://example.org/"}`)},
+			present: []string{"return 301 https://example.org$request_uri;"},
+		},
+		{
+			name:    "redirect preserve path false",
+			req:     CreateSiteRequest{Domain: "example.com", Type: "redirect", Params: []byte(`{"to":"https://example.org/","preserve_path":false}`)},
+			present: []string{"return 301 https://example.org/;"},
+			absent:  []string{"$request_uri"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := renderSiteConfig(&tt.req)
+			if err != nil {
+				t.Fatalf("renderSiteConfig: %v", err)
+			}
+			for _, want := range tt.present {
+				if !strings.Contains(string(config), want) {
+					t.Errorf("config does not contain %q:\n%s", want, config)
+				}
+			}
+			for _, unwanted := range tt.absent {
+				if strings.Contains(string(config), unwanted) {
+					t.Errorf("config contains %q:\n%s", unwanted, config)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderSPAHardeningInLocations(t *testing.T) {
+	req := CreateSiteRequest{Domain: "example.com", Type: "spa", Params: []byte(`{"root":"/srv/app"}`), Hardening: &SiteHardening{}}
+	config, err := renderSiteConfig(&req)
+	if err != nil {
+		t.Fatalf("renderSiteConfig: %v", err)
+	}
+	include := "include " + hardeningSnippetPath("example.com") + ";"
+	// Once at server level and once in each location with add_header
+	if got := strings.Count(string(config), include); got != 3 {
+		t.Errorf("snippet included %d times, want 3:\n%s", got, config)
+	}
+	for _, want := range []string{`add_header Cache-Control "no-cache";`, `add_header Cache-Control "public, immutable";`} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("config does not contain %q:\n%s", want, config)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package nginxconf
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Config is the structured view of one configuration file.
+type Config struct {
+	Servers    []Server   `json:"servers"`
+	Upstreams  []Upstream `json:"upstreams"`
+	AccessLogs []string   `json:"access_logs,omitempty"`
+	ErrorLogs  []string   `json:"error_logs,omitempty"`
+}
+
+type Server struct {
+	ServerNames       []string   `json:"server_names"`
+	Listen            []Listen   `json:"listen"`
+	SSL               bool       `json:"ssl"`
+	SSLCertificate    string     `json:"ssl_certificate,omitempty"`
+	SSLCertificateKey string     `json:"ssl_certificate_key,omitempty"`
+	Root              string     `json:"root,omitempty"`
+	Return            string     `json:"return,omitempty"`
+	AccessLogs        []string   `json:"access_logs,omitempty"`
+	ErrorLogs         []string   `json:"error_logs,omitempty"`
+	Locations         []Location `json:"locations"`
+	Line              int        `json:"line"`
+}
+
+type Listen struct {
+	Address       string `json:"address,omitempty"` // Empty means all addresses
+	Port          int    `json:"port,omitempty"`
+	Socket        string `json:"socket,omitempty"` // unix:/path listeners
+	SSL           bool   `json:"ssl"`
+	HTTP2         bool   `json:"http2"`
+	DefaultServer bool   `json:"default_server"`
+}
+
+type Location struct {
+	Modifier    string `json:"modifier,omitempty"` // "=", "~", "~*", "^~"
+	Path        string `json:"path"`
+	ProxyPass   string `json:"proxy_pass,omitempty"`
+	FastCGIPass string `json:"fastcgi_pass,omitempty"`
+	Root        string `json:"root,omitempty"`
+	Alias       string `json:"alias,omitempty"`
+	Return      string `json:"return,omitempty"`
+	TryFiles    string `json:"try_files,omitempty"`
+	Line        int    `json:"line"`
+}
+
+type Upstream struct {
+	Name    string           `json:"name"`
+	Servers []UpstreamServer `json:"servers"`
+	Line    int              `json:"line"`
+}
+
+type UpstreamServer struct {
+	Address string   `json:"address"`
+	Params  []string `json:"params,omitempty"`
+}
+
+// Target is a resolved backend address referenced by proxy_pass/fastcgi_pass.
+type Target struct {
+	Host   string `json:"host,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	Socket string `json:"socket,omitempty"`
+}
+
+// Extract builds the structured model from a parsed file. Server blocks may
+// appear at top level (sites-available) or inside an http block (nginx.conf).
+func Extract(directives []*Directive) Config {
+	cfg := Config{Servers: []Server{}, Upstreams: []Upstream{}}
+	for _, d := range directives {
+		switch d.Name {
+		case "http":
+			inner := Extract(d.Block)
+			cfg.Servers = append(cfg.Servers, inner.Servers...)
+			cfg.Upstreams = append(cfg.Upstreams, inner.Upstreams...)
+			cfg.AccessLogs = append(cfg.AccessLogs, inner.AccessLogs...)
+			cfg.ErrorLogs = append(cfg.ErrorLogs, inner.ErrorLogs...)
+		case "server":
+			cfg.Servers = append(cfg.Servers, extractServer(d))
+		case "upstream":
+			cfg.Upstreams = append(cfg.Upstreams, extractUpstream(d))
+		case "access_log":
+			if len(d.Args) > 0 {
+				cfg.AccessLogs = append(cfg.AccessLogs, d.Args[0])
+			}
+		case "error_log":
+			if len(d.Args) > 0 {
+				cfg.ErrorLogs = append(cfg.ErrorLogs, d.Args[0])
+			}
+		}
+	}
+	return cfg
+}
+
+func extractServer(d *Directive) Server {
+	s := Server{Locations: []Location{}, Line: d.Line}
+	for _, child := range d.Block {
+		switch child.Name {
+		case "server_name":
+			s.ServerNames = append(s.ServerNames, child.Args...)
+		case "listen":
+			l := ParseListen(child.Args)
+			s.SSL = s.SSL || l.SSL
+			s.Listen = append(s.Listen, l)
+		case "ssl":
+			s.SSL = s.SSL || (len(child.Args) > 0 && child.Args[0] == "on")
+		case "ssl_certificate":
+			if len(child.Args) > 0 {
+				s.SSLCertificate = child.Args[0]
+			}
+		case "ssl_certificate_key":
+			if len(child.Args) > 0 {
+				s.SSLCertificateKey = child.Args[0]
+			}
+		case "root":
+			if len(child.Args) > 0 {
+				s.Root = child.Args[0]
+			}
+		case "return":
+			s.Return = strings.Join(child.Args, " ")
+		case "access_log":
+			if len(child.Args) > 0 {
+				s.AccessLogs = append(s.AccessLogs, child.Args[0])
+			}
+		case "error_log":
+			if len(child.Args) > 0 {
+				s.ErrorLogs = append(s.ErrorLogs, child.Args[0])
+			}
+		case "location":
+			s.Locations = append(s.Locations, extractLocations(child)...)
+		}
+	}
+	return s
+}
+
+// extractLocations flattens nested locations into a list.
+func extractLocations(d *Directive) []Location {
+	loc := Location{Line: d.Line}
+	switch len(d.Args) {
+	case 0:
+	case 1:
+		loc.Path = d.Args[0]
+	default:
+		loc.Modifier, loc.Path = d.Args[0], d.Args[1]
+	}
+	var nested []Location
+	for _, child := range d.Block {
+		switch child.Name {
+		case "proxy_pass":
+			loc.ProxyPass = strings.Join(child.Args, " ")
+		case "fastcgi_pass":
+			loc.FastCGIPass = strings.Join(child.Args, " ")
+		case "root":
+			loc.Root = strings.Join(child.Args, " ")
+		case "alias":
+			loc.Alias = strings.Join(child.Args, " ")
+		case "return":
+			loc.Return = strings.Join(child.Args, " ")
+		case "try_files":
+			loc.TryFiles = strings.Join(child.Args, " ")
+		case "location":
+			nested = append(nested, extractLocations(child)...)
+		}
+	}
+	return append([]Location{loc}, nested...)
+}
+
+func extractUpstream(d *Directive) Upstream {
+	u := Upstream{Line: d.Line}
+	if len(d.Args) > 0 {
+		u.Name = d.Args[0]
+	}
+	for _, child := range d.Block {
+		if child.Name == "server" && len(child.Args) > 0 {
+			u.Servers = append(u.Servers, UpstreamServer{Address: child.Args[0], Params: child.Args[1:]})
+		}
+	}
+	return u
+}
+
+// ParseListen interprets the arguments of a listen directive.
+func ParseListen(args []string) Listen {
+	var l Listen
+	if len(args) == 0 {
+		return l
+	}
+	addr := args[0]
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		l.Socket = strings.TrimPrefix(addr, "unix:")
+	default:
+		if port, err := strconv.Atoi(addr); err == nil {
+			l.Port = port
+		} else if host, portStr, err := net.SplitHostPort(addr); err == nil {
+			l.Address = strings.Trim(host, "[]")
+			l.Port, _ = strconv.Atoi(portStr)
+		} else {
+			l.Address = strings.Trim(addr, "[]")
+			l.Port = 80
+		}
+	}
+	for _, param := range args[1:] {
+		switch param {
+		case "ssl":
+			l.SSL = true
+		case "http2":
+			l.HTTP2 = true
+		case "default_server", "default":
+			l.DefaultServer = true
+		}
+	}
+	return l
+}
+
+// ParseTarget resolves a proxy_pass URL or fastcgi_pass address. Host is left
+// unresolved so callers can match it against upstream names.
+func ParseTarget(value string) (Target, bool) {
+	if value == "" || strings.Contains(value, "$") {
+		return Target{}, false // Variables are only known at request time
+	}
+	if strings.HasPrefix(value, "unix:") {
+		return Target{Socket: strings.SplitN(strings.TrimPrefix(value, "unix:"), ":", 2)[0]}, true
+	}
+
+	defaultPort := 0
+	if strings.Contains(value, "://") {
+		u, err := url.Parse(value)
+		if err != nil {
+			return Target{}, false
+		}
+		if strings.HasPrefix(u.Host, "unix:") {
+			return Target{Socket: strings.SplitN(strings.TrimPrefix(u.Host, "unix:"), ":", 2)[0]}, true
+		}
+		switch u.Scheme {
+		case "http":
+			defaultPort = 80
+		case "https":
+			defaultPort = 443
+		}
+		value = u.Host
+	}
+	return ParseAddress(value, defaultPort), true
+}
+
+// ParseAddress splits "host:port", "[v6]:port" or a bare host.
+func ParseAddress(value string, defaultPort int) Target {
+	if host, portStr, err := net.SplitHostPort(value); err == nil {
+		port, _ := strconv.Atoi(portStr)
+		return Target{Host: host, Port: port}
+	}
+	if strings.HasPrefix(value, "unix:") {
+		return Target{Socket: strings.TrimPrefix(value, "unix:")}
+	}
+	return Target{Host: strings.Trim(value, "[]"), Port: defaultPort}
+}
@@ -0,0 +1,238 @@
+package nginxconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Directive is a single nginx directive. Block is nil for simple directives
+// ("listen 80;") and non-nil for block directives ("server { ... }").
+type Directive struct {
+	Name  string       `json:"name"`
+	Args  []string     `json:"args,omitempty"`
+	Block []*Directive `json:"block,omitempty"`
+	Line  int          `json:"line"`
+}
+
+// ParseError reports a syntax error with the line it was found on.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenSemicolon
+	tokenEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) next() (token, error) {
+	// Skip whitespace and comments
+	for l.pos < len(l.src) {
+		ch := l.src[l.pos]
+		if ch == '\n' {
+			l.line++
+			l.pos++
+		} else if ch == ' ' || ch == '\t' || ch == '\r' {
+			l.pos++
+		} else if ch == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	line := l.line
+	switch ch := l.src[l.pos]; ch {
+	case '{':
+		l.pos++
+		return token{kind: tokenOpen, text: "{", line: line}, nil
+	case '}':
+		l.pos++
+		return token{kind: tokenClose, text: "}", line: line}, nil
+	case ';':
+		l.pos++
+		return token{kind: tokenSemicolon, text: ";", line: line}, nil
+	case '"', '\'':
+		return l.quoted(ch)
+	}
+	return l.word(), nil
+}
+
+func (l *lexer) quoted(quote byte) (token, error) {
+	line := l.line
+	l.pos++ // opening quote
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		ch := l.src[l.pos]
+		switch {
+		case ch == '\\' && l.pos+1 < len(l.src):
+			next := l.src[l.pos+1]
+			if next == quote || next == '\\' {
+				sb.WriteByte(next)
+			} else {
+				sb.WriteByte(ch)
+				sb.WriteByte(next)
+			}
+			l.pos += 2
+			continue
+		case ch == quote:
+			l.pos++
+			return token{kind: tokenWord, text: sb.String(), line: line}, nil
+		case ch == '\n':
+			l.line++
+		}
+		sb.WriteByte(ch)
+		l.pos++
+	}
+	return token{}, &ParseError{Line: line, Msg: "unterminated quoted string"}
+}
+
+func (l *lexer) word() token {
+	line := l.line
+	start := l.pos
+	for l.pos < len(l.src) {
+		ch := l.src[l.pos]
+		if ch == '$' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '{' {
+			// ${variable} may contain characters that end a word
+			end := strings.IndexByte(l.src[l.pos:], '}')
+			if end > 0 {
+				l.pos += end + 1
+				continue
+			}
+		}
+		if ch == '\\' && l.pos+1 < len(l.src) {
+			l.pos += 2
+			continue
+		}
+		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == ';' || ch == '{' || ch == '}' {
+			break
+		}
+		l.pos++
+	}
+	return token{kind: tokenWord, text: l.src[start:l.pos], line: line}
+}
+
+// Parse turns nginx configuration text into a directive tree. Includes are not
+// followed; they are returned as ordinary "include" directives.
+func Parse(src string) ([]*Directive, error) {
+	l := &lexer{src: src, line: 1}
+	directives, closed, err := parseBlock(l)
+	if err != nil {
+		return nil, err
+	}
+	if closed {
+		return nil, &ParseError{Line: l.line, Msg: "unexpected \"}\""}
+	}
+	return directives, nil
+}
+
+// parseBlock reads directives until EOF or a closing brace; closed reports which.
+func parseBlock(l *lexer) ([]*Directive, bool, error) {
+	var directives []*Directive
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, false, err
+		}
+		switch tok.kind {
+		case tokenEOF:
+			return directives, false, nil
+		case tokenClose:
+			return directives, true, nil
+		case tokenOpen, tokenSemicolon:
+			return nil, false, &ParseError{Line: tok.line, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+		}
+
+		d := &Directive{Name: tok.text, Line: tok.line}
+		for {
+			arg, err := l.next()
+			if err != nil {
+				return nil, false, err
+			}
+			if arg.kind == tokenWord {
+				d.Args = append(d.Args, arg.text)
+				continue
+			}
+			if arg.kind == tokenSemicolon {
+				break
+			}
+			if arg.kind == tokenOpen {
+				block, closed, err := parseBlock(l)
+				if err != nil {
+					return nil, false, err
+				}
+				if !closed {
+					return nil, false, &ParseError{Line: d.Line, Msg: fmt.Sprintf("unclosed block %q", d.Name)}
+				}
+				if block == nil {
+					block = []*Directive{}
+				}
+				d.Block = block
+				break
+			}
+			return nil, false, &ParseError{Line: arg.line, Msg: fmt.Sprintf("directive %q is not terminated by \";\"", d.Name)}
+		}
+		directives = append(directives, d)
+	}
+}
+
+// Find returns the direct children of directives named name.
+func Find(directives []*Directive, name string) []*Directive {
+	var found []*Directive
+	for _, d := range directives {
+		if d.Name == name {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// FindRecursive returns every directive named name at any depth.
+func FindRecursive(directives []*Directive, name string) []*Directive {
+	var found []*Directive
+	for _, d := range directives {
+		if d.Name == name {
+			found = append(found, d)
+		}
+		if d.Block != nil {
+			found = append(found, FindRecursive(d.Block, name)...)
+		}
+	}
+	return found
+}
+
+// First returns the arguments of the first direct child named name, or nil.
+func First(directives []*Directive, name string) []string {
+	for _, d := range directives {
+		if d.Name == name {
+			return d.Args
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package nginxconf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []*Directive
+	}{
+		{
+			name: "simple directives",
+			src:  "user www-data;\nworker_processes auto;\n",
+			want: []*Directive{
+				{Name: "user", Args: []string{"www-data"}, Line: 1},
+				{Name: "worker_processes", Args: []string{"auto"}, Line: 2},
+			},
+		},
+		{
+			name: "nested blocks",
+			src:  "server {\n  listen 80;\n  location / {\n    root /var/www;\n  }\n}\n",
+			want: []*Directive{
+				{Name: "server", Line: 1, Block: []*Directive{
+					{Name: "listen", Args: []string{"80"}, Line: 2},
+					{Name: "location", Args: []string{"/"}, Line: 3, Block: []*Directive{
+						{Name: "root", Args: []string{"/var/www"}, Line: 4},
+					}},
+				}},
+			},
+		},
+		{
+			name: "empty block",
+			src:  "events {}",
+			want: []*Directive{{Name: "events", Line: 1, Block: []*Directive{}}},
+		},
+		{
+			name: "double quotes keep spaces and braces",
+			src:  `add_header X-Note "a {b}; c";`,
+			want: []*Directive{{Name: "add_header", Args: []string{"X-Note", "a {b}; c"}, Line: 1}},
+		},
+		{
+			name: "single quotes and escaped quote",
+			src:  `return 200 'it\'s ok';`,
+			want: []*Directive{{Name: "return", Args: []string{"200", "it's ok"}, Line: 1}},
+		},
+		{
+			name: "other escapes are kept",
+			src:  `rewrite "^/a\.b$" /c;`,
+			want: []*Directive{{Name: "rewrite", Args: []string{`^/a\.b$`, "/c"}, Line: 1}},
+		},
+		{
+			name: "multi-line quoted string counts lines",
+			src:  "log_format main \"a\nb\";\nlisten 80;",
+			want: []*Directive{
+				{Name: "log_format", Args: []string{"main", "a\nb"}, Line: 1},
+				{Name: "listen", Args: []string{"80"}, Line: 3},
+			},
+		},
+		{
+			name: "variables in braces",
+			src:  `set $x ${host}_suffix;`,
+			want: []*Directive{{Name: "set", Args: []string{"$x", "${host}_suffix"}, Line: 1}},
+		},
+		{
+			name: "comments",
+			src:  "# leading\nlisten 80; # trailing ; {\n# listen 81;\n",
+			want: []*Directive{{Name: "listen", Args: []string{"80"}, Line: 2}},
+		},
+		{
+			name: "hash inside quotes is not a comment",
+			src:  `add_header X-Tag "#1";`,
+			want: []*Directive{{Name: "add_header", Args: []string{"X-Tag", "#1"}, Line: 1}},
+		},
+		{
+			name: "includes are not followed",
+			src:  "http {\n  include /etc/nginx/mime.types;\n  include /etc/nginx/sites-enabled/*;\n}",
+			want: []*Directive{
+				{Name: "http", Line: 1, Block: []*Directive{
+					{Name: "include", Args: []string{"/etc/nginx/mime.types"}, Line: 2},
+					{Name: "include", Args: []string{"/etc/nginx/sites-enabled/*"}, Line: 3},
+				}},
+			},
+		},
+		{
+			name: "empty input",
+			src:  "  \n# nothing\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.src)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got  %s\n want %s", tt.src, dump(got), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+	}{
+		{"unterminated directive", "listen 80", 1},
+		{"unterminated before block end", "server {\n  listen 80\n}", 3},
+		{"unclosed block", "server {\n  listen 80;\n", 1},
+		{"unexpected close", "listen 80;\n}", 2},
+		{"unexpected open", "{ listen 80; }", 1},
+		{"stray semicolon", "listen 80;\n;", 2},
+		{"unterminated quote", "listen 80;\nreturn 200 \"oops;\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Parse(%q) error = %v, want *ParseError", tt.src, err)
+			}
+			if perr.Line != tt.line {
+				t.Errorf("Parse(%q) error on line %d, want %d (%v)", tt.src, perr.Line, tt.line, err)
+			}
+		})
+	}
+}
+
+func TestFindRecursive(t *testing.T) {
+	directives, err := Parse("http { include a; server { include b; } }\ninclude c;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range FindRecursive(directives, "include") {
+		got = append(got, d.Args[0])
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindRecursive = %v, want %v", got, want)
+	}
+	if got := len(Find(directives, "include")); got != 1 {
+		t.Errorf("Find returned %d top-level includes, want 1", got)
+	}
+}
+
+func dump(directives []*Directive) string {
+	s := "["
+	for i, d := range directives {
+		if i > 0 {
+			s += " "
+		}
+		s += d.Name
+		for _, a := range d.Args {
+			s += fmt.Sprintf(" %q", a)
+		}
+		if d.Block != nil {
+			s += " {" + dump(d.Block) + "}"
+		}
+		s += fmt.Sprintf(";@%d", d.Line)
+	}
+	return s + "]"
+}
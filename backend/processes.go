@@ -0,0 +1,624 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/unix"
+)
+
+// --- Process Control ---
+
+type KillProcessRequest struct {
+	PID          int32  `json:"pid"`
+	Signal       string `json:"signal"`        // TERM, HUP, INT, KILL, STOP, CONT, USR1 (default KILL)
+	GraceSeconds int    `json:"grace_seconds"` // If > 0, send TERM or INT (TERM by default), then KILL after this many seconds
+	Tree         bool   `json:"tree"`          // Also signal all descendants
+	Group        bool   `json:"group"`         // Signal the whole process group
+}
+
+type KillResult struct {
+	PID       int32  `json:"pid"`
+	Name      string `json:"name"`
+	Signal    string `json:"signal"`
+	Escalated bool   `json:"escalated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+var allowedSignals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+	"USR1": syscall.SIGUSR1,
+}
+
+// Process names that must never be signalled from the panel.
+var protectedProcessNames = []string{"systemd", "init", "sshd"}
+
+const maxGraceSeconds = 300
+
+func parseSignal(name string) (syscall.Signal, string, error) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if name == "" {
+		name = "KILL"
+	}
+	sig, ok := allowedSignals[name]
+	if !ok {
+		return 0, "", fmt.Errorf("unsupported signal %q", name)
+	}
+	return sig, name, nil
+}
+
+// checkProtectedProcess refuses PID 1, the backend itself (and its parent) and
+// any process whose name is in protectedProcessNames.
+func checkProtectedProcess(proc *process.Process) error {
+	if proc.Pid <= 1 {
+		return errors.New("refusing to signal PID 1")
+	}
+	if int(proc.Pid) == os.Getpid() || int(proc.Pid) == os.Getppid() {
+		return errors.New("refusing to signal the system manager backend")
+	}
+	name, _ := proc.Name()
+	for _, protected := range protectedProcessNames {
+		if name == protected {
+			return fmt.Errorf("refusing to signal protected process %q", name)
+		}
+	}
+	return nil
+}
+
+// collectProcessTree returns the process followed by all of its descendants.
+func collectProcessTree(proc *process.Process) []*process.Process {
+	result := []*process.Process{proc}
+	children, err := proc.Children()
+	if err != nil {
+		return result
+	}
+	for _, child := range children {
+		result = append(result, collectProcessTree(child)...)
+	}
+	return result
+}
+
+// collectProcessGroup returns every process sharing the process group of pid.
+func collectProcessGroup(pid int32) ([]*process.Process, error) {
+	pgid, err := syscall.Getpgid(int(pid))
+	if err != nil {
+		return nil, err
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	var group []*process.Process
+	for _, p := range procs {
+		if g, err := syscall.Getpgid(int(p.Pid)); err == nil && g == pgid {
+			group = append(group, p)
+		}
+	}
+	return group, nil
+}
+
+// waitForExit polls until the process is gone or the timeout expires.
+func waitForExit(pid int32, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if exists, _ := process.PidExists(pid); !exists {
+			return true
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	exists, _ := process.PidExists(pid)
+	return !exists
+}
+
+// signalProcesses validates every target against the safeguard list before
+// sending anything, so a protected process inside a tree or group aborts the
+// whole operation instead of leaving it half-killed.
+func signalProcesses(targets []*process.Process, sig syscall.Signal, sigName string, grace time.Duration) ([]KillResult, error) {
+	for _, p := range targets {
+		if err := checkProtectedProcess(p); err != nil {
+			return nil, fmt.Errorf("PID %d: %w", p.Pid, err)
+		}
+	}
+
+	results := make([]KillResult, 0, len(targets))
+	for _, p := range targets {
+		name, _ := p.Name()
+		res := KillResult{PID: p.Pid, Name: name, Signal: sigName}
+		if err := syscall.Kill(int(p.Pid), sig); err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+
+	if grace > 0 {
+		deadline := time.Now().Add(grace)
+		for i := range results {
+			if results[i].Error != "" || waitForExit(results[i].PID, time.Until(deadline)) {
+				continue
+			}
+			if err := syscall.Kill(int(results[i].PID), syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				results[i].Error = "escalation failed: " + err.Error()
+			}
+			results[i].Escalated = true
+		}
+	}
+	return results, nil
+}
+
+// --- Process Tuning ---
+
+type ReniceRequest struct {
+	PID        int32  `json:"pid"`
+	Nice       *int   `json:"nice"`        // -20 (highest) .. 19 (lowest)
+	IOClass    string `json:"io_class"`    // "realtime", "best-effort", "idle"
+	IOPriority *int   `json:"io_priority"` // 0 (highest) .. 7 (lowest), ignored for "idle"
+}
+
+type AffinityRequest struct {
+	PID  int32 `json:"pid"`
+	CPUs []int `json:"cpus"`
+}
+
+// ioprio_set/ioprio_get constants (linux/ioprio.h)
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	"none":        0,
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+func ioprioClassName(class int) string {
+	for name, value := range ioprioClasses {
+		if value == class {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func setIOPriority(pid int32, class, level int) error {
+	prio := class<<ioprioClassShift | level
+	_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getIOPriority(pid int32) (class, level int, err error) {
+	r, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(pid), 0)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(r) >> ioprioClassShift, int(r) & (1<<ioprioClassShift - 1), nil
+}
+
+// getNice reads the nice value directly; gopsutil's Nice() reports the kernel
+// priority (20 + nice) instead.
+func getNice(pid int32) (int32, error) {
+	// The raw getpriority syscall returns 20 - nice to avoid negative values.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, int(pid))
+	if err != nil {
+		return 0, err
+	}
+	return int32(20 - prio), nil
+}
+
+func getCPUAffinity(pid int32) ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(int(pid), &set); err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for i := 0; i < len(set)*64; i++ {
+		if set.IsSet(i) {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+// ThreadError is a thread a tuning change could not be applied to.
+type ThreadError struct {
+	TID   int    `json:"tid"`
+	Error string `json:"error"`
+}
+
+// forEachThread calls apply for every thread of pid, like taskset -a: nice
+// values, IO priorities and CPU affinity are per thread on Linux, so changing
+// only the thread whose TID equals the PID leaves the others untouched. The
+// task list is read again until it holds no new threads, so threads started
+// meanwhile are covered too. Threads that exit before they are reached are
+// skipped.
+func forEachThread(pid int32, apply func(tid int) error) (applied int, failed []ThreadError, err error) {
+	taskDir := fmt.Sprintf("/proc/%d/task", pid)
+	seen := make(map[int]bool)
+	for {
+		entries, err := os.ReadDir(taskDir)
+		if err != nil {
+			return applied, failed, err
+		}
+		found := false
+		for _, entry := range entries {
+			tid, err := strconv.Atoi(entry.Name())
+			if err != nil || seen[tid] {
+				continue
+			}
+			seen[tid], found = true, true
+			if err := apply(tid); errors.Is(err, syscall.ESRCH) {
+				continue
+			} else if err != nil {
+				failed = append(failed, ThreadError{TID: tid, Error: err.Error()})
+				continue
+			}
+			applied++
+		}
+		if !found {
+			return applied, failed, nil
+		}
+	}
+}
+
+// applyToThreads runs forEachThread for a handler. It writes the error
+// response and returns false when no thread could be changed; partial
+// failures are returned for the response.
+func applyToThreads(c *gin.Context, pid int32, what string, apply func(tid int) error) ([]ThreadError, bool) {
+	applied, failed, err := forEachThread(pid, apply)
+	switch {
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to set %s: %v", what, err)})
+		return nil, false
+	case applied == 0 && len(failed) > 0:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to set %s: %s", what, failed[0].Error), "thread_errors": failed})
+		return nil, false
+	case applied == 0:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Process not found"})
+		return nil, false
+	}
+	return failed, true
+}
+
+// lookupTunableProcess resolves the PID and applies the same safeguards as kill.
+func lookupTunableProcess(c *gin.Context, pid int32) (*process.Process, bool) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Process not found"})
+		return nil, false
+	}
+	if err := checkProtectedProcess(proc); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return proc, true
+}
+
+func reniceProcess(c *gin.Context) {
+	var req ReniceRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if req.Nice == nil && req.IOClass == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nice or io_class required"})
+		return
+	}
+	if req.Nice != nil && (*req.Nice < -20 || *req.Nice > 19) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nice must be between -20 and 19"})
+		return
+	}
+	ioClass, ioLevel := 0, 4
+	if req.IOClass != "" {
+		var ok bool
+		if ioClass, ok = ioprioClasses[req.IOClass]; !ok || ioClass == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "io_class must be realtime, best-effort or idle"})
+			return
+		}
+		if req.IOPriority != nil {
+			if *req.IOPriority < 0 || *req.IOPriority > 7 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "io_priority must be between 0 and 7"})
+				return
+			}
+			ioLevel = *req.IOPriority
+		}
+		if ioClass == ioprioClasses["idle"] {
+			ioLevel = 0
+		}
+	}
+
+	proc, ok := lookupTunableProcess(c, req.PID)
+	if !ok {
+		return
+	}
+
+	var threadErrors []ThreadError
+	if req.Nice != nil {
+		failed, ok := applyToThreads(c, proc.Pid, "nice", func(tid int) error {
+			return syscall.Setpriority(syscall.PRIO_PROCESS, tid, *req.Nice)
+		})
+		if !ok {
+			return
+		}
+		threadErrors = append(threadErrors, failed...)
+	}
+	if req.IOClass != "" {
+		failed, ok := applyToThreads(c, proc.Pid, "IO priority", func(tid int) error {
+			return setIOPriority(int32(tid), ioClass, ioLevel)
+		})
+		if !ok {
+			return
+		}
+		threadErrors = append(threadErrors, failed...)
+	}
+
+	nice, _ := getNice(proc.Pid)
+	class, level, _ := getIOPriority(proc.Pid)
+	resp := gin.H{
+		"status":      "Updated",
+		"nice":        nice,
+		"io_class":    ioprioClassName(class),
+		"io_priority": level,
+	}
+	if len(threadErrors) > 0 {
+		resp["thread_errors"] = threadErrors
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func setProcessAffinity(c *gin.Context) {
+	var req AffinityRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if len(req.CPUs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one CPU required"})
+		return
+	}
+	// runtime.NumCPU only counts the CPUs this process may run on, which
+	// would reject CPUs outside our own affinity mask.
+	numCPU, err := cpu.Counts(true)
+	if err != nil || numCPU < 1 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count CPUs"})
+		return
+	}
+	var set unix.CPUSet
+	for _, cpuID := range req.CPUs {
+		if cpuID < 0 || cpuID >= numCPU {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid CPU %d (host has %d)", cpuID, numCPU)})
+			return
+		}
+		set.Set(cpuID)
+	}
+
+	proc, ok := lookupTunableProcess(c, req.PID)
+	if !ok {
+		return
+	}
+	threadErrors, ok := applyToThreads(c, proc.Pid, "affinity", func(tid int) error {
+		return unix.SchedSetaffinity(tid, &set)
+	})
+	if !ok {
+		return
+	}
+
+	cpus, _ := getCPUAffinity(proc.Pid)
+	resp := gin.H{"status": "Updated", "cpus": cpus}
+	if len(threadErrors) > 0 {
+		resp["thread_errors"] = threadErrors
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// --- Process Detail ---
+
+type ProcessDetail struct {
+	PID         int32               `json:"pid"`
+	PPID        int32               `json:"ppid"`
+	Name        string              `json:"name"`
+	Exe         string              `json:"exe"`
+	Cmdline     string              `json:"cmdline"`
+	Cwd         string              `json:"cwd"`
+	Username    string              `json:"username"`
+	Status      []string            `json:"status"`
+	CreateTime  int64               `json:"create_time"`
+	CPUPercent  float64             `json:"cpu_percent"`
+	MemPercent  float32             `json:"mem_percent"`
+	RSS         uint64              `json:"rss"`
+	VMS         uint64              `json:"vms"`
+	NumThreads  int32               `json:"num_threads"`
+	NumFDs      int32               `json:"num_fds"`
+	Nice        int32               `json:"nice"`
+	IOClass     string              `json:"io_class"`
+	IOPriority  int                 `json:"io_priority"`
+	CPUAffinity []int               `json:"cpu_affinity"`
+	Environ     []string            `json:"environ"`
+	OpenFiles   []OpenFileInfo      `json:"open_files"`
+	Connections []ProcessConnection `json:"connections"`
+	MemoryMaps  MemoryMapsSummary   `json:"memory_maps"`
+	Cgroups     []string            `json:"cgroups"`
+}
+
+type OpenFileInfo struct {
+	FD   uint64 `json:"fd"`
+	Path string `json:"path"`
+}
+
+type ProcessConnection struct {
+	Protocol   string `json:"protocol"`
+	LocalAddr  string `json:"local_addr"`
+	RemoteAddr string `json:"remote_addr"`
+	Status     string `json:"status"`
+}
+
+type MemoryMapsSummary struct {
+	Count        int              `json:"count"`
+	TotalRSS     uint64           `json:"total_rss"`
+	TotalSize    uint64           `json:"total_size"`
+	TotalSwap    uint64           `json:"total_swap"`
+	PrivateDirty uint64           `json:"private_dirty"`
+	Top          []MemoryMapEntry `json:"top"`
+}
+
+type MemoryMapEntry struct {
+	Path string `json:"path"`
+	RSS  uint64 `json:"rss"`
+	Size uint64 `json:"size"`
+}
+
+const (
+	maxDetailOpenFiles   = 200
+	maxDetailConnections = 200
+	maxDetailMemoryMaps  = 10
+)
+
+var sensitiveEnvPattern = regexp.MustCompile(`(?i)(pass|secret|token|key|auth|credential|cookie|session|private|dsn|database_url)`)
+
+// redactEnviron hides the value of any variable whose name looks sensitive.
+func redactEnviron(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, found := strings.Cut(kv, "=")
+		if found && sensitiveEnvPattern.MatchString(name) {
+			kv = name + "=[REDACTED]"
+		}
+		redacted = append(redacted, kv)
+	}
+	sort.Strings(redacted)
+	return redacted
+}
+
+func summarizeMemoryMaps(maps []process.MemoryMapsStat) MemoryMapsSummary {
+	summary := MemoryMapsSummary{Count: len(maps)}
+	byPath := make(map[string]*MemoryMapEntry)
+	for _, m := range maps {
+		summary.TotalRSS += m.Rss * 1024
+		summary.TotalSize += m.Size * 1024
+		summary.TotalSwap += m.Swap * 1024
+		summary.PrivateDirty += m.PrivateDirty * 1024
+
+		path := m.Path
+		if path == "" {
+			path = "[anon]"
+		}
+		entry, ok := byPath[path]
+		if !ok {
+			entry = &MemoryMapEntry{Path: path}
+			byPath[path] = entry
+		}
+		entry.RSS += m.Rss * 1024
+		entry.Size += m.Size * 1024
+	}
+	for _, entry := range byPath {
+		summary.Top = append(summary.Top, *entry)
+	}
+	sort.Slice(summary.Top, func(i, j int) bool { return summary.Top[i].RSS > summary.Top[j].RSS })
+	if len(summary.Top) > maxDetailMemoryMaps {
+		summary.Top = summary.Top[:maxDetailMemoryMaps]
+	}
+	return summary
+}
+
+func readCgroups(pid int32) []string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+func getProcessDetail(c *gin.Context) {
+	pid, err := strconv.ParseInt(c.Param("pid"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PID"})
+		return
+	}
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Process not found"})
+		return
+	}
+
+	// Every field is best effort: kernel threads and foreign users hide parts of /proc.
+	detail := ProcessDetail{PID: proc.Pid}
+	detail.PPID, _ = proc.Ppid()
+	detail.Name, _ = proc.Name()
+	detail.Exe, _ = proc.Exe()
+	detail.Cmdline, _ = proc.Cmdline()
+	detail.Cwd, _ = proc.Cwd()
+	detail.Username, _ = proc.Username()
+	detail.Status, _ = proc.Status()
+	detail.CreateTime, _ = proc.CreateTime()
+	detail.CPUPercent, _ = proc.CPUPercent()
+	detail.MemPercent, _ = proc.MemoryPercent()
+	if memInfo, err := proc.MemoryInfo(); err == nil {
+		detail.RSS = memInfo.RSS
+		detail.VMS = memInfo.VMS
+	}
+	detail.NumThreads, _ = proc.NumThreads()
+	detail.NumFDs, _ = proc.NumFDs()
+	detail.Nice, _ = getNice(proc.Pid)
+	if class, level, err := getIOPriority(proc.Pid); err == nil {
+		detail.IOClass = ioprioClassName(class)
+		detail.IOPriority = level
+	}
+	detail.CPUAffinity, _ = getCPUAffinity(proc.Pid)
+
+	if env, err := proc.Environ(); err == nil {
+		detail.Environ = redactEnviron(env)
+	}
+
+	if files, err := proc.OpenFiles(); err == nil {
+		for i, f := range files {
+			if i >= maxDetailOpenFiles {
+				break
+			}
+			detail.OpenFiles = append(detail.OpenFiles, OpenFileInfo{FD: f.Fd, Path: f.Path})
+		}
+	}
+
+	if conns, err := proc.Connections(); err == nil {
+		for i, conn := range conns {
+			if i >= maxDetailConnections {
+				break
+			}
+			protocol := "tcp"
+			if conn.Type == syscall.SOCK_DGRAM {
+				protocol = "udp"
+			}
+			detail.Connections = append(detail.Connections, ProcessConnection{
+				Protocol:   protocol,
+				LocalAddr:  fmt.Sprintf("%s:%d", conn.Laddr.IP, conn.Laddr.Port),
+				RemoteAddr: fmt.Sprintf("%s:%d", conn.Raddr.IP, conn.Raddr.Port),
+				Status:     conn.Status,
+			})
+		}
+	}
+
+	if maps, err := proc.MemoryMaps(false); err == nil && maps != nil {
+		detail.MemoryMaps = summarizeMemoryMaps(*maps)
+	}
+	detail.Cgroups = readCgroups(proc.Pid)
+
+	c.JSON(http.StatusOK, detail)
+}
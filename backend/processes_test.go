@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestForEachThreadCoversEveryThread(t *testing.T) {
+	// Park a few goroutines on threads of their own
+	var started, done sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			started.Done()
+			<-stop
+		}()
+	}
+	started.Wait()
+	defer func() {
+		close(stop)
+		done.Wait()
+	}()
+
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	applied, failed, err := forEachThread(int32(os.Getpid()), func(tid int) error {
+		if seen[tid] {
+			t.Errorf("thread %d visited twice", tid)
+		}
+		seen[tid] = true
+		// Setting the current mask again changes nothing
+		return unix.SchedSetaffinity(tid, &set)
+	})
+	if err != nil || len(failed) > 0 {
+		t.Fatalf("forEachThread: %v %v", err, failed)
+	}
+	if applied < 5 || !seen[os.Getpid()] {
+		t.Errorf("applied to %d threads %v, want the main thread and at least 4 more", applied, seen)
+	}
+}
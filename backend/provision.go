@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Provisioning Jobs ---
+//
+// A job is a list of named steps, each with an optional compensating action.
+// Steps run in order; when one fails the completed steps are undone in
+// reverse so the job ends either fully applied or fully rolled back. Progress
+// is published to subscribers and streamed to clients as server-sent events.
+
+const (
+	maxProvisionJobs    = 100
+	provisionJobTimeout = 15 * time.Minute
+)
+
+type ProvisionStepState struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"` // pending, running, done, failed, undone, undo_failed
+	Error      string     `json:"error,omitempty"`
+	Details    string     `json:"details,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ProvisionJobState struct {
+	ID         string               `json:"id"`
+	Kind       string               `json:"kind"`
+	Target     string               `json:"target"`
+	Author     string               `json:"author"`
+	Status     string               `json:"status"` // pending, running, rolling_back, succeeded, rolled_back, rollback_failed
+	Error      string               `json:"error,omitempty"`
+	FailedStep string               `json:"failed_step,omitempty"`
+	Steps      []ProvisionStepState `json:"steps"`
+	CreatedAt  time.Time            `json:"created_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+}
+
+type ProvisionEvent struct {
+	Type string            `json:"type"` // "step" or "job"
+	Step string            `json:"step,omitempty"`
+	Job  ProvisionJobState `json:"job"`
+}
+
+// provisionStep is one unit of work. Do returns details (command output) to
+// show to the user; Undo is only called if Do succeeded.
+type provisionStep struct {
+	Name string
+	Do   func(ctx context.Context) (string, error)
+	Undo func() error
+}
+
+type provisionJob struct {
+	mu        sync.Mutex
+	state     ProvisionJobState
+	steps     []provisionStep
+	afterUndo func() error
+	subs      map[chan ProvisionEvent]struct{}
+	done      chan struct{}
+}
+
+var (
+	provisionJobsMu sync.Mutex
+	provisionJobs   = make(map[string]*provisionJob)
+)
+
+func newProvisionJob(kind, target, author string, steps []provisionStep) *provisionJob {
+	id := make([]byte, 8)
+	rand.Read(id)
+	job := &provisionJob{
+		state: ProvisionJobState{
+			ID: hex.EncodeToString(id), Kind: kind, Target: target, Author: author,
+			Status: "pending", CreatedAt: time.Now(),
+		},
+		steps: steps,
+		subs:  make(map[chan ProvisionEvent]struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, s := range steps {
+		job.state.Steps = append(job.state.Steps, ProvisionStepState{Name: s.Name, Status: "pending"})
+	}
+
+	provisionJobsMu.Lock()
+	provisionJobs[job.state.ID] = job
+	pruneProvisionJobs()
+	provisionJobsMu.Unlock()
+	return job
+}
+
+// pruneProvisionJobs drops the oldest finished jobs. Caller holds provisionJobsMu.
+func pruneProvisionJobs() {
+	if len(provisionJobs) <= maxProvisionJobs {
+		return
+	}
+	var finished []*provisionJob
+	for _, job := range provisionJobs {
+		if s := job.snapshot(); s.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].state.CreatedAt.Before(finished[j].state.CreatedAt) })
+	for i := 0; i < len(finished) && len(provisionJobs) > maxProvisionJobs; i++ {
+		delete(provisionJobs, finished[i].state.ID)
+	}
+}
+
+func getProvisionJob(id string) *provisionJob {
+	provisionJobsMu.Lock()
+	defer provisionJobsMu.Unlock()
+	return provisionJobs[id]
+}
+
+func (j *provisionJob) snapshot() ProvisionJobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.state
+	s.Steps = append([]ProvisionStepState(nil), j.state.Steps...)
+	return s
+}
+
+// update changes the state under the lock and notifies subscribers.
+func (j *provisionJob) update(eventType, step string, fn func(s *ProvisionJobState)) {
+	j.mu.Lock()
+	fn(&j.state)
+	s := j.state
+	s.Steps = append([]ProvisionStepState(nil), j.state.Steps...)
+	event := ProvisionEvent{Type: eventType, Step: step, Job: s}
+	for ch := range j.subs {
+		select {
+		case ch <- event:
+		default: // Slow subscriber; it still gets the final state from Wait
+		}
+	}
+	j.mu.Unlock()
+}
+
+func (j *provisionJob) subscribe() (chan ProvisionEvent, func()) {
+	ch := make(chan ProvisionEvent, 64)
+	j.mu.Lock()
+	j.subs[ch] = struct{}{}
+	j.mu.Unlock()
+	return ch, func() {
+		j.mu.Lock()
+		delete(j.subs, ch)
+		j.mu.Unlock()
+	}
+}
+
+func (j *provisionJob) setStep(i int, fn func(s *ProvisionStepState)) {
+	j.update("step", j.steps[i].Name, func(s *ProvisionJobState) { fn(&s.Steps[i]) })
+}
+
+// start runs the job in the background.
+func (j *provisionJob) start() {
+	go j.run()
+}
+
+func (j *provisionJob) run() {
+	defer close(j.done)
+	ctx, cancel := context.WithTimeout(context.Background(), provisionJobTimeout)
+	defer cancel()
+
+	finish := func(status, failedStep, errMsg string) {
+		now := time.Now()
+		j.update("job", "", func(s *ProvisionJobState) {
+			s.Status, s.FailedStep, s.Error, s.FinishedAt = status, failedStep, errMsg, &now
+		})
+	}
+
+	j.update("job", "", func(s *ProvisionJobState) { s.Status = "running" })
+
+	failed := -1
+	var failure error
+	for i, step := range j.steps {
+		now := time.Now()
+		j.setStep(i, func(s *ProvisionStepState) { s.Status, s.StartedAt = "running", &now })
+		details, err := step.Do(ctx)
+		end := time.Now()
+		if err != nil {
+			j.setStep(i, func(s *ProvisionStepState) {
+				s.Status, s.Error, s.Details, s.FinishedAt = "failed", err.Error(), details, &end
+			})
+			failed, failure = i, err
+			break
+		}
+		j.setStep(i, func(s *ProvisionStepState) { s.Status, s.Details, s.FinishedAt = "done", details, &end })
+	}
+	if failed < 0 {
+		finish("succeeded", "", "")
+		return
+	}
+
+	j.update("job", "", func(s *ProvisionJobState) { s.Status = "rolling_back" })
+	undoFailed := false
+	for i := failed - 1; i >= 0; i-- {
+		if j.steps[i].Undo == nil {
+			continue
+		}
+		if err := j.steps[i].Undo(); err != nil {
+			undoFailed = true
+			j.setStep(i, func(s *ProvisionStepState) { s.Status, s.Error = "undo_failed", err.Error() })
+			continue
+		}
+		j.setStep(i, func(s *ProvisionStepState) { s.Status = "undone" })
+	}
+	if j.afterUndo != nil {
+		if err := j.afterUndo(); err != nil {
+			undoFailed = true
+			failure = fmt.Errorf("%v; after rollback: %v", failure, err)
+		}
+	}
+	if undoFailed {
+		finish("rollback_failed", j.steps[failed].Name, failure.Error())
+		return
+	}
+	finish("rolled_back", j.steps[failed].Name, failure.Error())
+}
+
+// wait blocks until the job is finished and returns its final state.
+func (j *provisionJob) wait() ProvisionJobState {
+	<-j.done
+	return j.snapshot()
+}
+
+// --- Site Provisioning ---
+
+// newCreateSiteJob builds the steps of createSite. req must be validated and
+// config rendered from it.
+func newCreateSiteJob(req CreateSiteRequest, config []byte, author string) *provisionJob {
+	availablePath := filepath.Join(nginxPath, req.Domain)
+	enabledPath := filepath.Join(nginxEnabledPath, req.Domain)
+	configChanges := []nginxChange{{Path: availablePath, Content: config}}
+	liveChanged := false
+	var undoSnapshots []nginxChange
+
+	// commit applies changes to the live tree, remembering how to restore them
+	commit := func(changes []nginxChange) error {
+		var snapshots []nginxChange
+		for _, ch := range changes {
+			snap, err := snapshotNginxPath(ch.Path)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snap)
+		}
+		if err := commitNginxChanges(changes); err != nil {
+			return err
+		}
+		liveChanged = true
+		undoSnapshots = append(undoSnapshots, snapshots...)
+		return nil
+	}
+	restore := func(count int) func() error {
+		return func() error {
+			start := len(undoSnapshots) - count
+			if start < 0 {
+				start = 0
+			}
+			batch := undoSnapshots[start:]
+			undoSnapshots = undoSnapshots[:start]
+			// Restore in reverse so the symlink goes before its target
+			reversed := make([]nginxChange, 0, len(batch))
+			for i := len(batch) - 1; i >= 0; i-- {
+				reversed = append(reversed, batch[i])
+			}
+			return commitNginxChanges(reversed)
+		}
+	}
+
+	// Only the steps that stage, validate, commit or reload take the nginx
+	// lock; DNS and certificate steps can run for minutes.
+	steps := []provisionStep{{
+		Name: "preflight",
+		Do: func(ctx context.Context) (string, error) {
+			if _, err := os.Lstat(availablePath); err == nil {
+				return "", errors.New("site configuration already exists")
+			}
+			if req.Hardening != nil {
+				hardening, err := hardeningChanges(req.Domain, req.Hardening)
+				if err != nil {
+					return "", fmt.Errorf("failed to generate hardening config: %w", err)
+				}
+				configChanges = append(configChanges, hardening...)
+			}
+			return "", nil
+		},
+	}, {
+		Name: "validate",
+		Do: withNginxLock(func(ctx context.Context) (string, error) {
+			changes := append(append([]nginxChange{}, configChanges...), nginxChange{Path: enabledPath, Symlink: availablePath})
+			return validateStagedNginx(changes)
+		}),
+	}, {
+		Name: "write_config",
+		Do: withNginxLock(func(ctx context.Context) (string, error) {
+			// The lock was released since preflight
+			if _, err := os.Lstat(availablePath); err == nil {
+				return "", errors.New("site configuration already exists")
+			}
+			return "", commit(configChanges)
+		}),
+		Undo: undoWithNginxLock(func() error { return restore(len(configChanges))() }),
+	}, {
+		Name: "enable_site",
+		Do: withNginxLock(func(ctx context.Context) (string, error) {
+			return "", commit([]nginxChange{{Path: enabledPath, Symlink: availablePath}})
+		}),
+		Undo: undoWithNginxLock(func() error { return restore(1)() }),
+	}, {
+		Name: "reload",
+		Do: withNginxLock(func(ctx context.Context) (string, error) {
+			return "", nginxReload()
+		}),
+	}}
+
+	if req.DNS != nil {
+		steps = append(steps, siteDNSSteps(req.Domain, req.DNS, author)...)
+	}
+	if req.SSL {
+		steps = append(steps, certificateSteps(req, availablePath, commit, restore)...)
+	}
+
+	steps = append(steps, provisionStep{
+		Name: "record",
+		Do: func(ctx context.Context) (string, error) {
+			final, err := os.ReadFile(availablePath)
+			if err != nil {
+				final = config
+			}
+			if _, err := recordNginxRevision(req.Domain, nil, final, author, "Site created", "create"); err != nil {
+				fmt.Printf("Nginx: failed to record revision for %s: %v\n", req.Domain, err)
+			}
+			if req.Hardening != nil {
+				if err := saveHardeningSettings(req.Domain, req.Hardening); err != nil {
+					fmt.Printf("Nginx: failed to save hardening settings for %s: %v\n", req.Domain, err)
+				}
+			}
+			return "", nil
+		},
+	})
+
+	job := newProvisionJob("create-site", req.Domain, author, steps)
+	job.afterUndo = func() error {
+		if !liveChanged {
+			return nil
+		}
+		return undoWithNginxLock(nginxReload)()
+	}
+	return job
+}
+
+// withNginxLock holds the nginx config lock while a step runs.
+func withNginxLock(do func(ctx context.Context) (string, error)) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		unlock, err := lockNginxConfig()
+		if err != nil {
+			return "", fmt.Errorf("failed to acquire nginx lock: %w", err)
+		}
+		defer unlock()
+		return do(ctx)
+	}
+}
+
+// undoWithNginxLock is withNginxLock for Undo functions.
+func undoWithNginxLock(undo func() error) func() error {
+	return func() error {
+		unlock, err := lockNginxConfig()
+		if err != nil {
+			return fmt.Errorf("failed to acquire nginx lock: %w", err)
+		}
+		defer unlock()
+		return undo()
+	}
+}
+
+// certificateSteps obtains and installs a certificate with certbot (HTTP-01)
+// or the built-in ACME client (DNS-01).
+func certificateSteps(req CreateSiteRequest, sitePath string, commit func([]nginxChange) error, restore func(int) func() error) []provisionStep {
+	if req.SSLMethod == "dns" {
+		var cert *ACMECertificate
+		existed := false
+		return []provisionStep{{
+			Name: "certificate",
+			Do: func(ctx context.Context) (string, error) {
+				_, err := os.Stat(acmeCertDir(acmeCertName([]string{req.Domain})))
+				existed = err == nil
+				cert, err = issueACMECertificate(ctx, []string{req.Domain}, "")
+				return "", err
+			},
+			Undo: func() error {
+				if existed {
+					return nil
+				}
+				return os.RemoveAll(acmeCertDir(cert.Name))
+			},
+		}, {
+			Name: "install_certificate",
+			Do: withNginxLock(func(ctx context.Context) (string, error) {
+				current, err := os.ReadFile(sitePath)
+				if err != nil {
+					return "", err
+				}
+				updated, err := siteTLSContent(current, cert.CertPath, cert.KeyPath)
+				if err != nil {
+					return "", err
+				}
+				change := []nginxChange{{Path: sitePath, Content: updated}}
+				if output, err := validateStagedNginx(change); err != nil {
+					return output, err
+				}
+				return "", commit(change)
+			}),
+			Undo: undoWithNginxLock(func() error { return restore(1)() }),
+		}, {
+			Name: "reload_tls",
+			Do: withNginxLock(func(ctx context.Context) (string, error) {
+				return "", nginxReload()
+			}),
+		}}
+	}
+
+	certDir := filepath.Join(letsencryptLiveDir, req.Domain)
+	existed := false
+	var siteSnapshot nginxChange
+	// certbot edits the site and reloads nginx on its own, and can fail after
+	// doing either, so the step cleans up after itself as well. It holds the
+	// nginx lock throughout since certbot writes to the live tree.
+	cleanup := func() error {
+		if err := commitNginxChanges([]nginxChange{siteSnapshot}); err != nil {
+			return err
+		}
+		if _, err := os.Stat(certDir); existed || err != nil {
+			return nil
+		}
+		if out, err := exec.Command("certbot", "delete", "--cert-name", req.Domain, "--non-interactive").CombinedOutput(); err != nil {
+			return fmt.Errorf("certbot delete failed: %v: %s", err, out)
+		}
+		return nil
+	}
+	return []provisionStep{{
+		Name: "certificate",
+		Do: withNginxLock(func(ctx context.Context) (string, error) {
+			_, err := os.Stat(certDir)
+			existed = err == nil
+			if siteSnapshot, err = snapshotNginxPath(sitePath); err != nil {
+				return "", err
+			}
+			out, err := exec.CommandContext(ctx, "certbot", append([]string{"--nginx", "-d", req.Domain, "--non-interactive", "--agree-tos", "--redirect"}, certbotEmailArgs()...)...).CombinedOutput()
+			if err != nil {
+				if cleanupErr := cleanup(); cleanupErr != nil {
+					return string(out), fmt.Errorf("%v (cleanup: %v)", err, cleanupErr)
+				}
+			}
+			return string(out), err
+		}),
+		Undo: undoWithNginxLock(cleanup),
+	}}
+}
+
+// --- Provisioning Handlers ---
+
+func getProvisionJobHandler(c *gin.Context) {
+	job := getProvisionJob(c.Param("id"))
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job.snapshot())
+}
+
+func listProvisionJobs(c *gin.Context) {
+	provisionJobsMu.Lock()
+	jobs := make([]ProvisionJobState, 0, len(provisionJobs))
+	for _, job := range provisionJobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	provisionJobsMu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	c.JSON(http.StatusOK, jobs)
+}
+
+// streamProvisionJob sends the current state, then every change, as
+// server-sent events until the job finishes or the client disconnects.
+func streamProvisionJob(c *gin.Context, job *provisionJob) {
+	events, unsubscribe := job.subscribe()
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("job", ProvisionEvent{Type: "job", Job: job.snapshot()})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.Type, event)
+			return true
+		case <-job.done:
+			// Drain what was published before the job finished
+			for {
+				select {
+				case event := <-events:
+					c.SSEvent(event.Type, event)
+				default:
+					c.SSEvent("done", ProvisionEvent{Type: "done", Job: job.snapshot()})
+					return false
+				}
+			}
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func provisionJobEvents(c *gin.Context) {
+	job := getProvisionJob(c.Param("id"))
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	streamProvisionJob(c, job)
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Public IP Discovery ---
+//
+// Public IP detection runs in the background so that /api/network never blocks
+// on outbound traffic. Resolvers are tried in order until one answers for each
+// address family:
+//
+//	https://...            plain-text "what is my IP" HTTP services
+//	stun:host:port         STUN binding request (RFC 5389)
+//	dns:opendns|dns:google DNS based lookups (myip.opendns.com, o-o.myaddr.l.google.com)
+
+const (
+	defaultPublicIPResolvers = "https://api.ipify.org,https://icanhazip.com,https://ifconfig.co/ip,stun:stun.l.google.com:19302,stun:stun.cloudflare.com:3478,dns:opendns,dns:google"
+	defaultPublicIPInterval  = 10 * time.Minute
+	defaultPublicIPTimeout   = 5 * time.Second
+)
+
+type PublicIPState struct {
+	IPv4        string    `json:"ipv4"`
+	IPv6        string    `json:"ipv6"`
+	SourceV4    string    `json:"source_v4,omitempty"`
+	SourceV6    string    `json:"source_v6,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`   // Last time at least one family resolved
+	LastAttempt time.Time `json:"last_attempt"` // Last refresh, successful or not
+	LastError   string    `json:"last_error,omitempty"`
+	Resolvers   []string  `json:"resolvers"`
+}
+
+type publicIPResolver struct {
+	spec    string
+	resolve func(ctx context.Context, family string) (stdnet.IP, error)
+}
+
+type publicIPService struct {
+	mu        sync.RWMutex
+	state     PublicIPState
+	resolvers []publicIPResolver
+	timeout   time.Duration
+	interval  time.Duration
+	refreshMu sync.Mutex // Serializes refreshes triggered by the ticker and the API
+	watchers  []chan struct{}
+}
+
+var publicIP *publicIPService
+
+func newPublicIPService(specs []string, interval, timeout time.Duration) (*publicIPService, error) {
+	svc := &publicIPService{interval: interval, timeout: timeout}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := parsePublicIPResolver(spec, timeout)
+		if err != nil {
+			return nil, err
+		}
+		svc.resolvers = append(svc.resolvers, r)
+		svc.state.Resolvers = append(svc.state.Resolvers, spec)
+	}
+	if len(svc.resolvers) == 0 {
+		return nil, errors.New("no public IP resolvers configured")
+	}
+	return svc, nil
+}
+
+// publicIPConfigFromEnv reads PUBLIC_IP_RESOLVERS, PUBLIC_IP_REFRESH_INTERVAL and PUBLIC_IP_TIMEOUT.
+func publicIPConfigFromEnv() (specs []string, interval, timeout time.Duration) {
+	resolvers := os.Getenv("PUBLIC_IP_RESOLVERS")
+	if resolvers == "" {
+		resolvers = defaultPublicIPResolvers
+	}
+	interval = defaultPublicIPInterval
+	if d, err := time.ParseDuration(os.Getenv("PUBLIC_IP_REFRESH_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	timeout = defaultPublicIPTimeout
+	if d, err := time.ParseDuration(os.Getenv("PUBLIC_IP_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	return strings.Split(resolvers, ","), interval, timeout
+}
+
+func parsePublicIPResolver(spec string, timeout time.Duration) (publicIPResolver, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return publicIPResolver{spec: spec, resolve: func(ctx context.Context, family string) (stdnet.IP, error) {
+			return resolveViaHTTP(ctx, spec, family, timeout)
+		}}, nil
+	case strings.HasPrefix(spec, "stun:"):
+		addr := strings.TrimPrefix(spec, "stun:")
+		if _, _, err := stdnet.SplitHostPort(addr); err != nil {
+			addr = stdnet.JoinHostPort(addr, "3478")
+		}
+		return publicIPResolver{spec: spec, resolve: func(ctx context.Context, family string) (stdnet.IP, error) {
+			return resolveViaSTUN(ctx, addr, family)
+		}}, nil
+	case spec == "dns:opendns":
+		return publicIPResolver{spec: spec, resolve: resolveViaOpenDNS}, nil
+	case spec == "dns:google":
+		return publicIPResolver{spec: spec, resolve: resolveViaGoogleDNS}, nil
+	}
+	return publicIPResolver{}, fmt.Errorf("unsupported public IP resolver %q", spec)
+}
+
+// Start performs an initial refresh and then refreshes on the configured interval.
+func (s *publicIPService) Start() {
+	go func() {
+		s.Refresh()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.Refresh()
+		}
+	}()
+}
+
+// Refresh resolves both address families. Previously known addresses are kept
+// when every resolver fails, so a temporary outage does not blank the cache.
+func (s *publicIPService) Refresh() PublicIPState {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	var (
+		wg         sync.WaitGroup
+		ipv4, ipv6 stdnet.IP
+		src4, src6 string
+		err4, err6 error
+	)
+	wg.Add(2)
+	go func() { defer wg.Done(); ipv4, src4, err4 = s.resolveFamily("4") }()
+	go func() { defer wg.Done(); ipv6, src6, err6 = s.resolveFamily("6") }()
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := s.state
+	now := time.Now()
+	s.state.LastAttempt = now
+	if ipv4 != nil {
+		s.state.IPv4, s.state.SourceV4 = ipv4.String(), src4
+	}
+	if ipv6 != nil {
+		s.state.IPv6, s.state.SourceV6 = ipv6.String(), src6
+	}
+	if ipv4 != nil || ipv6 != nil {
+		s.state.UpdatedAt = now
+	}
+	// IPv6 is often simply unavailable; only report an error when IPv4 failed too.
+	s.state.LastError = ""
+	if ipv4 == nil && ipv6 == nil {
+		s.state.LastError = fmt.Sprintf("ipv4: %v; ipv6: %v", err4, err6)
+	} else if ipv4 == nil {
+		s.state.LastError = fmt.Sprintf("ipv4: %v", err4)
+	}
+	if s.state.IPv4 != before.IPv4 || s.state.IPv6 != before.IPv6 {
+		for _, ch := range s.watchers {
+			select {
+			case ch <- struct{}{}:
+			default: // A notification is already pending
+			}
+		}
+	}
+	return s.state
+}
+
+// Watch returns a channel that receives a value whenever a refresh finds a
+// different address. Notifications are coalesced; read State for the value.
+func (s *publicIPService) Watch() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	s.watchers = append(s.watchers, ch)
+	return ch
+}
+
+func (s *publicIPService) resolveFamily(family string) (stdnet.IP, string, error) {
+	// All resolvers are queried at once under one deadline; the first public
+	// address wins and cancels the others.
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	type answer struct {
+		ip   stdnet.IP
+		spec string
+		err  error
+	}
+	answers := make(chan answer, len(s.resolvers))
+	for _, r := range s.resolvers {
+		go func(r publicIPResolver) {
+			ip, err := r.resolve(ctx, family)
+			if err == nil && !(ipMatchesFamily(ip, family) && isPublicIP(ip)) {
+				err = fmt.Errorf("unexpected address %v", ip)
+			}
+			answers <- answer{ip, r.spec, err}
+		}(r)
+	}
+
+	var errs []string
+	for range s.resolvers {
+		a := <-answers
+		if a.err == nil {
+			return a.ip, a.spec, nil
+		}
+		errs = append(errs, a.spec+": "+a.err.Error())
+	}
+	return nil, "", errors.New(strings.Join(errs, "; "))
+}
+
+// State returns a copy of the cached public IP information.
+func (s *publicIPService) State() PublicIPState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := s.state
+	state.Resolvers = append([]string(nil), s.state.Resolvers...)
+	return state
+}
+
+func ipMatchesFamily(ip stdnet.IP, family string) bool {
+	if ip == nil {
+		return false
+	}
+	if family == "4" {
+		return ip.To4() != nil
+	}
+	return ip.To4() == nil && ip.To16() != nil
+}
+
+func isPublicIP(ip stdnet.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+func familyNetwork(proto, family string) string {
+	return proto + family // tcp4, tcp6, udp4, udp6
+}
+
+func resolveViaHTTP(ctx context.Context, url, family string, timeout time.Duration) (stdnet.IP, error) {
+	dialer := &stdnet.Dialer{Timeout: timeout}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (stdnet.Conn, error) {
+				return dialer.DialContext(ctx, familyNetwork("tcp", family), addr)
+			},
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := stdnet.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, errors.New("response is not an IP address")
+	}
+	return ip, nil
+}
+
+// STUN (RFC 5389) constants
+const (
+	stunMagicCookie       = 0x2112A442
+	stunBindingRequest    = 0x0001
+	stunBindingSuccess    = 0x0101
+	stunAttrMappedAddress = 0x0001
+	stunAttrXorMappedAddr = 0x0020
+	stunHeaderLength      = 20
+)
+
+func resolveViaSTUN(ctx context.Context, addr, family string) (stdnet.IP, error) {
+	var dialer stdnet.Dialer
+	conn, err := dialer.DialContext(ctx, familyNetwork("udp", family), addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:4], 0)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	txID := req[8:20]
+	if _, err := rand.Read(txID); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseSTUNResponse(buf[:n], txID)
+}
+
+func parseSTUNResponse(msg, txID []byte) (stdnet.IP, error) {
+	if len(msg) < stunHeaderLength {
+		return nil, errors.New("short STUN response")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingSuccess {
+		return nil, errors.New("STUN binding failed")
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || string(msg[8:20]) != string(txID) {
+		return nil, errors.New("STUN transaction mismatch")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if stunHeaderLength+length > len(msg) {
+		return nil, errors.New("truncated STUN response")
+	}
+
+	var mapped stdnet.IP
+	attrs := msg[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunAttrXorMappedAddr:
+			if ip := decodeSTUNAddress(value, msg[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunAttrMappedAddress:
+			mapped = decodeSTUNAddress(value, nil)
+		}
+		// Attributes are padded to 4-byte boundaries
+		advance := 4 + (attrLen+3)&^3
+		if advance > len(attrs) {
+			break
+		}
+		attrs = attrs[advance:]
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, errors.New("no mapped address in STUN response")
+}
+
+// decodeSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value. xorKey is the magic
+// cookie followed by the transaction ID, or nil for the plain variant.
+func decodeSTUNAddress(value, xorKey []byte) stdnet.IP {
+	if len(value) < 4 {
+		return nil
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = stdnet.IPv4len
+	case 0x02:
+		size = stdnet.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+	ip := make(stdnet.IP, size)
+	copy(ip, value[4:4+size])
+	if xorKey != nil {
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+	return ip
+}
+
+// dnsResolverFor returns a resolver that only talks to the given nameserver.
+func dnsResolverFor(server, family string) *stdnet.Resolver {
+	return &stdnet.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (stdnet.Conn, error) {
+			var dialer stdnet.Dialer
+			return dialer.DialContext(ctx, familyNetwork("udp", family), stdnet.JoinHostPort(server, "53"))
+		},
+	}
+}
+
+func resolveViaOpenDNS(ctx context.Context, family string) (stdnet.IP, error) {
+	server, network := "208.67.222.222", "ip4"
+	if family == "6" {
+		server, network = "2620:119:35::35", "ip6"
+	}
+	ips, err := dnsResolverFor(server, family).LookupIP(ctx, network, "myip.opendns.com")
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("empty answer")
+	}
+	return ips[0], nil
+}
+
+func resolveViaGoogleDNS(ctx context.Context, family string) (stdnet.IP, error) {
+	server := "216.239.32.10"
+	if family == "6" {
+		server = "2001:4860:4802:32::a"
+	}
+	txts, err := dnsResolverFor(server, family).LookupTXT(ctx, "o-o.myaddr.l.google.com")
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		if ip := stdnet.ParseIP(strings.Trim(txt, "\"")); ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, errors.New("no address in TXT answer")
+}
+
+// --- Public IP Handlers ---
+
+func getPublicIP(c *gin.Context) {
+	c.JSON(http.StatusOK, publicIP.State())
+}
+
+func refreshPublicIP(c *gin.Context) {
+	state := publicIP.Refresh()
+	if state.IPv4 == "" && state.IPv6 == "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Public IP could not be determined", "details": state.LastError})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
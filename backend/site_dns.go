@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"sort"
+	"strings"
+	"time"
+
+	"system-manager/dnsprovider"
+)
+
+// --- Site DNS Records ---
+//
+// createSite can point the domain at this server before requesting a
+// certificate: it creates or updates the Cloudflare A/AAAA records with the
+// addresses found by the public IP service, then waits until the zone's
+// authoritative nameservers serve them.
+
+const dnsUndoTimeout = 30 * time.Second
+
+type SiteDNSOptions struct {
+	Zone    string `json:"zone,omitempty"` // Zone ID or name, selected from the domain when empty
+	Proxied bool   `json:"proxied"`
+	TTL     int    `json:"ttl,omitempty"` // Default 1 (auto)
+	// Families restricts the records to "A" and/or "AAAA". Defaults to every
+	// family the public IP service knows an address for.
+	Families []string `json:"families,omitempty"`
+}
+
+func (o *SiteDNSOptions) Validate() error {
+	if CloudflareAPIToken == "" {
+		return errors.New("Cloudflare credentials not configured")
+	}
+	if o.TTL != 0 && o.TTL != 1 && (o.TTL < 60 || o.TTL > 86400) {
+		return errors.New("dns.ttl must be 1 (auto) or between 60 and 86400")
+	}
+	for _, f := range o.Families {
+		if f != "A" && f != "AAAA" {
+			return fmt.Errorf("dns.families: unsupported record type %q", f)
+		}
+	}
+	return nil
+}
+
+// siteAddresses maps record type to the server's public address.
+func siteAddresses(families []string) (map[string]string, error) {
+	state := publicIP.State()
+	if state.IPv4 == "" && state.IPv6 == "" {
+		state = publicIP.Refresh()
+	}
+	if len(families) == 0 {
+		families = []string{"A", "AAAA"}
+	}
+	addrs := make(map[string]string)
+	for _, f := range families {
+		if f == "A" && state.IPv4 != "" {
+			addrs["A"] = state.IPv4
+		}
+		if f == "AAAA" && state.IPv6 != "" {
+			addrs["AAAA"] = state.IPv6
+		}
+	}
+	if len(addrs) == 0 {
+		msg := "public IP address is unknown"
+		if state.LastError != "" {
+			msg += ": " + state.LastError
+		}
+		return nil, errors.New(msg)
+	}
+	return addrs, nil
+}
+
+// siteDNSSteps creates or updates the records and waits for propagation. The
+// undo restores the previous records (or deletes the ones it created). Every
+// change and its undo are added to the DNS record history under author.
+func siteDNSSteps(domain string, opts *SiteDNSOptions, author string) []provisionStep {
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = 1
+	}
+	var addrs map[string]string
+	var undo []func(ctx context.Context) error
+	rollback := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsUndoTimeout)
+		defer cancel()
+		var errs []string
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](ctx); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		undo = nil
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	apply := func(ctx context.Context) (string, error) {
+		var err error
+		if addrs, err = siteAddresses(opts.Families); err != nil {
+			return "", err
+		}
+		provider := cloudflareProvider{}
+		zoneID, err := resolveCloudflareZone(ctx, opts.Zone, domain)
+		if err != nil {
+			return "", err
+		}
+		var details []string
+		for _, recordType := range []string{"A", "AAAA"} {
+			content, ok := addrs[recordType]
+			if !ok {
+				continue
+			}
+			existing, err := provider.ListRecords(ctx, zoneID, dnsprovider.Filter{Type: recordType, Name: domain})
+			if err != nil {
+				return strings.Join(details, "\n"), err
+			}
+			record := dnsprovider.Record{Type: recordType, Name: domain, Content: content, Proxied: opts.Proxied, TTL: ttl}
+			// Keep the record that already matches, or else the first one, and
+			// delete the rest so no stale address stays in rotation.
+			keep := 0
+			for i, r := range existing {
+				if r.Content == content && r.Proxied == opts.Proxied {
+					keep = i
+					break
+				}
+			}
+			switch {
+			case len(existing) == 0:
+				created, err := provider.CreateRecord(ctx, zoneID, record)
+				if err != nil {
+					return strings.Join(details, "\n"), err
+				}
+				change := logDNSChange(provider.Name(), zoneID, "create", "site", nil, &created, author)
+				undo = append(undo, func(ctx context.Context) error {
+					if err := provider.DeleteRecord(ctx, zoneID, created.ID); err != nil {
+						return err
+					}
+					logDNSUndo(provider.Name(), zoneID, "undo", "site", change.ID, &created, nil, author)
+					return nil
+				})
+				details = append(details, fmt.Sprintf("created %s %s -> %s", recordType, domain, content))
+			case existing[keep].Content == content && existing[keep].Proxied == opts.Proxied:
+				details = append(details, fmt.Sprintf("%s %s already points to %s", recordType, domain, content))
+			default:
+				previous := existing[keep]
+				updated, err := provider.UpdateRecord(ctx, zoneID, previous.ID, record)
+				if err != nil {
+					return strings.Join(details, "\n"), err
+				}
+				change := logDNSChange(provider.Name(), zoneID, "update", "site", &previous, &updated, author)
+				undo = append(undo, func(ctx context.Context) error {
+					restored, err := provider.UpdateRecord(ctx, zoneID, previous.ID, writableRecord(previous))
+					if err != nil {
+						return err
+					}
+					logDNSUndo(provider.Name(), zoneID, "undo", "site", change.ID, &updated, &restored, author)
+					return nil
+				})
+				details = append(details, fmt.Sprintf("updated %s %s: %s -> %s", recordType, domain, previous.Content, content))
+			}
+			for i, extra := range existing {
+				if i == keep {
+					continue
+				}
+				if err := provider.DeleteRecord(ctx, zoneID, extra.ID); err != nil {
+					return strings.Join(details, "\n"), err
+				}
+				change := logDNSChange(provider.Name(), zoneID, "delete", "site", &extra, nil, author)
+				undo = append(undo, func(ctx context.Context) error {
+					recreated, err := provider.CreateRecord(ctx, zoneID, writableRecord(extra))
+					if err != nil {
+						return err
+					}
+					logDNSUndo(provider.Name(), zoneID, "undo", "site", change.ID, nil, &recreated, author)
+					return nil
+				})
+				details = append(details, fmt.Sprintf("deleted %s %s -> %s", recordType, domain, extra.Content))
+			}
+		}
+		return strings.Join(details, "\n"), nil
+	}
+
+	return []provisionStep{{
+		Name: "dns_record",
+		Do: func(ctx context.Context) (string, error) {
+			// A failure after the first record leaves nothing behind
+			details, err := apply(ctx)
+			if err != nil {
+				if undoErr := rollback(); undoErr != nil {
+					return details, fmt.Errorf("%v (cleanup: %v)", err, undoErr)
+				}
+			}
+			return details, err
+		},
+		Undo: rollback,
+	}, {
+		Name: "dns_propagation",
+		Do: func(ctx context.Context) (string, error) {
+			// Proxied records are answered with Cloudflare's addresses
+			expect := addrs
+			if opts.Proxied {
+				expect = nil
+			}
+			return waitForAuthoritative(ctx, domain, addrs, expect)
+		},
+	}}
+}
+
+// --- Authoritative Lookups ---
+
+// authoritativeNameservers finds the zone that contains name by walking up
+// its labels and returns the addresses of that zone's nameservers.
+func authoritativeNameservers(ctx context.Context, name string) (zone string, servers []string, err error) {
+	zone = strings.TrimSuffix(name, ".")
+	for strings.Contains(zone, ".") {
+		nss, err := stdnet.DefaultResolver.LookupNS(ctx, zone)
+		if err == nil && len(nss) > 0 {
+			for _, ns := range nss {
+				ips, err := stdnet.DefaultResolver.LookupIP(ctx, "ip4", ns.Host)
+				if err != nil {
+					continue
+				}
+				for _, ip := range ips {
+					servers = append(servers, ip.String())
+				}
+			}
+			if len(servers) == 0 {
+				return zone, nil, fmt.Errorf("could not resolve the nameservers of %s", zone)
+			}
+			return zone, servers, nil
+		}
+		_, zone, _ = strings.Cut(zone, ".")
+	}
+	return "", nil, fmt.Errorf("no authoritative nameservers found for %s", name)
+}
+
+// waitForAuthoritative polls the authoritative nameservers until every one
+// answers each record type in types. When expect is set the answer must
+// contain the expected address; otherwise any answer will do.
+func waitForAuthoritative(ctx context.Context, name string, types, expect map[string]string) (string, error) {
+	timeout := dnsPropagationTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	zone, servers, err := authoritativeNameservers(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	var recordTypes []string
+	for t := range types {
+		recordTypes = append(recordTypes, t)
+	}
+	sort.Strings(recordTypes)
+
+	pending := servers
+	for {
+		var remaining []string
+		for _, server := range pending {
+			if !authoritativeAnswers(ctx, server, name, recordTypes, expect) {
+				remaining = append(remaining, server)
+			}
+		}
+		if len(remaining) == 0 {
+			return fmt.Sprintf("%s visible on the %s nameservers (%s)", name, zone, strings.Join(servers, ", ")), nil
+		}
+		pending = remaining
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s not visible on %s after %s", name, strings.Join(pending, ", "), timeout)
+		case <-time.After(propagationPollInterval):
+		}
+	}
+}
+
+func authoritativeAnswers(ctx context.Context, server, name string, recordTypes []string, expect map[string]string) bool {
+	resolver := dnsResolverFor(server, "4")
+	for _, t := range recordTypes {
+		network := "ip4"
+		if t == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, name)
+		if err != nil || len(ips) == 0 {
+			return false
+		}
+		want, ok := expect[t]
+		if !ok {
+			continue
+		}
+		found := false
+		for _, ip := range ips {
+			if ip.Equal(stdnet.ParseIP(want)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,328 @@
+// Package zonefile reads and writes DNS master files (RFC 1035 section 5), the
+// format used by BIND. Only what is needed to move records between providers
+// is supported: $ORIGIN, $TTL, relative names, omitted owners, parentheses
+// and quoted strings. $INCLUDE and $GENERATE are rejected.
+package zonefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Record is one resource record. Name is fully qualified without the trailing
+// dot; RData holds the fields after the type, with quoted strings unquoted.
+// Origin is the $ORIGIN in effect, which relative names in RData are
+// resolved against (see Absolute).
+type Record struct {
+	Name    string
+	Origin  string
+	TTL     uint32
+	Class   string
+	Type    string
+	RData   []string
+	Comment string
+	Line    int
+}
+
+// ParseError reports a syntax error with the line it was found on.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+var classes = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// Parse reads a zone file. origin is used until a $ORIGIN directive changes
+// it; defaultTTL applies to records without a TTL when there is no $TTL.
+func Parse(r io.Reader, origin string, defaultTTL uint32) ([]Record, error) {
+	origin = strings.TrimSuffix(origin, ".")
+	entries, err := readEntries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	var lastName string
+	ttl := defaultTTL
+	for _, e := range entries {
+		fields := e.fields
+		first := fields[0]
+
+		if !first.quoted && strings.HasPrefix(first.text, "$") {
+			switch strings.ToUpper(first.text) {
+			case "$ORIGIN":
+				if len(fields) != 2 {
+					return nil, &ParseError{e.line, "$ORIGIN takes one argument"}
+				}
+				origin = absolute(fields[1].text, origin)
+			case "$TTL":
+				if len(fields) != 2 {
+					return nil, &ParseError{e.line, "$TTL takes one argument"}
+				}
+				v, err := parseTTL(fields[1].text)
+				if err != nil {
+					return nil, &ParseError{e.line, err.Error()}
+				}
+				ttl = v
+			default:
+				return nil, &ParseError{e.line, "unsupported directive " + first.text}
+			}
+			continue
+		}
+
+		rec := Record{Origin: origin, TTL: ttl, Class: "IN", Comment: e.comment, Line: e.line}
+		if e.continued {
+			// Owner omitted: the line started with whitespace
+			if lastName == "" {
+				return nil, &ParseError{e.line, "record without an owner name"}
+			}
+			rec.Name = lastName
+		} else {
+			rec.Name = absolute(first.text, origin)
+			fields = fields[1:]
+		}
+		lastName = rec.Name
+
+		// TTL and class may appear in either order before the type
+		for i := 0; i < 2 && len(fields) > 0; i++ {
+			word := fields[0].text
+			if classes[strings.ToUpper(word)] {
+				rec.Class = strings.ToUpper(word)
+				fields = fields[1:]
+			} else if v, err := parseTTL(word); err == nil && word[0] >= '0' && word[0] <= '9' {
+				rec.TTL = v
+				fields = fields[1:]
+			}
+		}
+		if len(fields) == 0 {
+			return nil, &ParseError{e.line, "missing record type"}
+		}
+		rec.Type = strings.ToUpper(fields[0].text)
+		for _, f := range fields[1:] {
+			rec.RData = append(rec.RData, f.text)
+		}
+		if len(rec.RData) == 0 {
+			return nil, &ParseError{e.line, "missing record data for " + rec.Type}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Absolute resolves a name from a zone file against origin ("@" is the
+// origin itself, names ending in a dot are already absolute).
+func Absolute(name, origin string) string {
+	return absolute(name, strings.TrimSuffix(origin, "."))
+}
+
+func absolute(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin == "":
+		return name
+	}
+	return name + "." + origin
+}
+
+var ttlUnits = map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+
+// parseTTL accepts seconds or BIND units (1h30m, 2d, 1w).
+func parseTTL(s string) (uint32, error) {
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+	var total, n uint64
+	digits := false
+	for _, ch := range strings.ToLower(s) {
+		if ch >= '0' && ch <= '9' {
+			n = n*10 + uint64(ch-'0')
+			digits = true
+			continue
+		}
+		unit, ok := ttlUnits[ch]
+		if !ok || !digits {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		total, n, digits = total+n*unit, 0, false
+	}
+	if s == "" || digits || total > 1<<31-1 {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return uint32(total), nil
+}
+
+type field struct {
+	text   string
+	quoted bool
+}
+
+type entry struct {
+	fields    []field
+	continued bool // Line started with whitespace (owner omitted)
+	comment   string
+	line      int
+}
+
+// readEntries splits the file into logical entries, joining lines inside
+// parentheses and removing comments.
+func readEntries(r io.Reader) ([]entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var entries []entry
+	var cur *entry
+	depth := 0
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if cur == nil {
+			cur = &entry{line: lineNo, continued: line != "" && (line[0] == ' ' || line[0] == '\t')}
+		}
+
+		for i := 0; i < len(line); {
+			ch := line[i]
+			switch {
+			case ch == ' ' || ch == '\t' || ch == '\r':
+				i++
+			case ch == ';':
+				comment := strings.TrimSpace(line[i+1:])
+				if cur.comment != "" && comment != "" {
+					comment = cur.comment + " " + comment
+				} else if comment == "" {
+					comment = cur.comment
+				}
+				cur.comment = comment
+				i = len(line)
+			case ch == '(':
+				depth++
+				i++
+			case ch == ')':
+				if depth == 0 {
+					return nil, &ParseError{lineNo, "unbalanced )"}
+				}
+				depth--
+				i++
+			case ch == '"':
+				var b strings.Builder
+				j := i + 1
+				for ; j < len(line) && line[j] != '"'; j++ {
+					if line[j] == '\\' && j+1 < len(line) {
+						j++
+						if line[j] >= '0' && line[j] <= '9' && j+2 < len(line) {
+							if v, err := strconv.Atoi(line[j : j+3]); err == nil && v < 256 {
+								b.WriteByte(byte(v))
+								j += 2
+								continue
+							}
+						}
+					}
+					b.WriteByte(line[j])
+				}
+				if j >= len(line) {
+					return nil, &ParseError{lineNo, "unterminated quoted string"}
+				}
+				cur.fields = append(cur.fields, field{text: b.String(), quoted: true})
+				i = j + 1
+			default:
+				j := i
+				for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+					if line[j] == '\\' && j+1 < len(line) {
+						j++
+					}
+					j++
+				}
+				cur.fields = append(cur.fields, field{text: line[i:j]})
+				i = j
+			}
+		}
+
+		if depth == 0 {
+			if len(cur.fields) > 0 {
+				entries = append(entries, *cur)
+			}
+			cur = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, &ParseError{lineNo, "unbalanced ("}
+	}
+	return entries, nil
+}
+
+// Quote returns s as one or more quoted character-strings of at most 255
+// bytes, the form TXT data takes in a zone file.
+func Quote(s string) string {
+	var parts []string
+	for {
+		chunk := s
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		var b strings.Builder
+		b.WriteByte('"')
+		for i := 0; i < len(chunk); i++ {
+			switch c := chunk[i]; {
+			case c == '"' || c == '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c < 0x20 || c > 0x7e:
+				fmt.Fprintf(&b, "\\%03d", c)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+		parts = append(parts, b.String())
+		s = s[len(chunk):]
+		if s == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// Write prints records relative to origin, one per line. RData is written
+// as is, so strings must already be quoted (see Quote).
+func Write(w io.Writer, origin string, defaultTTL uint32, records []Record) error {
+	origin = strings.TrimSuffix(origin, ".")
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %s.\n$TTL %d\n", origin, defaultTTL)
+	for _, r := range records {
+		fmt.Fprintf(bw, "%s\t%d\t%s\t%s\t%s", relative(r.Name, origin), r.TTL, classOrIN(r.Class), r.Type, strings.Join(r.RData, " "))
+		if r.Comment != "" {
+			fmt.Fprintf(bw, " ; %s", r.Comment)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func relative(name, origin string) string {
+	switch {
+	case strings.EqualFold(name, origin):
+		return "@"
+	case origin != "" && strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(origin)):
+		return name[:len(name)-len(origin)-1]
+	}
+	return name + "."
+}
+
+func classOrIN(class string) string {
+	if class == "" {
+		return "IN"
+	}
+	return class
+}
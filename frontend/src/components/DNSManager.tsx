@@ -5,6 +5,9 @@ import axios from 'axios';
 import Cookies from 'js-cookie';
 import { DNSRecord, CloudflareResponse } from '../types/api';
 
+// Only these types can be served through Cloudflare's proxy
+const PROXIABLE_TYPES = ['A', 'AAAA', 'CNAME'];
+
 export default function DNSManager() {
   const [records, setRecords] = useState<DNSRecord[]>([]);
   const [loading, setLoading] = useState(true);
@@ -18,8 +21,10 @@ export default function DNSManager() {
     name: '',
     content: '',
     proxied: true,
-    ttl: 1
+    ttl: 1,
+    priority: 10
   });
+  const proxiable = PROXIABLE_TYPES.includes(formData.type);
 
   const getAuthHeader = () => {
     const token = Cookies.get('auth_token');
@@ -77,7 +82,12 @@ export default function DNSManager() {
         ? `/api/cloudflare/record/${editingRecord.id}`
         : '/api/cloudflare/add-record';
       
-      const payload = { ...formData };
+      const { priority, ...fields } = formData;
+      const payload = {
+        ...fields,
+        proxied: proxiable && formData.proxied,
+        ...(formData.type === 'MX' ? { priority } : {})
+      };
       
       if (isEdit) {
          await axios.put(url, payload, getAuthHeader());
@@ -100,7 +110,8 @@ export default function DNSManager() {
         name: record.name,
         content: record.content,
         proxied: record.proxied,
-        ttl: record.ttl
+        ttl: record.ttl,
+        priority: record.priority ?? 10
       });
     } else {
       setEditingRecord(null);
@@ -109,7 +120,8 @@ export default function DNSManager() {
         name: '',
         content: '',
         proxied: true,
-        ttl: 1
+        ttl: 1,
+        priority: 10
       });
     }
     setIsModalOpen(true);
@@ -205,7 +217,10 @@ export default function DNSManager() {
                 <select 
                   className="w-full bg-slate-50 dark:bg-slate-900 border border-slate-300 dark:border-slate-600 rounded-md p-2 text-sm focus:ring-2 focus:ring-blue-500 outline-none"
                   value={formData.type}
-                  onChange={(e) => setFormData({...formData, type: e.target.value})}
+                  onChange={(e) => {
+                    const type = e.target.value;
+                    setFormData({...formData, type, proxied: PROXIABLE_TYPES.includes(type) && formData.proxied});
+                  }}
                 >
                   {['A', 'AAAA', 'CNAME', 'TXT', 'MX', 'NS'].map(t => (
                     <option key={t} value={t}>{t}</option>
@@ -238,6 +253,22 @@ export default function DNSManager() {
                 />
               </div>
 
+              {formData.type === 'MX' && (
+                <div>
+                  <label className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">Priority</label>
+                  <input 
+                    type="number"
+                    min={0}
+                    max={65535}
+                    className="w-full bg-slate-50 dark:bg-slate-900 border border-slate-300 dark:border-slate-600 rounded-md p-2 text-sm focus:ring-2 focus:ring-blue-500 outline-none"
+                    value={formData.priority}
+                    onChange={(e) => setFormData({...formData, priority: parseInt(e.target.value) || 0})}
+                    required
+                  />
+                  <p className="text-xs text-slate-500 mt-1">Lower values are tried first</p>
+                </div>
+              )}
+
               <div>
                 <label className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">TTL</label>
                 <select 
@@ -256,12 +287,13 @@ export default function DNSManager() {
                 <input 
                   type="checkbox"
                   id="proxied"
-                  checked={formData.proxied}
+                  checked={proxiable && formData.proxied}
+                  disabled={!proxiable}
                   onChange={(e) => setFormData({...formData, proxied: e.target.checked})}
-                  className="w-4 h-4 rounded text-blue-600 focus:ring-blue-500"
+                  className="w-4 h-4 rounded text-blue-600 focus:ring-blue-500 disabled:opacity-50"
                 />
-                <label htmlFor="proxied" className="text-sm font-medium text-slate-700 dark:text-slate-300 cursor-pointer">
-                   Proxy through Cloudflare (CDN)
+                <label htmlFor="proxied" className={`text-sm font-medium text-slate-700 dark:text-slate-300 ${proxiable ? 'cursor-pointer' : 'opacity-50'}`}>
+                   Proxy through Cloudflare (CDN){!proxiable && ` (not available for ${formData.type} records)`}
                 </label>
               </div>
 
@@ -9,6 +9,7 @@ export default function NginxEditor() {
   const [selectedFile, setSelectedFile] = useState<string | null>(null);
   const [content, setContent] = useState('');
   const [originalContent, setOriginalContent] = useState('');
+  const [etag, setEtag] = useState('');
   const [loading, setLoading] = useState(false);
   const [saving, setSaving] = useState(false);
   const [status, setStatus] = useState<{ type: 'success' | 'error', msg: string } | null>(null);
@@ -34,6 +35,7 @@ export default function NginxEditor() {
       const res = await axios.get(`${API_URL}/nginx/file?name=${name}`);
       setContent(res.data.content);
       setOriginalContent(res.data.content);
+      setEtag(res.data.etag);
     } catch (err) {
       console.error(err);
       setStatus({ type: 'error', msg: 'Failed to load file content.' });
@@ -50,13 +52,14 @@ export default function NginxEditor() {
       const res = await axios.post(`${API_URL}/nginx/file`, {
         name: selectedFile,
         content: content
-      });
+      }, { headers: { 'If-Match': `"${etag}"` } });
       
       // Backend returns status success or error inside 200 OK sometimes depending on logic, 
       // but we implemented explicit 400 for validation error.
       
       setStatus({ type: 'success', msg: res.data.message });
       setOriginalContent(content);
+      setEtag(res.data.etag);
     } catch (err: any) {
       const msg = err.response?.data?.message || err.response?.data?.error || 'Failed to save.';
       const details = err.response?.data?.details || '';
@@ -73,6 +73,7 @@ export interface DNSRecord {
   content: string;
   proxied: boolean;
   ttl: number;
+  priority?: number; // MX only
   zone_name?: string;
 }
 